@@ -6,14 +6,23 @@ import (
 	"balance_api/internal/usecase"
 	"balance_api/internal/usecase/report"
 	"balance_api/internal/usecase/repository"
+	"balance_api/internal/worker"
 	"balance_api/pkg/httpserver"
 	"balance_api/pkg/logger"
+	"balance_api/pkg/migrate"
 	"balance_api/pkg/postgres"
+	"balance_api/pkg/s3"
+	"balance_api/schema"
+	"context"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	// report time zones are loaded by name, alpine image has no tz database
+	_ "time/tzdata"
 )
 
 // @title           Balance API
@@ -34,17 +43,59 @@ func main() {
 		log.Fatalf("failed to build logger: %s", err)
 	}
 
-	db, err := postgres.New(uri, postgres.MaxConn(cfg.PG.MaxConn))
+	db, err := postgres.New(uri,
+		postgres.MaxConn(cfg.PG.MaxConn),
+		postgres.MaxRetries(cfg.PG.TxRetries),
+		postgres.RetryBackoff(cfg.PG.TxBackoff),
+		postgres.OnRetry(func(attempt int, err error) {
+			l.Warnf("retrying transaction, attempt %d: %s", attempt, err)
+		}))
 	if err != nil {
 		l.Fatalf("failed to connect to db: %s", err)
 	}
 
-	r, err := report.New("reports/")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(db, os.Args[2:])
+		db.Close()
+		return
+	}
+	if cfg.PG.Migrate {
+		m, err := migrate.New(db.Pool, schema.Migrations,
+			migrate.Baseline(schema.BaselineVersion, schema.BaselineTable))
+		if err != nil {
+			l.Fatalf("failed to load migrations: %s", err)
+		}
+		applied, err := m.Up(context.Background())
+		for _, a := range applied {
+			l.Infof("applied migration %04d_%s", a.Version, a.Name)
+		}
+		if err != nil {
+			l.Fatalf("failed to migrate db: %s", err)
+		}
+	}
+
+	storage, err := newReportStorage(cfg)
 	if err != nil {
-		l.Fatalf("failed to create report folder: %s", err)
+		l.Fatalf("failed to set up report storage: %s", err)
+	}
+	r := report.New(storage)
+
+	useCase := usecase.New(repository.New(db), r, usecase.OrderTTL(cfg.Orders.TTL),
+		usecase.IdempotencyTTL(cfg.Orders.IdempotencyTTL),
+		usecase.ReportFormats(r.CSV(), r.JSON(), r.XLSX(), r.HTML()))
+
+	sweeper := worker.NewSweeper(useCase, l, cfg.Orders.SweepInterval)
+
+	if cfg.Reports.Workers > 0 {
+		useCase.StartReportJobs(cfg.Reports.Workers, func(err error) {
+			l.Error(err)
+		})
 	}
 
-	useCase := usecase.New(repository.New(db), r)
+	var reconciler *worker.Reconciler
+	if cfg.Reconcile.Interval > 0 {
+		reconciler = worker.NewReconciler(useCase, l, cfg.Reconcile.Interval)
+	}
 
 	handler := gin.New()
 	v1.NewRouter(handler, useCase, l)
@@ -64,4 +115,43 @@ func main() {
 	if err != nil {
 		l.Infof("server shutdown err: %s", err)
 	}
+	sweeper.Shutdown()
+	useCase.StopReportJobs()
+	if reconciler != nil {
+		reconciler.Shutdown()
+	}
+	stats := db.Stats()
+	l.Infof("transaction retries: %d, exhausted: %d", stats.Retries, stats.Exhausted)
+}
+
+// newReportStorage returns storage of report files set up by config: local dir or S3-compatible bucket
+// shared by all instances, both of them are created if don't exist yet
+func newReportStorage(cfg *config.Config) (report.Storage, error) {
+	switch cfg.Reports.Storage {
+	case "", "local":
+		dir := cfg.Reports.Dir
+		if dir == "" {
+			dir = "reports/"
+		}
+		return report.NewLocalStorage(dir)
+	case "s3":
+		c, err := s3.New(cfg.S3.Endpoint, cfg.S3.Bucket, cfg.S3.AccessKey, cfg.S3.SecretKey,
+			s3.Region(cfg.S3.Region), s3.PublicEndpoint(cfg.S3.PublicEndpoint))
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err = c.CreateBucket(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ttl := cfg.Reports.URLTTL
+		if ttl <= 0 {
+			ttl = time.Hour
+		}
+		return report.NewS3Storage(c, ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown report storage %q", cfg.Reports.Storage)
+	}
 }
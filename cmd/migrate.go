@@ -0,0 +1,78 @@
+package main
+
+import (
+	"balance_api/pkg/migrate"
+	"balance_api/pkg/postgres"
+	"balance_api/schema"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+const migrateUsage = `usage: app migrate <command>
+  up             apply all not applied migrations
+  down [n]       revert n last migrations, 1 by default
+  version        print the latest applied version
+  force <v>      mark migrations up to v as applied without running them`
+
+// runMigrate runs migrate command with given args and exits on error
+func runMigrate(db *postgres.Db, args []string) {
+	m, err := migrate.New(db.Pool, schema.Migrations,
+		migrate.Baseline(schema.BaselineVersion, schema.BaselineTable))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		printMigrations("applied", applied)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatal(migrateUsage)
+			}
+		}
+		reverted, err := m.Down(ctx, n)
+		printMigrations("reverted", reverted)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "version":
+		version, err := m.Version(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(version)
+	case "force":
+		if len(args) < 2 {
+			log.Fatal(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			log.Fatal(migrateUsage)
+		}
+		err = m.Force(ctx, version)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
+
+func printMigrations(action string, migrations []migrate.Migration) {
+	for _, m := range migrations {
+		fmt.Printf("%s %04d_%s\n", action, m.Version, m.Name)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"balance_api/config"
+	"balance_api/internal/entity"
+	"balance_api/internal/usecase"
+	"balance_api/internal/usecase/repository"
+	"balance_api/pkg/postgres"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Reconcile checks cached balance of every user against the ledger and user's operations once and prints users
+// with discrepancies, result is saved to db as the last reconciliation. Db params are taken from the same ENV as
+// the app uses, exit code is 1 if any discrepancy is found
+func main() {
+	format := flag.String("format", "json", "output format: json or csv")
+	flag.Parse()
+	if *format != "json" && *format != "csv" {
+		log.Fatalf("unknown format: %s", *format)
+	}
+
+	cfg := config.NewConfig()
+	db, err := postgres.New(config.DbParams(cfg), postgres.MaxConn(cfg.PG.MaxConn))
+	if err != nil {
+		log.Fatalf("failed to connect to db: %s", err)
+	}
+
+	res, err := usecase.New(repository.New(db), nil).Reconcile(context.Background())
+	db.Close()
+	if err != nil {
+		log.Fatalf("failed to reconcile: %s", err)
+	}
+
+	if *format == "csv" {
+		err = writeCSV(os.Stdout, res)
+	} else {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "    ")
+		err = e.Encode(res)
+	}
+	if err != nil {
+		log.Fatalf("failed to write result: %s", err)
+	}
+	if len(res.Discrepancies) > 0 {
+		os.Exit(1)
+	}
+}
+
+func writeCSV(w io.Writer, res entity.Reconciliation) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"user_id", "amount", "reserved", "ledger_amount", "ledger_reserved",
+		"operations_amount", "operations_reserved"})
+	if err != nil {
+		return err
+	}
+	for _, d := range res.Discrepancies {
+		err = cw.Write([]string{strconv.Itoa(d.UserID), d.Amount, d.Reserved, d.LedgerAmount, d.LedgerReserved,
+			d.OperationsAmount, d.OperationsReserved})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
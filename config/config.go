@@ -13,6 +13,10 @@ type (
 		HTTP
 		PG
 		Logger
+		Orders
+		Reconcile
+		Reports
+		S3
 	}
 	// HTTP -.
 	HTTP struct {
@@ -23,17 +27,46 @@ type (
 	}
 	// PG -.
 	PG struct {
-		Host    string
-		Port    string
-		User    string
-		Pwd     string
-		Name    string
-		MaxConn int
+		Host      string
+		Port      string
+		User      string
+		Pwd       string
+		Name      string
+		MaxConn   int
+		TxRetries int
+		TxBackoff time.Duration
+		Migrate   bool
 	}
 	// Logger -.
 	Logger struct {
 		Level string
 	}
+	// Orders -.
+	Orders struct {
+		TTL            time.Duration
+		SweepInterval  time.Duration
+		IdempotencyTTL time.Duration
+	}
+	// Reconcile -.
+	Reconcile struct {
+		Interval time.Duration
+	}
+	// Reports -.
+	Reports struct {
+		Workers int
+		Storage string
+		Dir     string
+		URLTTL  time.Duration
+	}
+	// S3 -.
+	S3 struct {
+		Endpoint       string
+		PublicEndpoint string
+		Region         string
+		Bucket         string
+		AccessKey      string
+		SecretKey      string
+	}
 )
 
 // NewConfig gets values from ENV
@@ -49,7 +82,24 @@ func NewConfig() *Config {
 	cfg.PG.Pwd = os.Getenv("DB_PWD")
 	cfg.PG.Name = os.Getenv("DB_NAME")
 	cfg.PG.MaxConn, _ = strconv.Atoi(os.Getenv("DB_MAXCONNS"))
+	cfg.PG.TxRetries, _ = strconv.Atoi(os.Getenv("DB_TX_RETRIES"))
+	cfg.PG.TxBackoff, _ = time.ParseDuration(os.Getenv("DB_TX_BACKOFF") + "ms")
+	cfg.PG.Migrate, _ = strconv.ParseBool(os.Getenv("DB_MIGRATE"))
 	cfg.Logger.Level = os.Getenv("LOG_LVL")
+	cfg.Orders.TTL, _ = time.ParseDuration(os.Getenv("ORDER_TTL") + "s")
+	cfg.Orders.SweepInterval, _ = time.ParseDuration(os.Getenv("ORDER_SWEEP_INTERVAL") + "s")
+	cfg.Orders.IdempotencyTTL, _ = time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL") + "s")
+	cfg.Reconcile.Interval, _ = time.ParseDuration(os.Getenv("RECONCILE_INTERVAL") + "s")
+	cfg.Reports.Workers, _ = strconv.Atoi(os.Getenv("REPORT_WORKERS"))
+	cfg.Reports.Storage = os.Getenv("REPORT_STORAGE")
+	cfg.Reports.Dir = os.Getenv("REPORT_DIR")
+	cfg.Reports.URLTTL, _ = time.ParseDuration(os.Getenv("REPORT_URL_TTL") + "s")
+	cfg.S3.Endpoint = os.Getenv("S3_ENDPOINT")
+	cfg.S3.PublicEndpoint = os.Getenv("S3_PUBLIC_ENDPOINT")
+	cfg.S3.Region = os.Getenv("S3_REGION")
+	cfg.S3.Bucket = os.Getenv("S3_BUCKET")
+	cfg.S3.AccessKey = os.Getenv("S3_ACCESS_KEY")
+	cfg.S3.SecretKey = os.Getenv("S3_SECRET_KEY")
 	return cfg
 }
 
@@ -23,9 +23,41 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/reconciliation": {
+            "get": {
+                "description": "Returns result of the last check of users' cached balances against the ledger and users' operations\nmade by any instance or by cmd/reconcile, only users with discrepancies are listed",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "getReconciliation",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Reconciliation"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            }
+        },
         "/history": {
             "get": {
-                "description": "Returns user's transaction history",
+                "description": "Returns user's transaction history. Operations can be filtered by time, type, service, status, sum\nand searched by metadata key and its value. Type, service and status can be repeated to match any of them.\nPaged response has cursor of the next page, it can be passed with the same limit, order and filters\ninstead of page number. Page after the last one is empty. Every operation has balance after it:\nuser's available and reserved money right after the operation regardless of sort and filters",
                 "produces": [
                     "application/json"
                 ],
@@ -59,6 +91,12 @@ const docTemplate = `{
                         "name": "page",
                         "in": "query"
                     },
+                    {
+                        "type": "string",
+                        "description": "next page cursor from previous response, used instead of page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
                     {
                         "type": "boolean",
                         "example": true,
@@ -72,13 +110,97 @@ const docTemplate = `{
                         "description": "sort by",
                         "name": "order_by",
                         "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "operations made since, RFC3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "operations made before, RFC3339",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "enum": [
+                                "replenishment",
+                                "order",
+                                "refund",
+                                "transfer"
+                            ],
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "operation type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "service of orders and refunds",
+                        "name": "service_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "enum": [
+                                "pending",
+                                "approved",
+                                "canceled",
+                                "refunded",
+                                "partially_refunded",
+                                "expired"
+                            ],
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "operation status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "100",
+                        "description": "minimal sum",
+                        "name": "min_sum",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "500",
+                        "description": "maximal sum",
+                        "name": "max_sum",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "invoice",
+                        "description": "only operations with this metadata key",
+                        "name": "metadata_key",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "INV-42",
+                        "description": "only operations with this value of metadata key",
+                        "name": "metadata_value",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/entity.History"
+                            "$ref": "#/definitions/v1.historyResponse"
                         }
                     },
                     "400": {
@@ -96,35 +218,128 @@ const docTemplate = `{
                 }
             }
         },
-        "/order": {
-            "post": {
-                "description": "Creates, commits or rollbacks order",
-                "consumes": [
-                    "application/json"
-                ],
+        "/history/export": {
+            "get": {
+                "description": "Returns statement file of all user's operations sorted by date with available and reserved money\nright after every operation. Operations are filtered the same way as in history, statement is\nstreamed as it is read from database",
                 "produces": [
-                    "application/json"
+                    "text/plain"
                 ],
                 "tags": [
-                    "order"
+                    "history"
                 ],
-                "summary": "orderHandle",
+                "summary": "exportHistory",
                 "parameters": [
                     {
-                        "description": "order info",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/v1.orderPostRequest"
-                        }
+                        "minimum": 1,
+                        "type": "integer",
+                        "example": 1,
+                        "description": "user id",
+                        "name": "id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "csv",
+                            "jsonl",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "file format",
+                        "name": "format",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "operations made since, RFC3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "operations made before, RFC3339",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "enum": [
+                                "replenishment",
+                                "order",
+                                "refund",
+                                "transfer"
+                            ],
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "operation type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "service of orders and refunds",
+                        "name": "service_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "enum": [
+                                "pending",
+                                "approved",
+                                "canceled",
+                                "refunded",
+                                "partially_refunded",
+                                "expired"
+                            ],
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "operation status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "100",
+                        "description": "minimal sum",
+                        "name": "min_sum",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "500",
+                        "description": "maximal sum",
+                        "name": "max_sum",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "invoice",
+                        "description": "only operations with this metadata key",
+                        "name": "metadata_key",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "INV-42",
+                        "description": "only operations with this value of metadata key",
+                        "name": "metadata_value",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/v1.emptyJSONResponse"
+                            "type": "file"
                         }
                     },
                     "400": {
@@ -142,42 +357,41 @@ const docTemplate = `{
                 }
             }
         },
-        "/report": {
-            "get": {
-                "description": "Returns link to report file",
+        "/order": {
+            "post": {
+                "description": "Creates, commits, rollbacks or refunds order. Commit charges capture sum (whole sum if it is empty),\nthe rest is returned to user. Refund without amount returns the whole not refunded rest.\nPending order with ttl (in seconds) is canceled as expired when ttl is over.\nEvery status change is saved to order timeline with optional actor and reason.\nComment and metadata object (up to 20 keys) are saved on create",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "report"
+                    "order"
                 ],
-                "summary": "createReport",
+                "summary": "orderHandle",
                 "parameters": [
                     {
-                        "minimum": 1900,
-                        "type": "integer",
-                        "example": 2022,
-                        "description": "year",
-                        "name": "year",
-                        "in": "query",
-                        "required": true
+                        "description": "order info",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/v1.orderPostRequest"
+                        }
                     },
                     {
-                        "maximum": 12,
-                        "minimum": 1,
-                        "type": "integer",
-                        "example": 10,
-                        "description": "month",
-                        "name": "month",
-                        "in": "query",
-                        "required": true
+                        "type": "string",
+                        "description": "key for safe retries",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/v1.reportGetResponse"
+                            "$ref": "#/definitions/v1.emptyJSONResponse"
                         }
                     },
                     "400": {
@@ -186,6 +400,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/v1.response"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -195,21 +421,23 @@ const docTemplate = `{
                 }
             }
         },
-        "/reports/{name}": {
+        "/order/{id}": {
             "get": {
-                "description": "Returns report file",
+                "description": "Returns order with its user, service, sums, status and time of creation and last status change.\nEvents are the timeline of order status changes from old to new",
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "tags": [
-                    "report"
+                    "order"
                 ],
-                "summary": "getReport",
+                "summary": "getOrder",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "file name",
-                        "name": "name",
+                        "minimum": 1,
+                        "type": "integer",
+                        "example": 1,
+                        "description": "order id",
+                        "name": "id",
                         "in": "path",
                         "required": true
                     }
@@ -218,41 +446,113 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/v1.orderResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
                         }
                     }
                 }
             }
         },
-        "/user": {
+        "/orders": {
             "get": {
-                "description": "Returns user's balance",
+                "description": "Returns orders matching filters from new to old. Response has cursor of the next page if there is one",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "order"
                 ],
-                "summary": "getByID",
+                "summary": "getOrders",
                 "parameters": [
                     {
                         "minimum": 1,
                         "type": "integer",
-                        "example": 1,
                         "description": "user id",
-                        "name": "id",
-                        "in": "query",
-                        "required": true
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "minimum": 1,
+                        "type": "integer",
+                        "description": "service id",
+                        "name": "service_id",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "pending",
+                            "approved",
+                            "canceled",
+                            "refunded",
+                            "partially_refunded",
+                            "expired"
+                        ],
+                        "type": "string",
+                        "description": "order status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "orders created since, RFC3339",
+                        "name": "created_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "orders created before, RFC3339",
+                        "name": "created_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "orders changed since, RFC3339",
+                        "name": "modified_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "orders changed before, RFC3339",
+                        "name": "modified_to",
+                        "in": "query"
+                    },
+                    {
+                        "maximum": 200,
+                        "minimum": 1,
+                        "type": "integer",
+                        "description": "page size, 50 by default",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next page cursor from previous response",
+                        "name": "cursor",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/entity.Balance"
+                            "$ref": "#/definitions/v1.ordersResponse"
                         }
                     },
                     "400": {
@@ -268,28 +568,592 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "post": {
-                "description": "Makes new replenishment",
-                "consumes": [
-                    "application/json"
-                ],
+            }
+        },
+        "/report": {
+            "get": {
+                "description": "Creates report file of given format with header, total row, period, time zone and generation time\nand returns link to it. Report is made for a calendar month or for days from and to inclusive,\nwhich are taken in given time zone, UTC by default. Breakdown adds revenue and orders sum of every\nday, week starting on Monday or month. Rows are grouped by services, users or both, by services\nby default. Default format is csv",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "report"
+                ],
+                "summary": "createReport",
+                "parameters": [
+                    {
+                        "minimum": 1900,
+                        "type": "integer",
+                        "example": 2022,
+                        "description": "year, used with month",
+                        "name": "year",
+                        "in": "query"
+                    },
+                    {
+                        "maximum": 12,
+                        "minimum": 1,
+                        "type": "integer",
+                        "example": 10,
+                        "description": "month, used with year",
+                        "name": "month",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "2022-07-01",
+                        "description": "first day, used with to",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "2022-09-30",
+                        "description": "last day, used with from",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "Europe/Moscow",
+                        "description": "IANA time zone",
+                        "name": "time_zone",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "day",
+                            "week",
+                            "month"
+                        ],
+                        "type": "string",
+                        "description": "breakdown period",
+                        "name": "breakdown",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "enum": [
+                                "service",
+                                "user"
+                            ],
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "grouping of rows",
+                        "name": "group_by",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "csv",
+                            "json",
+                            "xlsx",
+                            "html"
+                        ],
+                        "type": "string",
+                        "description": "file format",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/v1.reportGetResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/reports": {
+            "get": {
+                "description": "Returns known report files, the latest generated first, with their size and sha256 checksum",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "report"
+                ],
+                "summary": "getReports",
+                "parameters": [
+                    {
+                        "maximum": 200,
+                        "minimum": 1,
+                        "type": "integer",
+                        "description": "max number of reports, 50 by default",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/v1.savedReportResponse"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Queues generation of report file in background, takes the same parameters as GET /report.\nStatus of job is returned by link in Location header, done job has a link to the file",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "report"
+                ],
+                "summary": "createReportJob",
+                "parameters": [
+                    {
+                        "description": "month or days, time zone, breakdown, grouping and format",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/v1.reportRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/v1.reportJobResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/reports/jobs/{id}": {
+            "get": {
+                "description": "Returns status of report job: queued, running, done or failed, progress in percents and\nlink to the file of done job or reason of failed one",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "report"
+                ],
+                "summary": "getReportJob",
+                "parameters": [
+                    {
+                        "minimum": 1,
+                        "type": "integer",
+                        "example": 1,
+                        "description": "job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/v1.reportJobResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/reports/{name}": {
+            "get": {
+                "description": "Returns known report file with content type of its format, or redirects to pre-signed link to it\nwhen reports are kept in S3-compatible storage. ETag is a sha256 checksum of the file, request\nwith matching If-None-Match gets 304, Range header is supported for files kept on disk",
+                "produces": [
+                    "text/plain",
+                    "application/json",
+                    "text/html",
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "report"
+                ],
+                "summary": "getReport",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "file name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of cached file",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "example": "bytes=0-99",
+                        "description": "byte range",
+                        "name": "Range",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "302": {
+                        "description": "Found"
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/services": {
+            "get": {
+                "description": "Returns all services, deactivated ones too",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "services"
+                ],
+                "summary": "getServices",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/entity.Service"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Creates new active service",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "services"
+                ],
+                "summary": "createService",
+                "parameters": [
+                    {
+                        "description": "service name",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/v1.servicePostRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Service"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/services/{id}": {
+            "patch": {
+                "description": "Renames, deactivates or activates service. Deactivated service can't be used for new orders,\nits existing orders and reports stay as they are",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "services"
+                ],
+                "summary": "updateService",
+                "parameters": [
+                    {
+                        "minimum": 1,
+                        "type": "integer",
+                        "example": 1,
+                        "description": "service id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "new name or state, at least one of them",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/v1.servicePatchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Service"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/transfer": {
+            "post": {
+                "description": "Transfers money from one user to another",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "transfer",
+                "parameters": [
+                    {
+                        "description": "sender, receiver, amount and comment",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/v1.transferPostRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "key for safe retries",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/v1.emptyJSONResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/user": {
+            "get": {
+                "description": "Returns user's balance: available amount, money reserved by pending orders, their total,\ncount of pending orders and time of last replenishment and order.\nIf at is set, balance is reconstructed as it was at that moment",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "getByID",
+                "parameters": [
+                    {
+                        "minimum": 1,
+                        "type": "integer",
+                        "example": 1,
+                        "description": "user id",
+                        "name": "id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "2022-10-24T13:00:00Z",
+                        "description": "moment in RFC3339",
+                        "name": "at",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Balance"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Makes new replenishment with optional comment and metadata object (up to 20 keys)",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
                 ],
                 "summary": "increaseAmount",
                 "parameters": [
                     {
-                        "description": "user id and amount",
+                        "description": "user id, amount, comment and metadata",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
                             "$ref": "#/definitions/v1.userPostRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "key for safe retries",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -305,6 +1169,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/v1.response"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/v1.response"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -324,19 +1200,64 @@ const docTemplate = `{
                 },
                 "id": {
                     "type": "integer"
+                },
+                "last_order": {
+                    "$ref": "#/definitions/entity.MyTime"
+                },
+                "last_replenishment": {
+                    "$ref": "#/definitions/entity.MyTime"
+                },
+                "pending_orders": {
+                    "type": "integer"
+                },
+                "reserved": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.BalanceAfter": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "type": "string"
+                },
+                "reserved": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.BalanceCheck": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "string"
+                },
+                "ledger_amount": {
+                    "type": "string"
+                },
+                "ledger_reserved": {
+                    "type": "string"
+                },
+                "operations_amount": {
+                    "type": "string"
+                },
+                "operations_reserved": {
+                    "type": "string"
+                },
+                "reserved": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
                 }
             }
         },
-        "entity.History": {
+        "entity.Metadata": {
             "type": "object",
-            "properties": {
-                "orders": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/entity.Order"
-                    }
-                }
-            }
+            "additionalProperties": true
         },
         "entity.MyTime": {
             "type": "object",
@@ -349,6 +1270,18 @@ const docTemplate = `{
         "entity.Order": {
             "type": "object",
             "properties": {
+                "balance_after": {
+                    "$ref": "#/definitions/entity.BalanceAfter"
+                },
+                "captured": {
+                    "type": "string"
+                },
+                "comment": {
+                    "type": "string"
+                },
+                "metadata": {
+                    "$ref": "#/definitions/entity.Metadata"
+                },
                 "service": {
                     "type": "string"
                 },
@@ -363,9 +1296,85 @@ const docTemplate = `{
                 }
             }
         },
+        "entity.Reconciliation": {
+            "type": "object",
+            "properties": {
+                "discrepancies": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.BalanceCheck"
+                    }
+                },
+                "finished": {
+                    "type": "string"
+                },
+                "started": {
+                    "type": "string"
+                },
+                "users": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.Service": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
         "v1.emptyJSONResponse": {
             "type": "object"
         },
+        "v1.historyResponse": {
+            "type": "object",
+            "properties": {
+                "next_cursor": {
+                    "type": "string"
+                },
+                "orders": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.Order"
+                    }
+                }
+            }
+        },
+        "v1.orderEventResponse": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string",
+                    "example": "api:support"
+                },
+                "amount": {
+                    "type": "string",
+                    "example": "150.00"
+                },
+                "from": {
+                    "type": "string",
+                    "example": "Pending"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "customer request"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "to": {
+                    "type": "string",
+                    "example": "Approved"
+                }
+            }
+        },
         "v1.orderPostRequest": {
             "type": "object",
             "required": [
@@ -381,15 +1390,42 @@ const docTemplate = `{
                     "enum": [
                         "create",
                         "approve",
-                        "cancel"
+                        "cancel",
+                        "refund"
                     ],
                     "example": "create"
                 },
+                "actor": {
+                    "type": "string",
+                    "maxLength": 60,
+                    "example": "support"
+                },
+                "capture": {
+                    "type": "string",
+                    "example": "150"
+                },
+                "comment": {
+                    "type": "string",
+                    "maxLength": 255,
+                    "example": "rent for October"
+                },
+                "metadata": {
+                    "$ref": "#/definitions/entity.Metadata"
+                },
                 "order_id": {
                     "type": "integer",
                     "minimum": 1,
                     "example": 1
                 },
+                "reason": {
+                    "type": "string",
+                    "maxLength": 255,
+                    "example": "customer request"
+                },
+                "refund": {
+                    "type": "string",
+                    "example": "50"
+                },
                 "service_id": {
                     "type": "integer",
                     "minimum": 1,
@@ -399,6 +1435,11 @@ const docTemplate = `{
                     "type": "string",
                     "example": "200"
                 },
+                "ttl": {
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 3600
+                },
                 "user_id": {
                     "type": "integer",
                     "minimum": 1,
@@ -406,6 +1447,79 @@ const docTemplate = `{
                 }
             }
         },
+        "v1.orderResponse": {
+            "type": "object",
+            "properties": {
+                "captured": {
+                    "type": "string",
+                    "example": "150.00"
+                },
+                "comment": {
+                    "type": "string",
+                    "example": "rent for October"
+                },
+                "created": {
+                    "type": "string"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/v1.orderEventResponse"
+                    }
+                },
+                "expires": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "metadata": {
+                    "$ref": "#/definitions/entity.Metadata"
+                },
+                "modified": {
+                    "type": "string"
+                },
+                "refunded": {
+                    "type": "string",
+                    "example": "0.00"
+                },
+                "service": {
+                    "type": "string",
+                    "example": "Rent"
+                },
+                "service_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "status": {
+                    "type": "string",
+                    "example": "Approved"
+                },
+                "sum": {
+                    "type": "string",
+                    "example": "200.00"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "v1.ordersResponse": {
+            "type": "object",
+            "properties": {
+                "next": {
+                    "type": "string"
+                },
+                "orders": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/v1.orderResponse"
+                    }
+                }
+            }
+        },
         "v1.reportGetResponse": {
             "type": "object",
             "properties": {
@@ -414,6 +1528,122 @@ const docTemplate = `{
                 }
             }
         },
+        "v1.reportJobResponse": {
+            "type": "object",
+            "properties": {
+                "breakdown": {
+                    "type": "string",
+                    "example": "month"
+                },
+                "created": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string",
+                    "example": "no any operations in this period"
+                },
+                "finished": {
+                    "type": "string"
+                },
+                "format": {
+                    "type": "string",
+                    "example": "csv"
+                },
+                "group_by": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "user"
+                    ]
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "link": {
+                    "type": "string",
+                    "example": "localhost:8080/v1/reports/2022-10.csv"
+                },
+                "period": {
+                    "type": "string",
+                    "example": "2022-07-01/2022-09-30"
+                },
+                "progress": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "started": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "done"
+                },
+                "time_zone": {
+                    "type": "string",
+                    "example": "Europe/Moscow"
+                }
+            }
+        },
+        "v1.reportRequest": {
+            "type": "object",
+            "properties": {
+                "breakdown": {
+                    "type": "string",
+                    "enum": [
+                        "day",
+                        "week",
+                        "month"
+                    ],
+                    "example": "month"
+                },
+                "format": {
+                    "type": "string",
+                    "enum": [
+                        "csv",
+                        "json",
+                        "xlsx",
+                        "html"
+                    ],
+                    "example": "csv"
+                },
+                "from": {
+                    "type": "string",
+                    "example": "2022-07-01"
+                },
+                "group_by": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "user"
+                    ]
+                },
+                "month": {
+                    "type": "integer",
+                    "maximum": 12,
+                    "minimum": 1,
+                    "example": 10
+                },
+                "time_zone": {
+                    "type": "string",
+                    "maxLength": 64,
+                    "example": "Europe/Moscow"
+                },
+                "to": {
+                    "type": "string",
+                    "example": "2022-09-30"
+                },
+                "year": {
+                    "type": "integer",
+                    "minimum": 1900,
+                    "example": 2022
+                }
+            }
+        },
         "v1.response": {
             "type": "object",
             "properties": {
@@ -422,6 +1652,96 @@ const docTemplate = `{
                 }
             }
         },
+        "v1.savedReportResponse": {
+            "type": "object",
+            "properties": {
+                "checksum": {
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+                },
+                "created": {
+                    "type": "string"
+                },
+                "format": {
+                    "type": "string",
+                    "example": "csv"
+                },
+                "link": {
+                    "type": "string",
+                    "example": "localhost:8080/v1/reports/2022-10.csv"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "2022-10.csv"
+                },
+                "period": {
+                    "type": "string",
+                    "example": "2022-10"
+                },
+                "size": {
+                    "type": "integer",
+                    "example": 1024
+                },
+                "time_zone": {
+                    "type": "string",
+                    "example": "UTC"
+                }
+            }
+        },
+        "v1.servicePatchRequest": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "name": {
+                    "type": "string",
+                    "example": "Delivery"
+                }
+            }
+        },
+        "v1.servicePostRequest": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "Delivery"
+                }
+            }
+        },
+        "v1.transferPostRequest": {
+            "type": "object",
+            "required": [
+                "amount",
+                "from_id",
+                "to_id"
+            ],
+            "properties": {
+                "amount": {
+                    "type": "string",
+                    "example": "100"
+                },
+                "comment": {
+                    "type": "string",
+                    "maxLength": 255,
+                    "example": "for dinner"
+                },
+                "from_id": {
+                    "type": "integer",
+                    "minimum": 1,
+                    "example": 1
+                },
+                "to_id": {
+                    "type": "integer",
+                    "minimum": 1,
+                    "example": 2
+                }
+            }
+        },
         "v1.userPostRequest": {
             "type": "object",
             "required": [
@@ -433,10 +1753,18 @@ const docTemplate = `{
                     "type": "string",
                     "example": "200"
                 },
+                "comment": {
+                    "type": "string",
+                    "maxLength": 255,
+                    "example": "credited by card"
+                },
                 "id": {
                     "type": "integer",
                     "minimum": 1,
                     "example": 1
+                },
+                "metadata": {
+                    "$ref": "#/definitions/entity.Metadata"
                 }
             }
         }
@@ -0,0 +1,47 @@
+package v1
+
+import (
+	"balance_api/internal/entity"
+	"balance_api/internal/usecase"
+	"balance_api/pkg/logger"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type adminRouters struct {
+	b usecase.Balance
+	l logger.Interface
+}
+
+func newAdminRoutes(handler *gin.RouterGroup, b usecase.Balance, l logger.Interface) {
+	r := &adminRouters{
+		b: b,
+		l: l,
+	}
+
+	handler.GET("/reconciliation", r.getReconciliation)
+}
+
+// @Summary     getReconciliation
+// @Description Returns result of the last check of users' cached balances against the ledger and users' operations
+// @Description made by any instance or by cmd/reconcile, only users with discrepancies are listed
+// @Tags  	    admin
+// @Produce     json
+// @Success     200 {object} entity.Reconciliation
+// @Failure     404 {object} response
+// @Failure     500 {object} response
+// @Router      /admin/reconciliation [get]
+func (r *adminRouters) getReconciliation(c *gin.Context) {
+	res, err := r.b.GetReconciliation(c.Request.Context())
+	switch {
+	case errors.Is(err, entity.ErrNoReconciliation):
+		errorResponse(c, http.StatusNotFound, "Reconciliation wasn't run yet")
+		return
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,60 @@
+package v1
+
+import (
+	"balance_api/internal/entity"
+	ucmock "balance_api/internal/mocks/usecase"
+	"balance_api/pkg/logger"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReconciliation(t *testing.T) {
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	res := entity.Reconciliation{Started: time.Unix(10, 0).UTC(), Finished: time.Unix(20, 0).UTC(), Users: 2,
+		Discrepancies: []entity.BalanceCheck{{UserID: 1, Amount: "100.00", Reserved: "0.00", LedgerAmount: "50.00",
+			LedgerReserved: "0.00", OperationsAmount: "100.00", OperationsReserved: "0.00"}}}
+	uc.On("GetReconciliation", mock.Anything).Return(entity.Reconciliation{}, entity.ErrNoReconciliation).Once()
+	uc.On("GetReconciliation", mock.Anything).Return(res, nil).Once()
+	uc.On("GetReconciliation", mock.Anything).Return(entity.Reconciliation{}, errors.New("aboba")).Once()
+
+	type testCases struct {
+		name    string
+		expCode int
+		resp    interface{}
+	}
+
+	cases := []testCases{{
+		name:    "not run yet",
+		expCode: http.StatusNotFound,
+		resp:    response{Msg: "Reconciliation wasn't run yet"},
+	}, {
+		name:    "valid",
+		expCode: http.StatusOK,
+		resp:    res,
+	}, {
+		name:    "db error",
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Database error"},
+	},
+	}
+
+	for _, tc := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "/v1/admin/reconciliation", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String(), tc.name)
+	}
+}
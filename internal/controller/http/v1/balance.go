@@ -4,11 +4,14 @@ import (
 	mw "balance_api/internal/controller/http/v1/middleware"
 	"balance_api/internal/entity"
 	"balance_api/internal/usecase"
+	"balance_api/pkg/httpserver"
 	"balance_api/pkg/logger"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/shopspring/decimal"
 	"net/http"
+	"time"
 )
 
 type balanceRouters struct {
@@ -26,29 +29,48 @@ func newBalanceRoutes(handler *gin.RouterGroup, b usecase.Balance, l logger.Inte
 	}
 
 	handler.GET("/user", mw.ValidateQuery[userGetRequest](r.l), r.getByID)
-	handler.POST("/user", mw.ValidateJSONBody[userPostRequest](r.l), r.increaseAmount)
-	handler.POST("/order", mw.ValidateJSONBody[orderPostRequest](r.l), r.orderHandle)
+	handler.POST("/user", r.idempotency, mw.ValidateJSONBody[userPostRequest](r.l), r.increaseAmount)
+	handler.POST("/order", r.idempotency, mw.ValidateJSONBody[orderPostRequest](r.l), r.orderHandle)
+	handler.GET("/order/:id", r.getOrder)
+	handler.GET("/orders", mw.ValidateQuery[ordersGetRequest](r.l), r.getOrders)
+	handler.POST("/transfer", r.idempotency, mw.ValidateJSONBody[transferPostRequest](r.l), r.transfer)
 	handler.GET("/history", mw.ValidateQuery[historyGetRequest](r.l), r.getHistory)
-	handler.GET("/report", mw.ValidateQuery[reportGetRequest](r.l), r.createReport)
+	handler.GET("/history/export", mw.ValidateQuery[historyExportRequest](r.l), r.exportHistory)
+	handler.GET("/report", mw.ValidateQuery[reportRequest](r.l), r.createReport)
+	handler.GET("/reports", mw.ValidateQuery[reportsGetRequest](r.l), r.getReports)
 	handler.GET("/reports/:name", r.getReport)
+	handler.POST("/reports", mw.ValidateJSONBody[reportRequest](r.l), r.createReportJob)
+	handler.GET("/reports/jobs/:id", r.getReportJob)
 }
 
 type userGetRequest struct {
-	ID int `form:"id" binding:"required,gte=1"`
+	ID int       `form:"id" binding:"required,gte=1"`
+	At time.Time `form:"at" time_format:"2006-01-02T15:04:05Z07:00"`
 }
 
 // @Summary     getByID
-// @Description Returns user's balance
+// @Description Returns user's balance: available amount, money reserved by pending orders, their total,
+// @Description count of pending orders and time of last replenishment and order.
+// @Description If at is set, balance is reconstructed as it was at that moment
 // @Tags  	    user
 // @Produce     json
 // @Param       id query int true "user id" minimum(1) example(1)
+// @Param       at query string false "moment in RFC3339" example(2022-10-24T13:00:00Z)
 // @Success     200 {object} entity.Balance
 // @Failure     400 {object} response
 // @Failure     500 {object} response
 // @Router      /user [get]
 func (r *balanceRouters) getByID(c *gin.Context) {
 	q := mw.GetQueryParams[userGetRequest](c)
-	balance, err := r.b.GetByID(c.Request.Context(), q.ID)
+	var (
+		balance entity.Balance
+		err     error
+	)
+	if q.At.IsZero() {
+		balance, err = r.b.GetByID(c.Request.Context(), q.ID)
+	} else {
+		balance, err = r.b.GetBalanceAt(c.Request.Context(), q.ID, q.At.UTC())
+	}
 	switch {
 	case errors.Is(err, entity.ErrNoID):
 		r.l.Infof("err \"%s\" with request params: %v", err, q)
@@ -63,18 +85,23 @@ func (r *balanceRouters) getByID(c *gin.Context) {
 }
 
 type userPostRequest struct {
-	ID     int    `json:"id" binding:"required,gte=1" example:"1"`
-	Amount string `json:"amount" binding:"required" example:"200"`
+	ID       int             `json:"id" binding:"required,gte=1" example:"1"`
+	Amount   string          `json:"amount" binding:"required" example:"200"`
+	Comment  string          `json:"comment,omitempty" binding:"omitempty,max=255" example:"credited by card"`
+	Metadata entity.Metadata `json:"metadata,omitempty" binding:"omitempty,max=20"`
 }
 
 // @Summary     increaseAmount
-// @Description Makes new replenishment
+// @Description Makes new replenishment with optional comment and metadata object (up to 20 keys)
 // @Tags  	    user
 // @Accept      json
 // @Produce     json
-// @Param       request body userPostRequest true "user id and amount"
+// @Param       request body userPostRequest true "user id, amount, comment and metadata"
+// @Param       Idempotency-Key header string false "key for safe retries"
 // @Success     200 {object} emptyJSONResponse
 // @Failure     400 {object} response
+// @Failure     409 {object} response
+// @Failure     422 {object} response
 // @Failure     500 {object} response
 // @Router      /user [post]
 func (r *balanceRouters) increaseAmount(c *gin.Context) {
@@ -85,7 +112,8 @@ func (r *balanceRouters) increaseAmount(c *gin.Context) {
 		errorResponse(c, http.StatusBadRequest, "Invalid money format")
 		return
 	}
-	err = r.b.Increase(c.Request.Context(), entity.Balance{ID: b.ID, Amount: b.Amount})
+	err = r.b.Increase(c.Request.Context(),
+		entity.Balance{ID: b.ID, Amount: b.Amount, Comment: b.Comment, Metadata: b.Metadata})
 	if err != nil {
 		r.l.Error(err)
 		errorResponse(c, http.StatusInternalServerError, "Database error")
@@ -95,21 +123,35 @@ func (r *balanceRouters) increaseAmount(c *gin.Context) {
 }
 
 type orderPostRequest struct {
-	Action    string `json:"action" binding:"required" enums:"create,approve,cancel" example:"create"`
-	ID        int    `json:"order_id" binding:"required,gte=1" example:"1"`
-	ServiceID int    `json:"service_id" binding:"required,gte=1" example:"1"`
-	UserID    int    `json:"user_id" binding:"required,gte=1" example:"1"`
-	Sum       string `json:"sum" binding:"required" example:"200"`
+	Action    string          `json:"action" binding:"required" enums:"create,approve,cancel,refund" example:"create"`
+	ID        int             `json:"order_id" binding:"required,gte=1" example:"1"`
+	ServiceID int             `json:"service_id" binding:"required,gte=1" example:"1"`
+	UserID    int             `json:"user_id" binding:"required,gte=1" example:"1"`
+	Sum       string          `json:"sum" binding:"required" example:"200"`
+	Capture   string          `json:"capture,omitempty" example:"150"`
+	Refund    string          `json:"refund,omitempty" example:"50"`
+	TTL       int             `json:"ttl,omitempty" binding:"omitempty,gte=0" example:"3600"`
+	Actor     string          `json:"actor,omitempty" binding:"omitempty,max=60" example:"support"`
+	Reason    string          `json:"reason,omitempty" binding:"omitempty,max=255" example:"customer request"`
+	Comment   string          `json:"comment,omitempty" binding:"omitempty,max=255" example:"rent for October"`
+	Metadata  entity.Metadata `json:"metadata,omitempty" binding:"omitempty,max=20"`
 }
 
 // @Summary     orderHandle
-// @Description Creates, commits or rollbacks order
+// @Description Creates, commits, rollbacks or refunds order. Commit charges capture sum (whole sum if it is empty),
+// @Description the rest is returned to user. Refund without amount returns the whole not refunded rest.
+// @Description Pending order with ttl (in seconds) is canceled as expired when ttl is over.
+// @Description Every status change is saved to order timeline with optional actor and reason.
+// @Description Comment and metadata object (up to 20 keys) are saved on create
 // @Tags  	    order
 // @Accept      json
 // @Produce     json
 // @Param       request body orderPostRequest true "order info"
+// @Param       Idempotency-Key header string false "key for safe retries"
 // @Success     200 {object} emptyJSONResponse
 // @Failure     400 {object} response
+// @Failure     409 {object} response
+// @Failure     422 {object} response
 // @Failure     500 {object} response
 // @Router      /order [post]
 func (r *balanceRouters) orderHandle(c *gin.Context) {
@@ -120,16 +162,38 @@ func (r *balanceRouters) orderHandle(c *gin.Context) {
 		errorResponse(c, http.StatusBadRequest, "Invalid money format")
 		return
 	}
+	for _, v := range []string{b.Capture, b.Refund} {
+		if v == "" {
+			continue
+		}
+		num, err = decimal.NewFromString(v)
+		if err != nil || !num.IsPositive() {
+			r.l.Infof("err \"%s\" with request params: %v", err, b)
+			errorResponse(c, http.StatusBadRequest, "Invalid money format")
+			return
+		}
+	}
+	actor := entity.ActorAPI
+	if b.Actor != "" {
+		actor += ":" + b.Actor
+	}
 	switch b.Action {
 	case "create":
 		err = r.b.CreateOrder(c.Request.Context(),
-			entity.Order{ID: b.ID, ServiceID: b.ServiceID, UserID: b.UserID, Sum: b.Sum})
+			entity.Order{ID: b.ID, ServiceID: b.ServiceID, UserID: b.UserID, Sum: b.Sum, TTL: b.TTL,
+				Comment: b.Comment, Metadata: b.Metadata, Actor: actor, Reason: b.Reason})
 	case "approve":
 		err = r.b.ChangeOrderStatus(c.Request.Context(),
-			entity.Order{ID: b.ID, ServiceID: b.ServiceID, UserID: b.UserID, Sum: b.Sum, StatusID: 2})
+			entity.Order{ID: b.ID, ServiceID: b.ServiceID, UserID: b.UserID, Sum: b.Sum, Captured: b.Capture,
+				StatusID: entity.StatusApproved, Actor: actor, Reason: b.Reason})
 	case "cancel":
 		err = r.b.ChangeOrderStatus(c.Request.Context(),
-			entity.Order{ID: b.ID, ServiceID: b.ServiceID, UserID: b.UserID, Sum: b.Sum, StatusID: 3})
+			entity.Order{ID: b.ID, ServiceID: b.ServiceID, UserID: b.UserID, Sum: b.Sum, StatusID: entity.StatusCanceled,
+				Actor: actor, Reason: b.Reason})
+	case "refund":
+		err = r.b.RefundOrder(c.Request.Context(),
+			entity.Order{ID: b.ID, ServiceID: b.ServiceID, UserID: b.UserID, Sum: b.Sum, Actor: actor,
+				Reason: b.Reason}, b.Refund)
 	default:
 		r.l.Infof("err \"wrong order action\" with request params: %v", b)
 		errorResponse(c, http.StatusBadRequest, "Invalid order action")
@@ -151,6 +215,63 @@ func (r *balanceRouters) orderHandle(c *gin.Context) {
 		errMsg = "Wrong order data"
 	case errors.Is(err, entity.ErrCantChangeStatus):
 		errMsg = "Order already approved/canceled"
+	case errors.Is(err, entity.ErrCaptureExceeds):
+		errMsg = "Capture exceeds order sum"
+	case errors.Is(err, entity.ErrCantRefund):
+		errMsg = "Order isn't approved"
+	case errors.Is(err, entity.ErrRefundExceeds):
+		errMsg = "Refund exceeds order sum"
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if err != nil {
+		r.l.Infof("err \"%s\" with request params: %v", err, b)
+		errorResponse(c, http.StatusBadRequest, errMsg)
+		return
+	}
+	c.JSON(http.StatusOK, emptyJSONResponse{})
+}
+
+type transferPostRequest struct {
+	FromID  int    `json:"from_id" binding:"required,gte=1" example:"1"`
+	ToID    int    `json:"to_id" binding:"required,gte=1" example:"2"`
+	Amount  string `json:"amount" binding:"required" example:"100"`
+	Comment string `json:"comment" binding:"omitempty,max=255" example:"for dinner"`
+}
+
+// @Summary     transfer
+// @Description Transfers money from one user to another
+// @Tags  	    user
+// @Accept      json
+// @Produce     json
+// @Param       request body transferPostRequest true "sender, receiver, amount and comment"
+// @Param       Idempotency-Key header string false "key for safe retries"
+// @Success     200 {object} emptyJSONResponse
+// @Failure     400 {object} response
+// @Failure     409 {object} response
+// @Failure     422 {object} response
+// @Failure     500 {object} response
+// @Router      /transfer [post]
+func (r *balanceRouters) transfer(c *gin.Context) {
+	b := mw.GetJSONBody[transferPostRequest](c)
+	num, err := decimal.NewFromString(b.Amount)
+	if err != nil || !num.IsPositive() {
+		r.l.Infof("err \"%s\" with request params: %v", err, b)
+		errorResponse(c, http.StatusBadRequest, "Invalid money format")
+		return
+	}
+	err = r.b.Transfer(c.Request.Context(),
+		entity.Transfer{FromID: b.FromID, ToID: b.ToID, Amount: b.Amount, Comment: b.Comment})
+	errMsg := ""
+	switch {
+	case errors.Is(err, entity.ErrSameUser):
+		errMsg = "Sender and receiver are the same"
+	case errors.Is(err, entity.ErrNoID):
+		errMsg = "No such id"
+	case errors.Is(err, entity.ErrNotEnoughMoney):
+		errMsg = "Not enough money"
 	case err != nil:
 		r.l.Error(err)
 		errorResponse(c, http.StatusInternalServerError, "Database error")
@@ -164,24 +285,65 @@ func (r *balanceRouters) orderHandle(c *gin.Context) {
 	c.JSON(http.StatusOK, emptyJSONResponse{})
 }
 
+// historyFilters are params of history shared by its pages and statements
+type historyFilters struct {
+	ID            int       `form:"id" binding:"required,gte=1"`
+	From          time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To            time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Type          []string  `form:"type" binding:"omitempty,dive,oneof=replenishment order refund transfer"`
+	ServiceID     []int     `form:"service_id" binding:"omitempty,dive,gte=1"`
+	Status        []string  `form:"status" binding:"omitempty,dive,oneof=pending approved canceled refunded partially_refunded expired"`
+	MinSum        string    `form:"min_sum"`
+	MaxSum        string    `form:"max_sum"`
+	MetadataKey   string    `form:"metadata_key" binding:"omitempty,max=255"`
+	MetadataValue string    `form:"metadata_value" binding:"omitempty,max=255"`
+}
+
 type historyGetRequest struct {
-	ID      int    `form:"id" binding:"required,gte=1"`
+	historyFilters
 	Limit   int    `form:"limit" binding:"omitempty,gte=0,lte=200"`
 	Page    int    `form:"page" binding:"omitempty,gte=1"`
 	Desc    bool   `form:"desc" binding:"omitempty"`
 	OrderBy string `form:"order_by" binding:"omitempty"`
+	Cursor  string `form:"cursor"`
+}
+
+var historyKinds = map[string][]int{
+	"replenishment": {entity.KindReplenishment},
+	"order":         {entity.KindOrder},
+	"refund":        {entity.KindRefund},
+	"transfer":      {entity.KindTransferOut, entity.KindTransferIn},
+}
+
+type historyResponse struct {
+	Orders     []entity.Order `json:"orders"`
+	NextCursor string         `json:"next_cursor,omitempty"`
 }
 
 // @Summary     getHistory
-// @Description Returns user's transaction history
+// @Description Returns user's transaction history. Operations can be filtered by time, type, service, status, sum
+// @Description and searched by metadata key and its value. Type, service and status can be repeated to match any of them.
+// @Description Paged response has cursor of the next page, it can be passed with the same limit, order and filters
+// @Description instead of page number. Page after the last one is empty. Every operation has balance after it:
+// @Description user's available and reserved money right after the operation regardless of sort and filters
 // @Tags  	    history
 // @Produce     json
 // @Param       id query int true "user id" minimum(1) example(1)
 // @Param       limit query int false "pagination limit" minimum(0) example(10)
 // @Param       page query int false "pagination page" minimum(1) example(1)
+// @Param       cursor query string false "next page cursor from previous response, used instead of page"
 // @Param       desc query bool false "descending sort" example(true)
 // @Param       order_by query string false "sort by" example(date)
-// @Success     200 {object} entity.History
+// @Param       from query string false "operations made since, RFC3339"
+// @Param       to query string false "operations made before, RFC3339"
+// @Param       type query []string false "operation type" Enums(replenishment, order, refund, transfer) collectionFormat(multi)
+// @Param       service_id query []int false "service of orders and refunds" collectionFormat(multi)
+// @Param       status query []string false "operation status" Enums(pending, approved, canceled, refunded, partially_refunded, expired) collectionFormat(multi)
+// @Param       min_sum query string false "minimal sum" example(100)
+// @Param       max_sum query string false "maximal sum" example(500)
+// @Param       metadata_key query string false "only operations with this metadata key" example(invoice)
+// @Param       metadata_value query string false "only operations with this value of metadata key" example(INV-42)
+// @Success     200 {object} historyResponse
 // @Failure     400 {object} response
 // @Failure     500 {object} response
 // @Router      /history [get]
@@ -193,29 +355,45 @@ func (r *balanceRouters) getHistory(c *gin.Context) {
 		errorResponse(c, http.StatusBadRequest, msg)
 		return
 	}
-	h, err := r.b.GetHistory(c.Request.Context(),
-		entity.History{UserID: q.ID, Limit: q.Limit, OrderBy: q.OrderBy, Desc: q.Desc, Page: q.Page})
+	after, err := decodeHistoryCursor(q.Cursor)
+	if err == nil && after != nil && (after.OrderBy != q.OrderBy || after.Desc != q.Desc) {
+		err = errWrongCursor
+	}
+	if err != nil {
+		r.l.Infof("err \"%s\" with request params: %v", err, q)
+		errorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	history := q.history()
+	history.Limit, history.OrderBy, history.Desc, history.Page, history.After = q.Limit, q.OrderBy, q.Desc, q.Page, after
+	h, err := r.b.GetHistory(c.Request.Context(), history)
 	switch {
 	case errors.Is(err, entity.ErrNoID):
 		r.l.Infof("err \"%s\" with request params: %v", err, q)
 		errorResponse(c, http.StatusBadRequest, "No such id")
 		return
-	case errors.Is(err, entity.ErrEmptyPage):
-		r.l.Infof("err \"%s\" with request params: %v", err, q)
-		errorResponse(c, http.StatusBadRequest, "The page is empty")
-		return
 	case err != nil:
 		r.l.Error(err)
 		errorResponse(c, http.StatusInternalServerError, "Database error")
 		return
 	}
-	c.JSON(http.StatusOK, h)
+	res := historyResponse{Orders: h.Orders, NextCursor: encodeHistoryCursor(h.Next)}
+	if res.Orders == nil {
+		res.Orders = []entity.Order{}
+	}
+	c.JSON(http.StatusOK, res)
 }
 
 func setHistoryParams(h historyGetRequest) (historyGetRequest, string) {
-	if (h.Limit == 0 && h.Page != 0) || (h.Limit != 0 && h.Page == 0) {
+	switch {
+	case h.Cursor != "" && (h.Limit == 0 || h.Page != 0):
+		return historyGetRequest{}, "Cursor needs limit and no page"
+	case h.Cursor == "" && ((h.Limit == 0 && h.Page != 0) || (h.Limit != 0 && h.Page == 0)):
 		return historyGetRequest{}, "Limit and page should be both zero or non zero"
 	}
+	if msg := h.check(); msg != "" {
+		return historyGetRequest{}, msg
+	}
 	switch h.OrderBy {
 	case "":
 		h.OrderBy = "date"
@@ -227,50 +405,211 @@ func setHistoryParams(h historyGetRequest) (historyGetRequest, string) {
 	return h, ""
 }
 
-type reportGetRequest struct {
-	Year  int `form:"year" binding:"required,gte=1900"`
-	Month int `form:"month" binding:"required,gte=1,lte=12"`
+// check returns message of the first wrong filter or empty string
+func (f historyFilters) check() string {
+	if f.MetadataValue != "" && f.MetadataKey == "" {
+		return "Metadata value needs metadata key"
+	}
+	for _, v := range []string{f.MinSum, f.MaxSum} {
+		if v == "" {
+			continue
+		}
+		if num, err := decimal.NewFromString(v); err != nil || num.IsNegative() {
+			return "Invalid money format"
+		}
+	}
+	return ""
+}
+
+// history makes entity.History with filters set
+func (f historyFilters) history() entity.History {
+	history := entity.History{UserID: f.ID, From: f.From.UTC(), To: f.To.UTC(), ServiceIDs: f.ServiceID,
+		MinSum: f.MinSum, MaxSum: f.MaxSum, MetadataKey: f.MetadataKey, MetadataValue: f.MetadataValue}
+	for _, t := range f.Type {
+		history.Kinds = append(history.Kinds, historyKinds[t]...)
+	}
+	for _, s := range f.Status {
+		history.StatusIDs = append(history.StatusIDs, orderStatuses[s])
+	}
+	return history
+}
+
+type historyExportRequest struct {
+	historyFilters
+	Format string `form:"format" binding:"required,oneof=csv jsonl xlsx"`
+}
+
+// exportWriteTimeout replaces server's write timeout for statements, they are streamed as long as they are read
+// from database
+const exportWriteTimeout = 10 * time.Minute
+
+var statementContentTypes = map[string]string{
+	"csv":   "text/csv",
+	"jsonl": "application/x-ndjson",
+	"xlsx":  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// @Summary     exportHistory
+// @Description Returns statement file of all user's operations sorted by date with available and reserved money
+// @Description right after every operation. Operations are filtered the same way as in history, statement is
+// @Description streamed as it is read from database
+// @Tags  	    history
+// @Produce     plain
+// @Param       id query int true "user id" minimum(1) example(1)
+// @Param       format query string true "file format" Enums(csv, jsonl, xlsx)
+// @Param       from query string false "operations made since, RFC3339"
+// @Param       to query string false "operations made before, RFC3339"
+// @Param       type query []string false "operation type" Enums(replenishment, order, refund, transfer) collectionFormat(multi)
+// @Param       service_id query []int false "service of orders and refunds" collectionFormat(multi)
+// @Param       status query []string false "operation status" Enums(pending, approved, canceled, refunded, partially_refunded, expired) collectionFormat(multi)
+// @Param       min_sum query string false "minimal sum" example(100)
+// @Param       max_sum query string false "maximal sum" example(500)
+// @Param       metadata_key query string false "only operations with this metadata key" example(invoice)
+// @Param       metadata_value query string false "only operations with this value of metadata key" example(INV-42)
+// @Success     200 {file} file
+// @Failure     400 {object} response
+// @Failure     500 {object} response
+// @Router      /history/export [get]
+func (r *balanceRouters) exportHistory(c *gin.Context) {
+	q := mw.GetQueryParams[historyExportRequest](c)
+	if msg := q.check(); msg != "" {
+		r.l.Infof("err \"%s\" with request params: %v", msg, q)
+		errorResponse(c, http.StatusBadRequest, msg)
+		return
+	}
+	c.Header("Content-Type", statementContentTypes[q.Format])
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"history-%d.%s\"", q.ID, q.Format))
+	httpserver.SetWriteDeadline(c.Request.Context(), time.Now().Add(exportWriteTimeout))
+	err := r.b.ExportHistory(c.Request.Context(), q.history(), q.Format, c.Writer)
+	if err == nil {
+		return
+	}
+	if c.Writer.Written() {
+		// statement is already being sent, the client gets it cut off
+		r.l.Error(err)
+		c.Abort()
+		return
+	}
+	c.Writer.Header().Del("Content-Type")
+	c.Writer.Header().Del("Content-Disposition")
+	switch {
+	case errors.Is(err, entity.ErrNoID):
+		r.l.Infof("err \"%s\" with request params: %v", err, q)
+		errorResponse(c, http.StatusBadRequest, "No such id")
+	default:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+	}
+}
+
+type reportRequest struct {
+	Year      int      `form:"year" json:"year" binding:"omitempty,gte=1900" example:"2022"`
+	Month     int      `form:"month" json:"month" binding:"omitempty,gte=1,lte=12" example:"10"`
+	From      string   `form:"from" json:"from" binding:"omitempty,datetime=2006-01-02" example:"2022-07-01"`
+	To        string   `form:"to" json:"to" binding:"omitempty,datetime=2006-01-02" example:"2022-09-30"`
+	TimeZone  string   `form:"time_zone" json:"time_zone" binding:"omitempty,max=64" example:"Europe/Moscow"`
+	Breakdown string   `form:"breakdown" json:"breakdown" binding:"omitempty,oneof=day week month" example:"month"`
+	GroupBy   []string `form:"group_by" json:"group_by" binding:"omitempty,dive,oneof=service user" example:"user"`
+	Format    string   `form:"format" json:"format" binding:"omitempty,oneof=csv json xlsx html" example:"csv"`
 }
 
 type reportGetResponse struct {
 	Link string `json:"link"`
 }
 
+// params makes entity.ReportParams from month or days of request in its time zone, returns message of the first
+// wrong parameter
+func (q reportRequest) params() (entity.ReportParams, string) {
+	var params entity.ReportParams
+	switch {
+	case q.Year != 0 && q.Month != 0 && q.From == "" && q.To == "":
+	case q.Year == 0 && q.Month == 0 && q.From != "" && q.To != "":
+	default:
+		return params, "Report needs either year and month or from and to"
+	}
+	// Local is a time zone of server, it isn't known to db
+	loc, err := time.LoadLocation(q.TimeZone)
+	if err != nil || q.TimeZone == "Local" {
+		return params, "Unknown time zone"
+	}
+	params.Location, params.Breakdown = loc, q.Breakdown
+	if q.Year != 0 {
+		params.From = time.Date(q.Year, time.Month(q.Month), 1, 0, 0, 0, 0, loc)
+		params.To = params.From.AddDate(0, 1, -1)
+	} else {
+		// dates are checked by binding
+		params.From, _ = time.ParseInLocation("2006-01-02", q.From, loc)
+		params.To, _ = time.ParseInLocation("2006-01-02", q.To, loc)
+		if params.From.After(params.To) {
+			return params, "From is after to"
+		}
+	}
+	for _, g := range q.GroupBy {
+		switch g {
+		case "service":
+			params.ByService = true
+		case "user":
+			params.ByUser = true
+		}
+	}
+	return params, ""
+}
+
 // @Summary     createReport
-// @Description Returns link to report file
+// @Description Creates report file of given format with header, total row, period, time zone and generation time
+// @Description and returns link to it. Report is made for a calendar month or for days from and to inclusive,
+// @Description which are taken in given time zone, UTC by default. Breakdown adds revenue and orders sum of every
+// @Description day, week starting on Monday or month. Rows are grouped by services, users or both, by services
+// @Description by default. Default format is csv
 // @Tags  	    report
 // @Produce     json
-// @Param       year query int true "year" minimum(1900) example(2022)
-// @Param       month query int true "month" minimum(1) maximum(12) example(10)
+// @Param       year query int false "year, used with month" minimum(1900) example(2022)
+// @Param       month query int false "month, used with year" minimum(1) maximum(12) example(10)
+// @Param       from query string false "first day, used with to" example(2022-07-01)
+// @Param       to query string false "last day, used with from" example(2022-09-30)
+// @Param       time_zone query string false "IANA time zone" example(Europe/Moscow)
+// @Param       breakdown query string false "breakdown period" Enums(day, week, month)
+// @Param       group_by query []string false "grouping of rows" Enums(service, user) collectionFormat(multi)
+// @Param       format query string false "file format" Enums(csv, json, xlsx, html)
 // @Success     200 {object} reportGetResponse
 // @Failure     400 {object} response
 // @Failure     500 {object} response
 // @Router      /report [get]
 func (r *balanceRouters) createReport(c *gin.Context) {
-	q := mw.GetQueryParams[reportGetRequest](c)
-	name, err := r.b.UpdateReport(c.Request.Context(), q.Year, q.Month)
+	q := mw.GetQueryParams[reportRequest](c)
+	if q.Format == "" {
+		q.Format = "csv"
+	}
+	params, msg := q.params()
+	if msg != "" {
+		r.l.Infof("err \"%s\" with request params: %v", msg, q)
+		errorResponse(c, http.StatusBadRequest, msg)
+		return
+	}
+	name, err := r.b.UpdateReport(c.Request.Context(), params, q.Format)
 	switch {
 	case errors.Is(err, entity.ErrEmptyReport):
 		r.l.Infof("err \"%s\" with request params: %v", err, q)
 		errorResponse(c, http.StatusBadRequest, "Report is empty")
 		return
+	case errors.Is(err, entity.ErrUnknownFormat):
+		r.l.Infof("err \"%s\" with request params: %v", err, q)
+		errorResponse(c, http.StatusBadRequest, "Unknown format")
+		return
+	case errors.Is(err, entity.ErrReportPeriods):
+		r.l.Infof("err \"%s\" with request params: %v", err, q)
+		errorResponse(c, http.StatusBadRequest, "Too many breakdown periods")
+		return
 	case err != nil:
 		r.l.Error(err)
 		errorResponse(c, http.StatusInternalServerError, "Database error")
 		return
 	}
-	c.JSON(http.StatusOK, reportGetResponse{Link: c.Request.Host + c.Request.URL.Path + "s/" + name})
-}
-
-// @Summary     getReport
-// @Description Returns report file
-// @Tags  	    report
-// @Produce     plain
-// @Param       name path string true "file name"
-// @Success     200 {array} string
-// @Router      /reports/{name} [get]
-func (r *balanceRouters) getReport(c *gin.Context) {
-	name := c.Param("name")
-	dir := r.b.GetReportDir()
-	c.FileAttachment(dir+name, name)
+	link, err := r.reportLink(c, c.Request.URL.Path+"s/", name)
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Storage error")
+		return
+	}
+	c.JSON(http.StatusOK, reportGetResponse{Link: link})
 }
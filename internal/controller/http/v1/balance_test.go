@@ -6,12 +6,16 @@ import (
 	"balance_api/pkg/logger"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -26,6 +30,13 @@ func TestGetByID(t *testing.T) {
 	uc.On("GetByID", ctx, 1).Return(entity.Balance{ID: 1, Amount: "200"}, nil)
 	uc.On("GetByID", ctx, 2).Return(entity.Balance{}, entity.ErrNoID)
 	uc.On("GetByID", ctx, 3).Return(entity.Balance{}, errors.New("aboba"))
+	uc.On("GetByID", ctx, 4).Return(entity.Balance{ID: 4, Amount: "200.00", Reserved: "50.00", Total: "250.00",
+		PendingOrders: 1, LastReplenishment: &entity.MyTime{Time: time.Unix(10, 0)},
+		LastOrder: &entity.MyTime{Time: time.Unix(20, 0)}}, nil)
+	at := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	uc.On("GetBalanceAt", ctx, 1, at).Return(entity.Balance{ID: 1, Amount: "150.00", Reserved: "50.00",
+		Total: "200.00", PendingOrders: 1}, nil)
+	uc.On("GetBalanceAt", ctx, 2, at).Return(entity.Balance{}, entity.ErrNoID)
 
 	req := "/v1/user"
 
@@ -40,7 +51,14 @@ func TestGetByID(t *testing.T) {
 		name:    "valid",
 		query:   "?id=1",
 		expCode: http.StatusOK,
-		resp:    entity.Balance{ID: 1, Amount: "200"},
+		resp:    json.RawMessage(`{"id":1,"amount":"200","pending_orders":0}`),
+	}, {
+		name:    "valid with reserved",
+		query:   "?id=4",
+		expCode: http.StatusOK,
+		resp: entity.Balance{ID: 4, Amount: "200.00", Reserved: "50.00", Total: "250.00",
+			PendingOrders: 1, LastReplenishment: &entity.MyTime{Time: time.Unix(10, 0)},
+			LastOrder: &entity.MyTime{Time: time.Unix(20, 0)}},
 	}, {
 		name:    "empty query",
 		query:   "",
@@ -66,6 +84,28 @@ func TestGetByID(t *testing.T) {
 		query:   "?id=3",
 		expCode: http.StatusInternalServerError,
 		resp:    response{Msg: "Database error"},
+	}, {
+		name:    "valid at",
+		query:   "?id=1&at=2022-10-24T13:00:00Z",
+		expCode: http.StatusOK,
+		resp: entity.Balance{ID: 1, Amount: "150.00", Reserved: "50.00", Total: "200.00",
+			PendingOrders: 1},
+	}, {
+		name:    "valid at with offset",
+		query:   "?id=1&at=2022-10-24T16:00:00%2B03:00",
+		expCode: http.StatusOK,
+		resp: entity.Balance{ID: 1, Amount: "150.00", Reserved: "50.00", Total: "200.00",
+			PendingOrders: 1},
+	}, {
+		name:    "wrong at",
+		query:   "?id=1&at=2022-10-24",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "no such id at",
+		query:   "?id=2&at=2022-10-24T13:00:00Z",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "No such id"},
 	},
 	}
 
@@ -73,9 +113,9 @@ func TestGetByID(t *testing.T) {
 		r, _ := http.NewRequest(http.MethodGet, req+tc.query, nil)
 		w := httptest.NewRecorder()
 		h.ServeHTTP(w, r)
-		require.Equal(t, tc.expCode, w.Code)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
 		b, _ := json.Marshal(tc.resp)
-		require.Equal(t, string(b), w.Body.String())
+		require.Equal(t, string(b), w.Body.String(), tc.name)
 	}
 }
 
@@ -90,6 +130,8 @@ func TestIncrease(t *testing.T) {
 
 	uc.On("Increase", ctx, entity.Balance{ID: 1, Amount: "200"}).Return(nil)
 	uc.On("Increase", ctx, entity.Balance{ID: 2, Amount: "200"}).Return(errors.New("aboba"))
+	uc.On("Increase", ctx, entity.Balance{ID: 3, Amount: "200", Comment: "credited by card",
+		Metadata: entity.Metadata{"card": "*1234"}}).Return(nil)
 
 	type testCases struct {
 		name    string
@@ -103,6 +145,17 @@ func TestIncrease(t *testing.T) {
 		body:    userPostRequest{ID: 1, Amount: "200"},
 		expCode: http.StatusOK,
 		resp:    struct{}{},
+	}, {
+		name: "valid with comment and metadata",
+		body: userPostRequest{ID: 3, Amount: "200", Comment: "credited by card",
+			Metadata: entity.Metadata{"card": "*1234"}},
+		expCode: http.StatusOK,
+		resp:    struct{}{},
+	}, {
+		name:    "too long comment",
+		body:    userPostRequest{ID: 3, Amount: "200", Comment: strings.Repeat("a", 256)},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request body format"},
 	}, {
 		name:    "wrong id",
 		body:    userPostRequest{ID: -1, Amount: "200"},
@@ -147,26 +200,49 @@ func TestOrder(t *testing.T) {
 
 	req := "/v1/order"
 
-	uc.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 2, UserID: 1, Sum: "200"}).
+	uc.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 2, UserID: 1, Sum: "200", Actor: "api"}).
+		Return(nil)
+	uc.On("ChangeOrderStatus", ctx,
+		entity.Order{ID: 1, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 2, Actor: "api"}).
+		Return(nil)
+	uc.On("ChangeOrderStatus", ctx, entity.Order{ID: 9, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 3,
+		Actor: "api:support", Reason: "customer request"}).
 		Return(nil)
-	uc.On("ChangeOrderStatus", ctx, entity.Order{ID: 1, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 2}).
+	uc.On("CreateOrder", ctx, entity.Order{ID: 2, ServiceID: 2, UserID: 1, Sum: "200", TTL: 60, Actor: "api"}).
 		Return(nil)
-	uc.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 10, UserID: 1, Sum: "200"}).
+	uc.On("CreateOrder", ctx, entity.Order{ID: 3, ServiceID: 2, UserID: 1, Sum: "200", Comment: "rent for October",
+		Metadata: entity.Metadata{"invoice": "INV-42"}, Actor: "api"}).
+		Return(nil)
+	uc.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 10, UserID: 1, Sum: "200", Actor: "api"}).
 		Return(entity.ErrNoService)
-	uc.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 2, UserID: 10, Sum: "200"}).
+	uc.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 2, UserID: 10, Sum: "200", Actor: "api"}).
 		Return(entity.ErrNoID)
-	uc.On("CreateOrder", ctx, entity.Order{ID: 10, ServiceID: 2, UserID: 1, Sum: "200"}).
+	uc.On("CreateOrder", ctx, entity.Order{ID: 10, ServiceID: 2, UserID: 1, Sum: "200", Actor: "api"}).
 		Return(entity.ErrOrderExists)
-	uc.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 2, UserID: 1, Sum: "1000"}).
+	uc.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 2, UserID: 1, Sum: "1000", Actor: "api"}).
 		Return(entity.ErrNotEnoughMoney)
-	uc.On("ChangeOrderStatus", ctx, entity.Order{ID: 2, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 2}).
+	uc.On("ChangeOrderStatus", ctx, entity.Order{ID: 2, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 2, Actor: "api"}).
 		Return(entity.ErrOrderNoExists)
-	uc.On("ChangeOrderStatus", ctx, entity.Order{ID: 3, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 2}).
+	uc.On("ChangeOrderStatus", ctx, entity.Order{ID: 3, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 2, Actor: "api"}).
 		Return(entity.ErrOrderMismatch)
-	uc.On("ChangeOrderStatus", ctx, entity.Order{ID: 4, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 2}).
+	uc.On("ChangeOrderStatus", ctx, entity.Order{ID: 4, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 2, Actor: "api"}).
 		Return(entity.ErrCantChangeStatus)
-	uc.On("ChangeOrderStatus", ctx, entity.Order{ID: 5, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 3}).
+	uc.On("ChangeOrderStatus", ctx, entity.Order{ID: 5, ServiceID: 2, UserID: 1, Sum: "200", StatusID: 3, Actor: "api"}).
 		Return(errors.New("aboba"))
+	uc.On("ChangeOrderStatus", ctx,
+		entity.Order{ID: 1, ServiceID: 2, UserID: 1, Sum: "200", Captured: "150", StatusID: 2, Actor: "api"}).
+		Return(nil)
+	uc.On("ChangeOrderStatus", ctx,
+		entity.Order{ID: 8, ServiceID: 2, UserID: 1, Sum: "200", Captured: "250", StatusID: 2, Actor: "api"}).
+		Return(entity.ErrCaptureExceeds)
+	uc.On("RefundOrder", ctx, entity.Order{ID: 1, ServiceID: 2, UserID: 1, Sum: "200", Actor: "api"}, "50").
+		Return(nil)
+	uc.On("RefundOrder", ctx, entity.Order{ID: 1, ServiceID: 2, UserID: 1, Sum: "200", Actor: "api"}, "").
+		Return(nil)
+	uc.On("RefundOrder", ctx, entity.Order{ID: 6, ServiceID: 2, UserID: 1, Sum: "200", Actor: "api"}, "50").
+		Return(entity.ErrCantRefund)
+	uc.On("RefundOrder", ctx, entity.Order{ID: 7, ServiceID: 2, UserID: 1, Sum: "200", Actor: "api"}, "500").
+		Return(entity.ErrRefundExceeds)
 
 	type testCases struct {
 		name    string
@@ -180,11 +256,39 @@ func TestOrder(t *testing.T) {
 		body:    orderPostRequest{Action: "create", ID: 1, ServiceID: 2, UserID: 1, Sum: "200"},
 		expCode: http.StatusOK,
 		resp:    struct{}{},
+	}, {
+		name:    "valid create with ttl",
+		body:    orderPostRequest{Action: "create", ID: 2, ServiceID: 2, UserID: 1, Sum: "200", TTL: 60},
+		expCode: http.StatusOK,
+		resp:    struct{}{},
+	}, {
+		name: "valid create with comment and metadata",
+		body: orderPostRequest{Action: "create", ID: 3, ServiceID: 2, UserID: 1, Sum: "200",
+			Comment: "rent for October", Metadata: entity.Metadata{"invoice": "INV-42"}},
+		expCode: http.StatusOK,
+		resp:    struct{}{},
+	}, {
+		name:    "wrong ttl",
+		body:    orderPostRequest{Action: "create", ID: 2, ServiceID: 2, UserID: 1, Sum: "200", TTL: -1},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request body format"},
 	}, {
 		name:    "valid change",
 		body:    orderPostRequest{Action: "approve", ID: 1, ServiceID: 2, UserID: 1, Sum: "200"},
 		expCode: http.StatusOK,
 		resp:    struct{}{},
+	}, {
+		name: "valid change with actor and reason",
+		body: orderPostRequest{Action: "cancel", ID: 9, ServiceID: 2, UserID: 1, Sum: "200", Actor: "support",
+			Reason: "customer request"},
+		expCode: http.StatusOK,
+		resp:    struct{}{},
+	}, {
+		name: "too long actor",
+		body: orderPostRequest{Action: "cancel", ID: 9, ServiceID: 2, UserID: 1, Sum: "200",
+			Actor: strings.Repeat("a", 61)},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request body format"},
 	}, {
 		name:    "wrong id",
 		body:    orderPostRequest{Action: "create", ID: -1, ServiceID: 2, UserID: 1, Sum: "200"},
@@ -250,6 +354,46 @@ func TestOrder(t *testing.T) {
 		body:    orderPostRequest{Action: "approve", ID: 4, ServiceID: 2, UserID: 1, Sum: "200"},
 		expCode: http.StatusBadRequest,
 		resp:    response{Msg: "Order already approved/canceled"},
+	}, {
+		name:    "valid partial capture",
+		body:    orderPostRequest{Action: "approve", ID: 1, ServiceID: 2, UserID: 1, Sum: "200", Capture: "150"},
+		expCode: http.StatusOK,
+		resp:    struct{}{},
+	}, {
+		name:    "wrong capture format",
+		body:    orderPostRequest{Action: "approve", ID: 1, ServiceID: 2, UserID: 1, Sum: "200", Capture: "a"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid money format"},
+	}, {
+		name:    "capture exceeds",
+		body:    orderPostRequest{Action: "approve", ID: 8, ServiceID: 2, UserID: 1, Sum: "200", Capture: "250"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Capture exceeds order sum"},
+	}, {
+		name:    "valid partial refund",
+		body:    orderPostRequest{Action: "refund", ID: 1, ServiceID: 2, UserID: 1, Sum: "200", Refund: "50"},
+		expCode: http.StatusOK,
+		resp:    struct{}{},
+	}, {
+		name:    "valid full refund",
+		body:    orderPostRequest{Action: "refund", ID: 1, ServiceID: 2, UserID: 1, Sum: "200"},
+		expCode: http.StatusOK,
+		resp:    struct{}{},
+	}, {
+		name:    "wrong refund format",
+		body:    orderPostRequest{Action: "refund", ID: 1, ServiceID: 2, UserID: 1, Sum: "200", Refund: "-50"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid money format"},
+	}, {
+		name:    "refund not approved",
+		body:    orderPostRequest{Action: "refund", ID: 6, ServiceID: 2, UserID: 1, Sum: "200", Refund: "50"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Order isn't approved"},
+	}, {
+		name:    "refund exceeds",
+		body:    orderPostRequest{Action: "refund", ID: 7, ServiceID: 2, UserID: 1, Sum: "200", Refund: "500"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Refund exceeds order sum"},
 	}, {
 		name:    "db error",
 		body:    orderPostRequest{Action: "cancel", ID: 5, ServiceID: 2, UserID: 1, Sum: "200"},
@@ -270,6 +414,83 @@ func TestOrder(t *testing.T) {
 	}
 }
 
+func TestTransfer(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	req := "/v1/transfer"
+
+	uc.On("Transfer", ctx, entity.Transfer{FromID: 1, ToID: 2, Amount: "200", Comment: "aboba"}).Return(nil)
+	uc.On("Transfer", ctx, entity.Transfer{FromID: 1, ToID: 1, Amount: "200"}).Return(entity.ErrSameUser)
+	uc.On("Transfer", ctx, entity.Transfer{FromID: 1, ToID: 3, Amount: "200"}).Return(entity.ErrNoID)
+	uc.On("Transfer", ctx, entity.Transfer{FromID: 1, ToID: 2, Amount: "1000"}).Return(entity.ErrNotEnoughMoney)
+	uc.On("Transfer", ctx, entity.Transfer{FromID: 1, ToID: 4, Amount: "200"}).Return(errors.New("aboba"))
+
+	type testCases struct {
+		name    string
+		body    transferPostRequest
+		expCode int
+		resp    interface{}
+	}
+
+	cases := []testCases{{
+		name:    "valid",
+		body:    transferPostRequest{FromID: 1, ToID: 2, Amount: "200", Comment: "aboba"},
+		expCode: http.StatusOK,
+		resp:    struct{}{},
+	}, {
+		name:    "wrong id",
+		body:    transferPostRequest{FromID: -1, ToID: 2, Amount: "200"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request body format"},
+	}, {
+		name:    "wrong money format",
+		body:    transferPostRequest{FromID: 1, ToID: 2, Amount: "aboba"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid money format"},
+	}, {
+		name:    "negative money",
+		body:    transferPostRequest{FromID: 1, ToID: 2, Amount: "-200"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid money format"},
+	}, {
+		name:    "same user",
+		body:    transferPostRequest{FromID: 1, ToID: 1, Amount: "200"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Sender and receiver are the same"},
+	}, {
+		name:    "no id",
+		body:    transferPostRequest{FromID: 1, ToID: 3, Amount: "200"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "No such id"},
+	}, {
+		name:    "not enough money",
+		body:    transferPostRequest{FromID: 1, ToID: 2, Amount: "1000"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Not enough money"},
+	}, {
+		name:    "db error",
+		body:    transferPostRequest{FromID: 1, ToID: 4, Amount: "200"},
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Database error"},
+	},
+	}
+
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		_ = json.NewEncoder(&buf).Encode(tc.body)
+		r, _ := http.NewRequest(http.MethodPost, req, &buf)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code)
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String())
+	}
+}
+
 func TestHistory(t *testing.T) {
 	ctx := context.Background()
 	h := gin.New()
@@ -298,11 +519,58 @@ func TestHistory(t *testing.T) {
 		entity.History{UserID: 2, OrderBy: "date"}).Return(entity.History{}, entity.ErrNoID)
 
 	uc.On("GetHistory", ctx,
-		entity.History{UserID: 3, Limit: 100, Page: 99, OrderBy: "date"}).Return(entity.History{}, entity.ErrEmptyPage)
+		entity.History{UserID: 3, Limit: 100, Page: 99, OrderBy: "date"}).Return(entity.History{}, nil)
+
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	uc.On("GetHistory", ctx,
+		entity.History{UserID: 7, OrderBy: "date", From: from, To: from.AddDate(0, 1, 0),
+			Kinds:      []int{entity.KindOrder, entity.KindTransferOut, entity.KindTransferIn},
+			ServiceIDs: []int{1, 2}, StatusIDs: []int{entity.StatusCanceled}, MinSum: "10", MaxSum: "500.50"}).
+		Return(entity.History{Orders: []entity.Order{{
+			Sum:          "200",
+			ServiceName:  "Rent",
+			Status:       "Canceled",
+			Time:         entity.MyTime{Time: from},
+			BalanceAfter: &entity.BalanceAfter{Available: "150.00", Reserved: "200.00"},
+		}}}, nil)
+	uc.On("GetHistory", ctx,
+		entity.History{UserID: 7, OrderBy: "date", Kinds: []int{entity.KindReplenishment},
+			StatusIDs: []int{entity.StatusApproved, entity.StatusPending}}).
+		Return(entity.History{}, nil)
+
+	next := &entity.HistoryCursor{OrderBy: "date", Desc: true, Created: time.Unix(10, 0).UTC(), Sum: "200",
+		Kind: entity.KindReplenishment, ID: 7}
+	uc.On("GetHistory", ctx,
+		entity.History{UserID: 6, Limit: 1, Page: 1, OrderBy: "date", Desc: true}).
+		Return(entity.History{Orders: []entity.Order{{
+			Sum:         "200",
+			ServiceName: "Replenishment",
+			Status:      "Approved",
+			Time:        entity.MyTime{Time: time.Unix(10, 0)},
+		}}, Next: next}, nil)
+	uc.On("GetHistory", ctx,
+		entity.History{UserID: 6, Limit: 1, OrderBy: "date", Desc: true, After: next}).
+		Return(entity.History{Orders: []entity.Order{{
+			Sum:         "100",
+			ServiceName: "Replenishment",
+			Status:      "Approved",
+			Time:        entity.MyTime{Time: time.Unix(5, 0)},
+		}}}, nil)
 
 	uc.On("GetHistory", ctx,
 		entity.History{UserID: 4, OrderBy: "date"}).Return(entity.History{}, errors.New("aboba"))
 
+	uc.On("GetHistory", ctx,
+		entity.History{UserID: 5, OrderBy: "date", MetadataKey: "invoice", MetadataValue: "INV-42"}).
+		Return(entity.History{Orders: []entity.Order{{
+			Sum:         "200",
+			ServiceName: "Replenishment",
+			Status:      "Approved",
+			Time:        entity.MyTime{Time: time.Unix(10, 0)},
+			Comment:     "credited by card",
+			Metadata:    entity.Metadata{"invoice": "INV-42"},
+		}}}, nil)
+
 	type testCases struct {
 		name    string
 		query   string
@@ -361,6 +629,23 @@ func TestHistory(t *testing.T) {
 		query:   "?id=1&order_by=1",
 		expCode: http.StatusBadRequest,
 		resp:    response{Msg: "Wrong \"order by\" value"},
+	}, {
+		name:    "search by metadata",
+		query:   "?id=5&metadata_key=invoice&metadata_value=INV-42",
+		expCode: http.StatusOK,
+		resp: entity.History{Orders: []entity.Order{{
+			Sum:         "200",
+			ServiceName: "Replenishment",
+			Status:      "Approved",
+			Time:        entity.MyTime{Time: time.Unix(10, 0)},
+			Comment:     "credited by card",
+			Metadata:    entity.Metadata{"invoice": "INV-42"},
+		}}},
+	}, {
+		name:    "metadata value without key",
+		query:   "?id=5&metadata_value=INV-42",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Metadata value needs metadata key"},
 	}, {
 		name:    "no id",
 		query:   "?id=2&order_by=date",
@@ -369,8 +654,95 @@ func TestHistory(t *testing.T) {
 	}, {
 		name:    "empty page",
 		query:   "?id=3&limit=100&page=99",
+		expCode: http.StatusOK,
+		resp:    historyResponse{Orders: []entity.Order{}},
+	}, {
+		name:    "page with next cursor",
+		query:   "?id=6&limit=1&page=1&desc=true",
+		expCode: http.StatusOK,
+		resp: historyResponse{Orders: []entity.Order{{
+			Sum:         "200",
+			ServiceName: "Replenishment",
+			Status:      "Approved",
+			Time:        entity.MyTime{Time: time.Unix(10, 0)},
+		}}, NextCursor: encodeHistoryCursor(next)},
+	}, {
+		name:    "last page by cursor",
+		query:   "?id=6&limit=1&desc=true&cursor=" + encodeHistoryCursor(next),
+		expCode: http.StatusOK,
+		resp: historyResponse{Orders: []entity.Order{{
+			Sum:         "100",
+			ServiceName: "Replenishment",
+			Status:      "Approved",
+			Time:        entity.MyTime{Time: time.Unix(5, 0)},
+		}}},
+	}, {
+		name: "all filters",
+		query: "?id=7&from=2022-03-01T03:00:00%2B03:00&to=2022-04-01T00:00:00Z&type=order&type=transfer" +
+			"&service_id=1&service_id=2&status=canceled&min_sum=10&max_sum=500.50",
+		expCode: http.StatusOK,
+		resp: historyResponse{Orders: []entity.Order{{
+			Sum:          "200",
+			ServiceName:  "Rent",
+			Status:       "Canceled",
+			Time:         entity.MyTime{Time: from},
+			BalanceAfter: &entity.BalanceAfter{Available: "150.00", Reserved: "200.00"},
+		}}},
+	}, {
+		name:    "type and statuses",
+		query:   "?id=7&type=replenishment&status=approved&status=pending",
+		expCode: http.StatusOK,
+		resp:    historyResponse{Orders: []entity.Order{}},
+	}, {
+		name:    "wrong type",
+		query:   "?id=7&type=aboba",
 		expCode: http.StatusBadRequest,
-		resp:    response{Msg: "The page is empty"},
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "wrong status",
+		query:   "?id=7&status=aboba",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "wrong service id",
+		query:   "?id=7&service_id=0",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "wrong from",
+		query:   "?id=7&from=2022-03-01",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "wrong min sum",
+		query:   "?id=7&min_sum=-1",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid money format"},
+	}, {
+		name:    "wrong max sum",
+		query:   "?id=7&max_sum=a",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid money format"},
+	}, {
+		name:    "cursor of another order",
+		query:   "?id=6&limit=1&order_by=sum&desc=true&cursor=" + encodeHistoryCursor(next),
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid cursor"},
+	}, {
+		name:    "wrong cursor",
+		query:   "?id=6&limit=1&cursor=aboba",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid cursor"},
+	}, {
+		name:    "cursor with page",
+		query:   "?id=6&limit=1&page=2&cursor=" + encodeHistoryCursor(next),
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Cursor needs limit and no page"},
+	}, {
+		name:    "cursor without limit",
+		query:   "?id=6&cursor=" + encodeHistoryCursor(next),
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Cursor needs limit and no page"},
 	}, {
 		name:    "db error",
 		query:   "?id=4",
@@ -387,6 +759,129 @@ func TestHistory(t *testing.T) {
 		b, _ := json.Marshal(tc.resp)
 		require.Equal(t, string(b), w.Body.String())
 	}
+
+	r, _ := http.NewRequest(http.MethodGet, req+"?id=7&from=2022-03-01T00:00:00Z&to=2022-04-01T00:00:00Z"+
+		"&type=order&type=transfer&service_id=1&service_id=2&status=canceled&min_sum=10&max_sum=500.50", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Contains(t, w.Body.String(), `"balance_after":{"available":"150.00","reserved":"200.00"}`)
+}
+
+func TestHistoryCursor(t *testing.T) {
+	c := &entity.HistoryCursor{OrderBy: "sum", Desc: true,
+		Created: time.Date(2022, 10, 24, 13, 0, 0, 123456000, time.UTC), Sum: "150.50", Kind: entity.KindRefund, ID: 42}
+	res, err := decodeHistoryCursor(encodeHistoryCursor(c))
+	require.NoError(t, err)
+	require.Equal(t, c, res)
+
+	res, err = decodeHistoryCursor("")
+	require.NoError(t, err)
+	require.Nil(t, res)
+
+	for _, s := range []string{"aboba", "date:true:1:1.00:1", "date:yes:1:1.00:1:1", "date:true:1:a:1:1"} {
+		_, err = decodeHistoryCursor(base64.RawURLEncoding.EncodeToString([]byte(s)))
+		require.Equal(t, errWrongCursor, err, s)
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	req := "/v1/history/export"
+
+	write := func(s string) func(args mock.Arguments) {
+		return func(args mock.Arguments) {
+			args.Get(3).(io.Writer).Write([]byte(s))
+		}
+	}
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	uc.On("ExportHistory", ctx, entity.History{UserID: 1}, "csv", mock.Anything).
+		Run(write("time,operation\n")).Return(nil)
+	uc.On("ExportHistory", ctx, entity.History{UserID: 1, From: from, To: from.AddDate(0, 1, 0),
+		Kinds: []int{entity.KindRefund}, StatusIDs: []int{entity.StatusApproved}, MinSum: "10"},
+		"jsonl", mock.Anything).
+		Run(write("{}\n")).Return(nil)
+	uc.On("ExportHistory", ctx, entity.History{UserID: 2}, "xlsx", mock.Anything).Return(entity.ErrNoID)
+	uc.On("ExportHistory", ctx, entity.History{UserID: 3}, "csv", mock.Anything).Return(errors.New("aboba"))
+	uc.On("ExportHistory", ctx, entity.History{UserID: 4}, "csv", mock.Anything).
+		Run(write("time,operation\n")).Return(errors.New("aboba"))
+
+	type testCases struct {
+		name        string
+		query       string
+		expCode     int
+		contentType string
+		body        string
+		resp        interface{}
+	}
+
+	cases := []testCases{{
+		name:        "csv",
+		query:       "?id=1&format=csv",
+		expCode:     http.StatusOK,
+		contentType: "text/csv",
+		body:        "time,operation\n",
+	}, {
+		name: "jsonl with filters",
+		query: "?id=1&format=jsonl&from=2022-03-01T00:00:00Z&to=2022-04-01T00:00:00Z" +
+			"&type=refund&status=approved&min_sum=10",
+		expCode:     http.StatusOK,
+		contentType: "application/x-ndjson",
+		body:        "{}\n",
+	}, {
+		name:    "no format",
+		query:   "?id=1",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "wrong format",
+		query:   "?id=1&format=pdf",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "wrong sum",
+		query:   "?id=1&format=csv&min_sum=-1",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid money format"},
+	}, {
+		name:    "no such id",
+		query:   "?id=2&format=xlsx",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "No such id"},
+	}, {
+		name:    "db error",
+		query:   "?id=3&format=csv",
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Database error"},
+	}, {
+		name:        "db error while streaming",
+		query:       "?id=4&format=csv",
+		expCode:     http.StatusOK,
+		contentType: "text/csv",
+		body:        "time,operation\n",
+	},
+	}
+
+	for _, tc := range cases {
+		r, _ := http.NewRequest(http.MethodGet, req+tc.query, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		if tc.resp != nil {
+			require.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"), tc.name)
+			require.Empty(t, w.Header().Get("Content-Disposition"), tc.name)
+			b, _ := json.Marshal(tc.resp)
+			require.Equal(t, string(b), w.Body.String(), tc.name)
+			continue
+		}
+		require.Equal(t, tc.contentType, w.Header().Get("Content-Type"), tc.name)
+		require.Contains(t, w.Header().Get("Content-Disposition"), "history-", tc.name)
+		require.Equal(t, tc.body, w.Body.String(), tc.name)
+	}
 }
 
 func TestReport(t *testing.T) {
@@ -398,9 +893,32 @@ func TestReport(t *testing.T) {
 
 	req := "/v1/report"
 
-	uc.On("UpdateReport", ctx, 2022, 10).Return("2022-10.csv", nil)
-	uc.On("UpdateReport", ctx, 2000, 1).Return("", entity.ErrEmptyReport)
-	uc.On("UpdateReport", ctx, 2000, 2).Return("", errors.New("aboba"))
+	month := func(year, month int) entity.ReportParams {
+		from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+		return entity.ReportParams{From: from, To: from.AddDate(0, 1, -1), Location: time.UTC}
+	}
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	days := entity.ReportParams{From: time.Date(2022, 7, 1, 0, 0, 0, 0, moscow),
+		To: time.Date(2022, 9, 30, 0, 0, 0, 0, moscow), Location: moscow, Breakdown: "week", ByService: true,
+		ByUser: true}
+	daily := entity.ReportParams{From: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		To: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), Location: time.UTC, Breakdown: "day", ByUser: true}
+
+	uc.On("UpdateReport", ctx, month(2022, 10), "csv").Return("2022-10.csv", nil)
+	uc.On("UpdateReport", ctx, month(2022, 10), "xlsx").Return("2022-10.xlsx", nil)
+	uc.On("UpdateReport", ctx, month(2022, 10), "html").Return("", entity.ErrUnknownFormat)
+	uc.On("UpdateReport", ctx, month(2000, 1), "csv").Return("", entity.ErrEmptyReport)
+	uc.On("UpdateReport", ctx, month(2000, 2), "csv").Return("", errors.New("aboba"))
+	uc.On("UpdateReport", ctx, days, "json").
+		Return("2022-07-01_2022-09-30_week_service-user_Europe-Moscow.json", nil)
+	uc.On("UpdateReport", ctx, daily, "csv").Return("", entity.ErrReportPeriods)
+	uc.On("UpdateReport", ctx, month(2022, 11), "csv").Return("2022-11.csv", nil)
+	uc.On("GetReportURL", ctx, "2022-10.csv").Return("", nil)
+	uc.On("GetReportURL", ctx, "2022-10.xlsx").
+		Return("http://localhost:9000/reports/2022-10.xlsx?X-Amz-Signature=a", nil)
+	uc.On("GetReportURL", ctx, "2022-07-01_2022-09-30_week_service-user_Europe-Moscow.json").Return("", nil)
+	uc.On("GetReportURL", ctx, "2022-11.csv").Return("", errors.New("aboba"))
 
 	type testCases struct {
 		name    string
@@ -416,6 +934,26 @@ func TestReport(t *testing.T) {
 		resp: struct {
 			Link string `json:"link"`
 		}{Link: "/v1/reports/2022-10.csv"}, // should be localhost:8080/v1/reports/2022-10.csv
+	}, {
+		name:    "pre-signed link",
+		query:   "?year=2022&month=10&format=xlsx",
+		expCode: http.StatusOK,
+		resp:    reportGetResponse{Link: "http://localhost:9000/reports/2022-10.xlsx?X-Amz-Signature=a"},
+	}, {
+		name:    "storage error",
+		query:   "?year=2022&month=11",
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Storage error"},
+	}, {
+		name:    "wrong format",
+		query:   "?year=2022&month=10&format=pdf",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "format not set up",
+		query:   "?year=2022&month=10&format=html",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Unknown format"},
 	}, {
 		name:    "wrong year",
 		query:   "?year=-1&month=10",
@@ -436,6 +974,62 @@ func TestReport(t *testing.T) {
 		query:   "?month=2&year=2000",
 		expCode: http.StatusInternalServerError,
 		resp:    response{Msg: "Database error"},
+	}, {
+		name: "days in time zone by weeks, services and users",
+		query: "?from=2022-07-01&to=2022-09-30&time_zone=Europe/Moscow&breakdown=week&group_by=service" +
+			"&group_by=user&format=json",
+		expCode: http.StatusOK,
+		resp:    reportGetResponse{Link: "/v1/reports/2022-07-01_2022-09-30_week_service-user_Europe-Moscow.json"},
+	}, {
+		name:    "too many periods",
+		query:   "?from=2020-01-01&to=2022-01-01&breakdown=day&group_by=user",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Too many breakdown periods"},
+	}, {
+		name:    "no period",
+		query:   "?format=csv",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Report needs either year and month or from and to"},
+	}, {
+		name:    "month and days",
+		query:   "?year=2022&month=10&from=2022-07-01&to=2022-09-30",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Report needs either year and month or from and to"},
+	}, {
+		name:    "no month",
+		query:   "?year=2022",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Report needs either year and month or from and to"},
+	}, {
+		name:    "from after to",
+		query:   "?from=2022-09-30&to=2022-07-01",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "From is after to"},
+	}, {
+		name:    "wrong date",
+		query:   "?from=2022-09-31&to=2022-10-01",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "unknown time zone",
+		query:   "?year=2022&month=10&time_zone=Mars/Olympus",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Unknown time zone"},
+	}, {
+		name:    "server time zone",
+		query:   "?year=2022&month=10&time_zone=Local",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Unknown time zone"},
+	}, {
+		name:    "wrong breakdown",
+		query:   "?year=2022&month=10&breakdown=year",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "wrong grouping",
+		query:   "?year=2022&month=10&group_by=order",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
 	},
 	}
 
@@ -448,3 +1042,123 @@ func TestReport(t *testing.T) {
 		require.Equal(t, string(b), w.Body.String())
 	}
 }
+
+func TestIdempotency(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	req := "/v1/user"
+	fp := func(b userPostRequest) string {
+		body, _ := json.Marshal(b)
+		return fingerprint(http.MethodPost, req, body)
+	}
+
+	lease := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	// operation gets the key to save it with success response in its transaction
+	withKey := func(key string) interface{} {
+		return mock.MatchedBy(func(ctx context.Context) bool {
+			k, ok := entity.IdempotencyFrom(ctx)
+			return ok && k.Key == key && k.Created.Equal(lease) && k.Completed && k.Code == http.StatusOK &&
+				string(k.Response) == "{}"
+		})
+	}
+
+	body1 := userPostRequest{ID: 1, Amount: "200"}
+	uc.On("StartIdempotent", ctx, entity.Idempotency{Key: "1", Fingerprint: fp(body1)}).
+		Return(entity.Idempotency{Key: "1", Fingerprint: fp(body1), Created: lease}, nil)
+	uc.On("Increase", withKey("1"), entity.Balance{ID: 1, Amount: "200"}).Return(nil)
+	uc.On("FinishIdempotent", mock.Anything, entity.Idempotency{Key: "1", Fingerprint: fp(body1), Completed: true,
+		Code: http.StatusOK, Response: []byte("{}"), Created: lease}).Return(nil)
+
+	uc.On("StartIdempotent", ctx, entity.Idempotency{Key: "2", Fingerprint: fp(body1)}).
+		Return(entity.Idempotency{Key: "2", Fingerprint: fp(body1), Completed: true,
+			Code: http.StatusOK, Response: []byte("{}")}, nil)
+
+	uc.On("StartIdempotent", ctx, entity.Idempotency{Key: "3", Fingerprint: fp(body1)}).
+		Return(entity.Idempotency{}, entity.ErrIdempotencyMismatch)
+
+	uc.On("StartIdempotent", ctx, entity.Idempotency{Key: "4", Fingerprint: fp(body1)}).
+		Return(entity.Idempotency{}, entity.ErrIdempotencyInProgress)
+
+	body2 := userPostRequest{ID: 2, Amount: "200"}
+	uc.On("StartIdempotent", ctx, entity.Idempotency{Key: "5", Fingerprint: fp(body2)}).
+		Return(entity.Idempotency{Key: "5", Fingerprint: fp(body2), Created: lease}, nil)
+	uc.On("Increase", withKey("5"), entity.Balance{ID: 2, Amount: "200"}).Return(errors.New("aboba"))
+	uc.On("CancelIdempotent", mock.Anything, entity.Idempotency{Key: "5", Fingerprint: fp(body2), Created: lease}).
+		Return(nil)
+
+	body3 := userPostRequest{ID: 3, Amount: "200"}
+	uc.On("StartIdempotent", ctx, entity.Idempotency{Key: "6", Fingerprint: fp(body3)}).
+		Return(entity.Idempotency{Key: "6", Fingerprint: fp(body3), Created: lease}, nil)
+	uc.On("Increase", withKey("6"), entity.Balance{ID: 3, Amount: "200"}).Return(nil).Run(func(mock.Arguments) {
+		panic("aboba")
+	})
+	uc.On("CancelIdempotent", mock.Anything, entity.Idempotency{Key: "6", Fingerprint: fp(body3), Created: lease}).
+		Return(nil)
+
+	type testCases struct {
+		name    string
+		key     string
+		body    userPostRequest
+		expCode int
+		resp    interface{}
+	}
+
+	cases := []testCases{{
+		name:    "new key",
+		key:     "1",
+		body:    body1,
+		expCode: http.StatusOK,
+		resp:    struct{}{},
+	}, {
+		name:    "replay",
+		key:     "2",
+		body:    body1,
+		expCode: http.StatusOK,
+		resp:    struct{}{},
+	}, {
+		name:    "another request",
+		key:     "3",
+		body:    body1,
+		expCode: http.StatusUnprocessableEntity,
+		resp:    response{Msg: "Idempotency key was used with another request"},
+	}, {
+		name:    "in progress",
+		key:     "4",
+		body:    body1,
+		expCode: http.StatusConflict,
+		resp:    response{Msg: "Request with this idempotency key is in progress"},
+	}, {
+		name:    "failed request",
+		key:     "5",
+		body:    body2,
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Database error"},
+	}, {
+		name:    "panic releases key",
+		key:     "6",
+		body:    body3,
+		expCode: http.StatusInternalServerError,
+		resp:    nil,
+	},
+	}
+
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		_ = json.NewEncoder(&buf).Encode(tc.body)
+		r, _ := http.NewRequest(http.MethodPost, req, &buf)
+		r.Header.Set(idempotencyHeader, tc.key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		if tc.resp == nil {
+			require.Empty(t, w.Body.String(), tc.name)
+			continue
+		}
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String(), tc.name)
+	}
+}
@@ -0,0 +1,84 @@
+package v1
+
+import (
+	"balance_api/internal/entity"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errWrongCursor = errors.New("wrong cursor")
+
+// encodeOrderCursor makes opaque string from position in orders list
+func encodeOrderCursor(c *entity.OrderCursor) string {
+	if c == nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.Created.UnixNano(), c.ID)))
+}
+
+// decodeOrderCursor parses string made by encodeOrderCursor, empty string means the first page
+func decodeOrderCursor(s string) (*entity.OrderCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errWrongCursor
+	}
+	var nanos int64
+	var id int
+	if _, err = fmt.Sscanf(string(b), "%d:%d", &nanos, &id); err != nil {
+		return nil, errWrongCursor
+	}
+	return &entity.OrderCursor{Created: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// encodeHistoryCursor makes opaque string from position in user's history
+func encodeHistoryCursor(c *entity.HistoryCursor) string {
+	if c == nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%t:%d:%s:%d:%d",
+		c.OrderBy, c.Desc, c.Created.UnixNano(), c.Sum, c.Kind, c.ID)))
+}
+
+// decodeHistoryCursor parses string made by encodeHistoryCursor, empty string means the first page
+func decodeHistoryCursor(s string) (*entity.HistoryCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errWrongCursor
+	}
+	parts := strings.Split(string(b), ":")
+	if len(parts) != 6 {
+		return nil, errWrongCursor
+	}
+	desc, err := strconv.ParseBool(parts[1])
+	if err != nil {
+		return nil, errWrongCursor
+	}
+	nanos, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, errWrongCursor
+	}
+	if _, err = decimal.NewFromString(parts[3]); err != nil {
+		return nil, errWrongCursor
+	}
+	kind, err := strconv.Atoi(parts[4])
+	if err != nil {
+		return nil, errWrongCursor
+	}
+	id, err := strconv.Atoi(parts[5])
+	if err != nil {
+		return nil, errWrongCursor
+	}
+	return &entity.HistoryCursor{OrderBy: parts[0], Desc: desc, Created: time.Unix(0, nanos).UTC(), Sum: parts[3],
+		Kind: kind, ID: id}, nil
+}
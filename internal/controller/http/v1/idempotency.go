@@ -0,0 +1,134 @@
+package v1
+
+import (
+	"balance_api/internal/entity"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyHeader      = "Idempotency-Key"
+	idempotencyMaxKeyLen   = 255
+	idempotencySaveTimeout = 5 * time.Second
+)
+
+// idempotentResponse is a response of idempotent routes on success, it is saved together with the operation
+var idempotentResponse = []byte("{}")
+
+// responseRecorder copies response body, so it can be saved for replays
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write -.
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// WriteString -.
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotency replays saved response if request with the same Idempotency-Key header was already handled,
+// requests without header are passed as is
+func (r *balanceRouters) idempotency(c *gin.Context) {
+	key := c.GetHeader(idempotencyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+	if len(key) > idempotencyMaxKeyLen {
+		r.l.Infof("err \"too long idempotency key\" with key: %s", key)
+		errorResponse(c, http.StatusBadRequest, "Invalid idempotency key")
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		r.l.Infof("err \"%s\" while reading request body", err)
+		errorResponse(c, http.StatusBadRequest, "Invalid request body format")
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	saved, err := r.b.StartIdempotent(c.Request.Context(),
+		entity.Idempotency{Key: key, Fingerprint: fingerprint(c.Request.Method, c.FullPath(), body)})
+	switch {
+	case errors.Is(err, entity.ErrIdempotencyMismatch):
+		r.l.Infof("err \"%s\" with key: %s", err, key)
+		errorResponse(c, http.StatusUnprocessableEntity, "Idempotency key was used with another request")
+		return
+	case errors.Is(err, entity.ErrIdempotencyInProgress):
+		r.l.Infof("err \"%s\" with key: %s", err, key)
+		errorResponse(c, http.StatusConflict, "Request with this idempotency key is in progress")
+		return
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if saved.Completed {
+		c.Data(saved.Code, gin.MIMEJSON+"; charset=utf-8", saved.Response)
+		c.Abort()
+		return
+	}
+
+	// operation saves the key with success response in its own transaction, so response isn't lost if request
+	// fails after the operation is done
+	done := saved
+	done.Completed, done.Code, done.Response = true, http.StatusOK, idempotentResponse
+	c.Request = c.Request.WithContext(entity.WithIdempotency(c.Request.Context(), done))
+
+	w := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = w
+	// key is released even if handler panics, panic is passed on to recovery
+	defer func() {
+		p := recover()
+		r.releaseIdempotent(saved, w, p != nil)
+		if p != nil {
+			panic(p)
+		}
+	}()
+	c.Next()
+}
+
+// releaseIdempotent saves response of request with idempotency key, key of failed request is removed,
+// so it can be retried
+func (r *balanceRouters) releaseIdempotent(key entity.Idempotency, w *responseRecorder, panicked bool) {
+	// request context may be already canceled here, but key must not stay locked
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencySaveTimeout)
+	defer cancel()
+	var err error
+	if panicked || w.Status() >= http.StatusInternalServerError {
+		err = r.b.CancelIdempotent(ctx, key)
+	} else {
+		key.Completed, key.Code, key.Response = true, w.Status(), w.body.Bytes()
+		err = r.b.FinishIdempotent(ctx, key)
+	}
+	if err != nil {
+		r.l.Error(err)
+	}
+}
+
+// fingerprint hashes route and request body, body is normalized if it is a valid json
+func fingerprint(method, path string, body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		body, _ = json.Marshal(v)
+	}
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
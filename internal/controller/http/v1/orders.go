@@ -0,0 +1,179 @@
+package v1
+
+import (
+	mw "balance_api/internal/controller/http/v1/middleware"
+	"balance_api/internal/entity"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultOrdersLimit = 50
+
+var orderStatuses = map[string]int{
+	"pending":            entity.StatusPending,
+	"approved":           entity.StatusApproved,
+	"canceled":           entity.StatusCanceled,
+	"refunded":           entity.StatusRefunded,
+	"partially_refunded": entity.StatusPartiallyRefunded,
+	"expired":            entity.StatusExpired,
+}
+
+type orderResponse struct {
+	ID        int                  `json:"id" example:"1"`
+	UserID    int                  `json:"user_id" example:"1"`
+	ServiceID int                  `json:"service_id" example:"1"`
+	Service   string               `json:"service" example:"Rent"`
+	Sum       string               `json:"sum" example:"200.00"`
+	Captured  string               `json:"captured,omitempty" example:"150.00"`
+	Refunded  string               `json:"refunded" example:"0.00"`
+	Status    string               `json:"status" example:"Approved"`
+	Created   time.Time            `json:"created"`
+	Modified  time.Time            `json:"modified"`
+	Expires   *time.Time           `json:"expires,omitempty"`
+	Comment   string               `json:"comment,omitempty" example:"rent for October"`
+	Metadata  entity.Metadata      `json:"metadata,omitempty"`
+	Events    []orderEventResponse `json:"events,omitempty"`
+}
+
+type orderEventResponse struct {
+	From   string    `json:"from,omitempty" example:"Pending"`
+	To     string    `json:"to" example:"Approved"`
+	Amount string    `json:"amount" example:"150.00"`
+	Actor  string    `json:"actor" example:"api:support"`
+	Reason string    `json:"reason,omitempty" example:"customer request"`
+	Time   time.Time `json:"time"`
+}
+
+func newOrderResponse(o entity.Order) orderResponse {
+	res := orderResponse{
+		ID:        o.ID,
+		UserID:    o.UserID,
+		ServiceID: o.ServiceID,
+		Service:   o.ServiceName,
+		Sum:       o.Sum,
+		Captured:  o.Captured,
+		Refunded:  o.Refunded,
+		Status:    o.Status,
+		Created:   o.Time.Time,
+		Modified:  o.Modified.Time,
+		Comment:   o.Comment,
+		Metadata:  o.Metadata,
+	}
+	if o.Expires != nil {
+		res.Expires = &o.Expires.Time
+	}
+	for _, e := range o.Events {
+		res.Events = append(res.Events, orderEventResponse{
+			From:   e.FromStatus,
+			To:     e.ToStatus,
+			Amount: e.Amount,
+			Actor:  e.Actor,
+			Reason: e.Reason,
+			Time:   e.Time,
+		})
+	}
+	return res
+}
+
+// @Summary     getOrder
+// @Description Returns order with its user, service, sums, status and time of creation and last status change.
+// @Description Events are the timeline of order status changes from old to new
+// @Tags  	    order
+// @Produce     json
+// @Param       id path int true "order id" minimum(1) example(1)
+// @Success     200 {object} orderResponse
+// @Failure     400 {object} response
+// @Failure     404 {object} response
+// @Failure     500 {object} response
+// @Router      /order/{id} [get]
+func (r *balanceRouters) getOrder(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		r.l.Infof("err \"%s\" with order id: %s", err, c.Param("id"))
+		errorResponse(c, http.StatusBadRequest, "Invalid order id")
+		return
+	}
+	order, err := r.b.GetOrder(c.Request.Context(), id)
+	switch {
+	case errors.Is(err, entity.ErrOrderNoExists):
+		r.l.Infof("err \"%s\" with order id: %d", err, id)
+		errorResponse(c, http.StatusNotFound, "No such order")
+		return
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	c.JSON(http.StatusOK, newOrderResponse(order))
+}
+
+type ordersGetRequest struct {
+	UserID       int       `form:"user_id" binding:"omitempty,gte=1"`
+	ServiceID    int       `form:"service_id" binding:"omitempty,gte=1"`
+	Status       string    `form:"status" binding:"omitempty,oneof=pending approved canceled refunded partially_refunded expired"`
+	CreatedFrom  time.Time `form:"created_from" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedTo    time.Time `form:"created_to" time_format:"2006-01-02T15:04:05Z07:00"`
+	ModifiedFrom time.Time `form:"modified_from" time_format:"2006-01-02T15:04:05Z07:00"`
+	ModifiedTo   time.Time `form:"modified_to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit        int       `form:"limit" binding:"omitempty,gte=1,lte=200"`
+	Cursor       string    `form:"cursor"`
+}
+
+type ordersResponse struct {
+	Orders []orderResponse `json:"orders"`
+	Next   string          `json:"next,omitempty"`
+}
+
+// @Summary     getOrders
+// @Description Returns orders matching filters from new to old. Response has cursor of the next page if there is one
+// @Tags  	    order
+// @Produce     json
+// @Param       user_id query int false "user id" minimum(1)
+// @Param       service_id query int false "service id" minimum(1)
+// @Param       status query string false "order status" Enums(pending, approved, canceled, refunded, partially_refunded, expired)
+// @Param       created_from query string false "orders created since, RFC3339"
+// @Param       created_to query string false "orders created before, RFC3339"
+// @Param       modified_from query string false "orders changed since, RFC3339"
+// @Param       modified_to query string false "orders changed before, RFC3339"
+// @Param       limit query int false "page size, 50 by default" minimum(1) maximum(200)
+// @Param       cursor query string false "next page cursor from previous response"
+// @Success     200 {object} ordersResponse
+// @Failure     400 {object} response
+// @Failure     500 {object} response
+// @Router      /orders [get]
+func (r *balanceRouters) getOrders(c *gin.Context) {
+	q := mw.GetQueryParams[ordersGetRequest](c)
+	after, err := decodeOrderCursor(q.Cursor)
+	if err != nil {
+		r.l.Infof("err \"%s\" with request params: %v", err, q)
+		errorResponse(c, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultOrdersLimit
+	}
+	orders, next, err := r.b.GetOrders(c.Request.Context(), entity.OrderFilter{
+		UserID:       q.UserID,
+		ServiceID:    q.ServiceID,
+		StatusID:     orderStatuses[q.Status],
+		CreatedFrom:  q.CreatedFrom.UTC(),
+		CreatedTo:    q.CreatedTo.UTC(),
+		ModifiedFrom: q.ModifiedFrom.UTC(),
+		ModifiedTo:   q.ModifiedTo.UTC(),
+		After:        after,
+		Limit:        q.Limit,
+	})
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	res := ordersResponse{Orders: make([]orderResponse, 0, len(orders)), Next: encodeOrderCursor(next)}
+	for _, o := range orders {
+		res.Orders = append(res.Orders, newOrderResponse(o))
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,163 @@
+package v1
+
+import (
+	"balance_api/internal/entity"
+	ucmock "balance_api/internal/mocks/usecase"
+	"balance_api/pkg/logger"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetOrder(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	created := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	order := entity.Order{ID: 1, UserID: 2, ServiceID: 3, ServiceName: "Rent", Sum: "200.00", Captured: "150.00",
+		Refunded: "0.00", StatusID: entity.StatusApproved, Status: "Approved", Time: entity.MyTime{Time: created},
+		Modified: entity.MyTime{Time: created.Add(time.Hour)}, Comment: "rent for October",
+		Metadata: entity.Metadata{"invoice": "INV-42"},
+		Events: []entity.OrderEvent{
+			{OrderID: 1, ToStatusID: 1, ToStatus: "Pending", Amount: "200.00", Actor: "api", Time: created},
+			{OrderID: 1, FromStatusID: 1, FromStatus: "Pending", ToStatusID: 2, ToStatus: "Approved", Amount: "150.00",
+				Actor: "api:support", Reason: "delivered", Time: created.Add(time.Hour)},
+		}}
+	uc.On("GetOrder", ctx, 1).Return(order, nil)
+	uc.On("GetOrder", ctx, 2).Return(entity.Order{}, entity.ErrOrderNoExists)
+	uc.On("GetOrder", ctx, 3).Return(entity.Order{}, errors.New("aboba"))
+
+	type testCases struct {
+		name    string
+		id      string
+		expCode int
+		resp    interface{}
+	}
+
+	cases := []testCases{{
+		name:    "valid",
+		id:      "1",
+		expCode: http.StatusOK,
+		resp: orderResponse{ID: 1, UserID: 2, ServiceID: 3, Service: "Rent", Sum: "200.00", Captured: "150.00",
+			Refunded: "0.00", Status: "Approved", Created: created, Modified: created.Add(time.Hour),
+			Comment: "rent for October", Metadata: entity.Metadata{"invoice": "INV-42"},
+			Events: []orderEventResponse{
+				{To: "Pending", Amount: "200.00", Actor: "api", Time: created},
+				{From: "Pending", To: "Approved", Amount: "150.00", Actor: "api:support", Reason: "delivered",
+					Time: created.Add(time.Hour)},
+			}},
+	}, {
+		name:    "wrong id",
+		id:      "a",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid order id"},
+	}, {
+		name:    "no such order",
+		id:      "2",
+		expCode: http.StatusNotFound,
+		resp:    response{Msg: "No such order"},
+	}, {
+		name:    "db error",
+		id:      "3",
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Database error"},
+	},
+	}
+
+	for _, tc := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "/v1/order/"+tc.id, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String(), tc.name)
+	}
+}
+
+func TestGetOrders(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	created := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	order := entity.Order{ID: 1, UserID: 2, ServiceID: 3, ServiceName: "Rent", Sum: "200.00", Refunded: "0.00",
+		StatusID: entity.StatusPending, Status: "Pending", Time: entity.MyTime{Time: created},
+		Modified: entity.MyTime{Time: created}, Expires: &entity.MyTime{Time: created.Add(time.Hour)}}
+	next := &entity.OrderCursor{Created: created, ID: 1}
+	expires := created.Add(time.Hour)
+	resp := orderResponse{ID: 1, UserID: 2, ServiceID: 3, Service: "Rent", Sum: "200.00", Refunded: "0.00",
+		Status: "Pending", Created: created, Modified: created, Expires: &expires}
+
+	uc.On("GetOrders", ctx, entity.OrderFilter{UserID: 2, StatusID: entity.StatusPending, Limit: 1}).
+		Return([]entity.Order{order}, next, nil)
+	uc.On("GetOrders", ctx, entity.OrderFilter{UserID: 2, StatusID: entity.StatusPending, After: next, Limit: 1}).
+		Return([]entity.Order{}, nil, nil)
+	uc.On("GetOrders", ctx, entity.OrderFilter{CreatedFrom: created, Limit: defaultOrdersLimit}).
+		Return(nil, nil, errors.New("aboba"))
+
+	type testCases struct {
+		name    string
+		query   string
+		expCode int
+		resp    interface{}
+	}
+
+	cases := []testCases{{
+		name:    "first page",
+		query:   "?user_id=2&status=pending&limit=1",
+		expCode: http.StatusOK,
+		resp:    ordersResponse{Orders: []orderResponse{resp}, Next: encodeOrderCursor(next)},
+	}, {
+		name:    "last page",
+		query:   "?user_id=2&status=pending&limit=1&cursor=" + encodeOrderCursor(next),
+		expCode: http.StatusOK,
+		resp:    ordersResponse{Orders: []orderResponse{}},
+	}, {
+		name:    "wrong status",
+		query:   "?status=aboba",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "wrong cursor",
+		query:   "?cursor=aboba",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid cursor"},
+	}, {
+		name:    "db error",
+		query:   "?created_from=2022-10-24T16:00:00%2B03:00",
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Database error"},
+	},
+	}
+
+	for _, tc := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "/v1/orders"+tc.query, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String(), tc.name)
+	}
+}
+
+func TestOrderCursor(t *testing.T) {
+	c := &entity.OrderCursor{Created: time.Date(2022, 10, 24, 13, 0, 0, 123456000, time.UTC), ID: 42}
+	res, err := decodeOrderCursor(encodeOrderCursor(c))
+	require.NoError(t, err)
+	require.Equal(t, c, res)
+
+	res, err = decodeOrderCursor("")
+	require.NoError(t, err)
+	require.Nil(t, res)
+}
@@ -0,0 +1,282 @@
+package v1
+
+import (
+	mw "balance_api/internal/controller/http/v1/middleware"
+	"balance_api/internal/entity"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultReportsLimit = 50
+
+type reportsGetRequest struct {
+	Limit int `form:"limit" binding:"omitempty,gte=1,lte=200"`
+}
+
+type savedReportResponse struct {
+	Name     string    `json:"name" example:"2022-10.csv"`
+	Period   string    `json:"period" example:"2022-10"`
+	TimeZone string    `json:"time_zone" example:"UTC"`
+	Format   string    `json:"format" example:"csv"`
+	Size     int64     `json:"size" example:"1024"`
+	Checksum string    `json:"checksum" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	Created  time.Time `json:"created"`
+	Link     string    `json:"link" example:"localhost:8080/v1/reports/2022-10.csv"`
+}
+
+// @Summary     getReports
+// @Description Returns known report files, the latest generated first, with their size and sha256 checksum
+// @Tags  	    report
+// @Produce     json
+// @Param       limit query int false "max number of reports, 50 by default" minimum(1) maximum(200)
+// @Success     200 {array} savedReportResponse
+// @Failure     400 {object} response
+// @Failure     500 {object} response
+// @Router      /reports [get]
+func (r *balanceRouters) getReports(c *gin.Context) {
+	q := mw.GetQueryParams[reportsGetRequest](c)
+	if q.Limit == 0 {
+		q.Limit = defaultReportsLimit
+	}
+	reports, err := r.b.GetSavedReports(c.Request.Context(), q.Limit)
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	res := make([]savedReportResponse, 0, len(reports))
+	for _, v := range reports {
+		res = append(res, savedReportResponse{
+			Name:     v.Name,
+			Period:   v.Period,
+			TimeZone: v.TimeZone,
+			Format:   v.Format,
+			Size:     v.Size,
+			Checksum: v.Checksum,
+			Created:  v.Created,
+			Link:     c.Request.Host + c.Request.URL.Path + "/" + v.Name,
+		})
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// @Summary     getReport
+// @Description Returns known report file with content type of its format, or redirects to pre-signed link to it
+// @Description when reports are kept in S3-compatible storage. ETag is a sha256 checksum of the file, request
+// @Description with matching If-None-Match gets 304, Range header is supported for files kept on disk
+// @Tags  	    report
+// @Produce     plain,json,html,octet-stream
+// @Param       name path string true "file name"
+// @Param       If-None-Match header string false "ETag of cached file"
+// @Param       Range header string false "byte range" example(bytes=0-99)
+// @Success     200 {file} file
+// @Success     206 {file} file
+// @Success     302
+// @Success     304
+// @Failure     404 {object} response
+// @Failure     500 {object} response
+// @Router      /reports/{name} [get]
+func (r *balanceRouters) getReport(c *gin.Context) {
+	name := c.Param("name")
+	report, err := r.b.GetSavedReport(c.Request.Context(), name)
+	switch {
+	case errors.Is(err, entity.ErrNoReport):
+		r.l.Infof("err \"%s\" with report name: %s", err, name)
+		errorResponse(c, http.StatusNotFound, "No such report")
+		return
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	etag := `"` + report.Checksum + `"`
+	c.Header("ETag", etag)
+
+	dir := r.b.GetReportDir()
+	if dir == "" {
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		url, err := r.b.GetReportURL(c.Request.Context(), report.Name)
+		if err != nil {
+			r.l.Error(err)
+			errorResponse(c, http.StatusInternalServerError, "Storage error")
+			return
+		}
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+
+	// name is taken from the list of known reports, so it can't point outside of report dir
+	file, err := os.Open(filepath.Join(dir, report.Name))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		r.l.Warnf("report %s is known but its file is missing in %s", report.Name, dir)
+		errorResponse(c, http.StatusNotFound, "No such report")
+		return
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Storage error")
+		return
+	}
+	defer file.Close()
+	if t := r.b.GetReportContentType(report.Name); t != "" {
+		c.Header("Content-Type", t)
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+report.Name+`"`)
+	// ServeContent answers If-None-Match by ETag header and serves byte ranges
+	http.ServeContent(c.Writer, c.Request, report.Name, report.Created, file)
+}
+
+// etagMatches checks if If-None-Match header has etag or is a wildcard
+func etagMatches(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+type reportJobResponse struct {
+	ID        int        `json:"id" example:"1"`
+	Status    string     `json:"status" example:"done"`
+	Progress  int        `json:"progress" example:"100"`
+	Period    string     `json:"period" example:"2022-07-01/2022-09-30"`
+	TimeZone  string     `json:"time_zone" example:"Europe/Moscow"`
+	Breakdown string     `json:"breakdown,omitempty" example:"month"`
+	GroupBy   []string   `json:"group_by" example:"user"`
+	Format    string     `json:"format" example:"csv"`
+	Link      string     `json:"link,omitempty" example:"localhost:8080/v1/reports/2022-10.csv"`
+	Error     string     `json:"error,omitempty" example:"no any operations in this period"`
+	Created   time.Time  `json:"created"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+}
+
+// newReportJobResponse makes response of job, link is set for done job only
+func newReportJobResponse(job entity.ReportJob, link string) reportJobResponse {
+	res := reportJobResponse{
+		ID:        job.ID,
+		Status:    job.Status,
+		Progress:  job.Progress,
+		Period:    job.Params.Period(),
+		TimeZone:  job.Params.Location.String(),
+		Breakdown: job.Params.Breakdown,
+		Format:    job.Format,
+		Error:     job.Error,
+		Created:   job.Created,
+		Started:   job.Started,
+		Finished:  job.Finished,
+	}
+	if job.Params.ByService {
+		res.GroupBy = append(res.GroupBy, "service")
+	}
+	if job.Params.ByUser {
+		res.GroupBy = append(res.GroupBy, "user")
+	}
+	if job.Status == entity.ReportJobDone {
+		res.Link = link
+	}
+	return res
+}
+
+// @Summary     createReportJob
+// @Description Queues generation of report file in background, takes the same parameters as GET /report.
+// @Description Status of job is returned by link in Location header, done job has a link to the file
+// @Tags  	    report
+// @Accept      json
+// @Produce     json
+// @Param       request body reportRequest true "month or days, time zone, breakdown, grouping and format"
+// @Success     202 {object} reportJobResponse
+// @Failure     400 {object} response
+// @Failure     500 {object} response
+// @Router      /reports [post]
+func (r *balanceRouters) createReportJob(c *gin.Context) {
+	b := mw.GetJSONBody[reportRequest](c)
+	if b.Format == "" {
+		b.Format = "csv"
+	}
+	params, msg := b.params()
+	if msg != "" {
+		r.l.Infof("err \"%s\" with request body: %v", msg, b)
+		errorResponse(c, http.StatusBadRequest, msg)
+		return
+	}
+	job, err := r.b.CreateReportJob(c.Request.Context(), params, b.Format)
+	switch {
+	case errors.Is(err, entity.ErrUnknownFormat):
+		r.l.Infof("err \"%s\" with request body: %v", err, b)
+		errorResponse(c, http.StatusBadRequest, "Unknown format")
+		return
+	case errors.Is(err, entity.ErrReportPeriods):
+		r.l.Infof("err \"%s\" with request body: %v", err, b)
+		errorResponse(c, http.StatusBadRequest, "Too many breakdown periods")
+		return
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	c.Header("Location", c.Request.URL.Path+"/jobs/"+strconv.Itoa(job.ID))
+	c.JSON(http.StatusAccepted, newReportJobResponse(job, ""))
+}
+
+// @Summary     getReportJob
+// @Description Returns status of report job: queued, running, done or failed, progress in percents and
+// @Description link to the file of done job or reason of failed one
+// @Tags  	    report
+// @Produce     json
+// @Param       id path int true "job id" minimum(1) example(1)
+// @Success     200 {object} reportJobResponse
+// @Failure     400 {object} response
+// @Failure     404 {object} response
+// @Failure     500 {object} response
+// @Router      /reports/jobs/{id} [get]
+func (r *balanceRouters) getReportJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		r.l.Infof("err \"%s\" with job id: %s", err, c.Param("id"))
+		errorResponse(c, http.StatusBadRequest, "Invalid job id")
+		return
+	}
+	job, err := r.b.GetReportJob(c.Request.Context(), id)
+	switch {
+	case errors.Is(err, entity.ErrNoReportJob):
+		r.l.Infof("err \"%s\" with job id: %d", err, id)
+		errorResponse(c, http.StatusNotFound, "No such job")
+		return
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	var link string
+	if job.Status == entity.ReportJobDone {
+		link, err = r.reportLink(c, strings.TrimSuffix(c.Request.URL.Path, "jobs/"+c.Param("id")), job.Name)
+		if err != nil {
+			r.l.Error(err)
+			errorResponse(c, http.StatusInternalServerError, "Storage error")
+			return
+		}
+	}
+	c.JSON(http.StatusOK, newReportJobResponse(job, link))
+}
+
+// reportLink returns pre-signed link to report file or link to it on reports path of api when storage has no links
+func (r *balanceRouters) reportLink(c *gin.Context, reports, name string) (string, error) {
+	url, err := r.b.GetReportURL(c.Request.Context(), name)
+	if err != nil || url != "" {
+		return url, err
+	}
+	return c.Request.Host + reports + name, nil
+}
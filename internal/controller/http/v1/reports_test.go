@@ -0,0 +1,343 @@
+package v1
+
+import (
+	"balance_api/internal/entity"
+	ucmock "balance_api/internal/mocks/usecase"
+	"balance_api/pkg/logger"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateReportJob(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	created := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	days := entity.ReportParams{From: time.Date(2022, 7, 1, 0, 0, 0, 0, moscow),
+		To: time.Date(2022, 9, 30, 0, 0, 0, 0, moscow), Location: moscow, Breakdown: "month", ByUser: true}
+	month := entity.ReportParams{From: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		To: time.Date(2022, 10, 31, 0, 0, 0, 0, time.UTC), Location: time.UTC}
+	queued := days
+	queued.ByService = true
+	uc.On("CreateReportJob", ctx, days, "xlsx").Return(entity.ReportJob{ID: 7, Params: queued, Format: "xlsx",
+		Status: entity.ReportJobQueued, Created: created}, nil)
+	uc.On("CreateReportJob", ctx, month, "csv").Return(entity.ReportJob{}, errors.New("aboba"))
+	uc.On("CreateReportJob", ctx, month, "html").Return(entity.ReportJob{}, entity.ErrUnknownFormat)
+
+	type testCases struct {
+		name     string
+		body     string
+		expCode  int
+		location string
+		resp     interface{}
+	}
+
+	cases := []testCases{{
+		name: "valid",
+		body: `{"from": "2022-07-01", "to": "2022-09-30", "time_zone": "Europe/Moscow", "breakdown": "month",
+			"group_by": ["user"], "format": "xlsx"}`,
+		expCode:  http.StatusAccepted,
+		location: "/v1/reports/jobs/7",
+		resp: reportJobResponse{ID: 7, Status: "queued", Period: "2022-07-01/2022-09-30", TimeZone: "Europe/Moscow",
+			Breakdown: "month", GroupBy: []string{"service", "user"}, Format: "xlsx", Created: created},
+	}, {
+		name:    "no period",
+		body:    `{"format": "csv"}`,
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Report needs either year and month or from and to"},
+	}, {
+		name:    "wrong date",
+		body:    `{"from": "2022.07.01", "to": "2022-09-30"}`,
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request body format"},
+	}, {
+		name:    "format not set up",
+		body:    `{"year": 2022, "month": 10, "format": "html"}`,
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Unknown format"},
+	}, {
+		name:    "db error",
+		body:    `{"year": 2022, "month": 10}`,
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Database error"},
+	},
+	}
+
+	for _, tc := range cases {
+		r, _ := http.NewRequest(http.MethodPost, "/v1/reports", strings.NewReader(tc.body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		require.Equal(t, tc.location, w.Header().Get("Location"), tc.name)
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String(), tc.name)
+	}
+}
+
+func TestGetReportJob(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	created := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	started, finished := created.Add(time.Second), created.Add(time.Minute)
+	params := entity.ReportParams{From: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		To: time.Date(2022, 10, 31, 0, 0, 0, 0, time.UTC), Location: time.UTC, ByService: true}
+	uc.On("GetReportJob", ctx, 1).Return(entity.ReportJob{ID: 1, Params: params, Format: "csv",
+		Status: entity.ReportJobDone, Progress: 100, Name: "2022-10.csv", Created: created, Started: &started,
+		Finished: &finished}, nil)
+	uc.On("GetReportJob", ctx, 2).Return(entity.ReportJob{ID: 2, Params: params, Format: "csv",
+		Status: entity.ReportJobFailed, Error: "no any operations in this period", Created: created, Started: &started,
+		Finished: &finished}, nil)
+	uc.On("GetReportJob", ctx, 3).Return(entity.ReportJob{ID: 3, Params: params, Format: "json",
+		Status: entity.ReportJobRunning, Progress: 50, Created: created, Started: &started}, nil)
+	uc.On("GetReportJob", ctx, 4).Return(entity.ReportJob{}, entity.ErrNoReportJob)
+	uc.On("GetReportJob", ctx, 5).Return(entity.ReportJob{}, errors.New("aboba"))
+	uc.On("GetReportJob", ctx, 6).Return(entity.ReportJob{ID: 6, Params: params, Format: "xlsx",
+		Status: entity.ReportJobDone, Progress: 100, Name: "2022-10.xlsx", Created: created, Started: &started,
+		Finished: &finished}, nil)
+	uc.On("GetReportURL", ctx, "2022-10.csv").Return("", nil)
+	uc.On("GetReportURL", ctx, "2022-10.xlsx").
+		Return("http://localhost:9000/reports/2022-10.xlsx?X-Amz-Signature=a", nil)
+
+	type testCases struct {
+		name    string
+		id      string
+		expCode int
+		resp    interface{}
+	}
+
+	cases := []testCases{{
+		name:    "done",
+		id:      "1",
+		expCode: http.StatusOK,
+		resp: reportJobResponse{ID: 1, Status: "done", Progress: 100, Period: "2022-10", TimeZone: "UTC",
+			GroupBy: []string{"service"}, Format: "csv", Link: "/v1/reports/2022-10.csv", Created: created,
+			Started: &started, Finished: &finished},
+	}, {
+		name:    "failed",
+		id:      "2",
+		expCode: http.StatusOK,
+		resp: reportJobResponse{ID: 2, Status: "failed", Period: "2022-10", TimeZone: "UTC",
+			GroupBy: []string{"service"}, Format: "csv", Error: "no any operations in this period",
+			Created: created, Started: &started, Finished: &finished},
+	}, {
+		name:    "running",
+		id:      "3",
+		expCode: http.StatusOK,
+		resp: reportJobResponse{ID: 3, Status: "running", Progress: 50, Period: "2022-10", TimeZone: "UTC",
+			GroupBy: []string{"service"}, Format: "json", Created: created, Started: &started},
+	}, {
+		name:    "no job",
+		id:      "4",
+		expCode: http.StatusNotFound,
+		resp:    response{Msg: "No such job"},
+	}, {
+		name:    "db error",
+		id:      "5",
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Database error"},
+	}, {
+		name:    "done in s3",
+		id:      "6",
+		expCode: http.StatusOK,
+		resp: reportJobResponse{ID: 6, Status: "done", Progress: 100, Period: "2022-10", TimeZone: "UTC",
+			GroupBy: []string{"service"}, Format: "xlsx",
+			Link: "http://localhost:9000/reports/2022-10.xlsx?X-Amz-Signature=a", Created: created,
+			Started: &started, Finished: &finished},
+	}, {
+		name:    "wrong id",
+		id:      "a",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid job id"},
+	},
+	}
+
+	for _, tc := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "/v1/reports/jobs/"+tc.id, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String(), tc.name)
+	}
+}
+
+func TestGetReports(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	created := time.Date(2022, 11, 1, 10, 0, 0, 0, time.UTC)
+	saved := entity.SavedReport{Name: "2022-10.csv", Period: "2022-10", TimeZone: "UTC", Format: "csv", Size: 42,
+		Checksum: strings.Repeat("a", 64), Created: created}
+	uc.On("GetSavedReports", ctx, defaultReportsLimit).Return([]entity.SavedReport{saved}, nil)
+	uc.On("GetSavedReports", ctx, 10).Return([]entity.SavedReport{}, nil)
+	uc.On("GetSavedReports", ctx, 20).Return(nil, errors.New("aboba"))
+
+	for _, tc := range []struct {
+		name    string
+		query   string
+		expCode int
+		resp    interface{}
+	}{{
+		name:    "default limit",
+		expCode: http.StatusOK,
+		resp: []savedReportResponse{{Name: "2022-10.csv", Period: "2022-10", TimeZone: "UTC", Format: "csv",
+			Size: 42, Checksum: strings.Repeat("a", 64), Created: created, Link: "/v1/reports/2022-10.csv"}},
+	}, {
+		name:    "no reports",
+		query:   "?limit=10",
+		expCode: http.StatusOK,
+		resp:    []savedReportResponse{},
+	}, {
+		name:    "wrong limit",
+		query:   "?limit=1000",
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request query"},
+	}, {
+		name:    "db error",
+		query:   "?limit=20",
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Database error"},
+	}} {
+		r, _ := http.NewRequest(http.MethodGet, "/v1/reports"+tc.query, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String(), tc.name)
+	}
+}
+
+func TestGetReport(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	dir := t.TempDir() + "/"
+	for name, content := range map[string]string{"2022-10.json": "{}", "2022-10.html": "<html></html>",
+		"2022-10.txt": "aboba", "unknown.csv": "a,b"} {
+		require.NoError(t, os.WriteFile(dir+name, []byte(content), 0600))
+	}
+	created := time.Date(2022, 11, 1, 10, 0, 0, 0, time.UTC)
+	known := func(name string) entity.SavedReport {
+		return entity.SavedReport{Name: name, Checksum: strings.Repeat("a", 64), Created: created}
+	}
+	etag := `"` + strings.Repeat("a", 64) + `"`
+	for _, name := range []string{"2022-10.json", "2022-10.html", "2022-10.txt", "2022-11.csv"} {
+		uc.On("GetSavedReport", ctx, name).Return(known(name), nil)
+	}
+	uc.On("GetSavedReport", ctx, "unknown.csv").Return(entity.SavedReport{}, entity.ErrNoReport)
+	uc.On("GetSavedReport", ctx, "..").Return(entity.SavedReport{}, entity.ErrNoReport)
+	uc.On("GetSavedReport", ctx, "2022-12.csv").Return(entity.SavedReport{}, errors.New("aboba"))
+	uc.On("GetReportDir").Return(dir)
+	uc.On("GetReportContentType", "2022-10.json").Return("application/json")
+	uc.On("GetReportContentType", "2022-10.html").Return("text/html; charset=utf-8")
+	uc.On("GetReportContentType", "2022-10.txt").Return("")
+
+	for _, tc := range []struct {
+		name, path  string
+		header      map[string]string
+		expCode     int
+		contentType string
+		body        string
+	}{
+		{name: "json", path: "2022-10.json", expCode: http.StatusOK, contentType: "application/json", body: "{}"},
+		{name: "html", path: "2022-10.html", expCode: http.StatusOK, contentType: "text/html; charset=utf-8",
+			body: "<html></html>"},
+		{name: "content type by extension", path: "2022-10.txt", expCode: http.StatusOK,
+			contentType: "text/plain; charset=utf-8", body: "aboba"},
+		{name: "range", path: "2022-10.txt", header: map[string]string{"Range": "bytes=1-3"},
+			expCode: http.StatusPartialContent, contentType: "text/plain; charset=utf-8", body: "bob"},
+		{name: "cached", path: "2022-10.txt", header: map[string]string{"If-None-Match": etag},
+			expCode: http.StatusNotModified},
+		{name: "changed", path: "2022-10.txt", header: map[string]string{"If-None-Match": `"b"`},
+			expCode: http.StatusOK, contentType: "text/plain; charset=utf-8", body: "aboba"},
+		{name: "file on disk isn't known", path: "unknown.csv", expCode: http.StatusNotFound,
+			contentType: "application/json; charset=utf-8", body: `{"error":"No such report"}`},
+		{name: "path outside of dir", path: "..%2Fconfig.env", expCode: http.StatusNotFound,
+			contentType: "text/plain", body: "404 page not found"},
+		{name: "dots", path: "..", expCode: http.StatusNotFound,
+			contentType: "application/json; charset=utf-8", body: `{"error":"No such report"}`},
+		{name: "known file is missing", path: "2022-11.csv", expCode: http.StatusNotFound,
+			contentType: "application/json; charset=utf-8", body: `{"error":"No such report"}`},
+		{name: "db error", path: "2022-12.csv", expCode: http.StatusInternalServerError,
+			contentType: "application/json; charset=utf-8", body: `{"error":"Database error"}`},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/v1/reports/"+tc.path, nil)
+		for k, v := range tc.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		require.Equal(t, tc.contentType, w.Header().Get("Content-Type"), tc.name)
+		require.Equal(t, tc.body, w.Body.String(), tc.name)
+		if tc.expCode == http.StatusOK || tc.expCode == http.StatusPartialContent {
+			require.Equal(t, etag, w.Header().Get("ETag"), tc.name)
+			require.Equal(t, `attachment; filename="`+tc.path+`"`, w.Header().Get("Content-Disposition"), tc.name)
+		}
+	}
+}
+
+func TestGetReportRedirect(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	etag := `"` + strings.Repeat("a", 64) + `"`
+	for _, name := range []string{"2022-10.csv", "2022-11.csv"} {
+		uc.On("GetSavedReport", ctx, name).Return(entity.SavedReport{Name: name, Checksum: strings.Repeat("a", 64)},
+			nil)
+	}
+	uc.On("GetReportDir").Return("")
+	uc.On("GetReportURL", ctx, "2022-10.csv").Return("http://localhost:9000/reports/2022-10.csv?X-Amz-Signature=a", nil)
+	uc.On("GetReportURL", ctx, "2022-11.csv").Return("", errors.New("aboba"))
+
+	for _, tc := range []struct {
+		name, path, ifNoneMatch string
+		expCode                 int
+		location, body          string
+	}{
+		{name: "redirect", path: "2022-10.csv", expCode: http.StatusFound,
+			location: "http://localhost:9000/reports/2022-10.csv?X-Amz-Signature=a"},
+		{name: "cached", path: "2022-10.csv", ifNoneMatch: `"b", ` + etag, expCode: http.StatusNotModified},
+		{name: "storage error", path: "2022-11.csv", expCode: http.StatusInternalServerError,
+			body: `{"error":"Storage error"}`},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/v1/reports/"+tc.path, nil)
+		if tc.ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", tc.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		require.Equal(t, tc.location, w.Header().Get("Location"), tc.name)
+		if tc.body != "" {
+			require.Equal(t, tc.body, w.Body.String(), tc.name)
+		}
+	}
+}
@@ -23,5 +23,7 @@ func NewRouter(handler *gin.Engine, b usecase.Balance, l logger.Interface) {
 	h := handler.Group("/v1")
 	{
 		newBalanceRoutes(h, b, l)
+		newServiceRoutes(h.Group("/services"), b, l)
+		newAdminRoutes(h.Group("/admin"), b, l)
 	}
 }
@@ -0,0 +1,123 @@
+package v1
+
+import (
+	mw "balance_api/internal/controller/http/v1/middleware"
+	"balance_api/internal/entity"
+	"balance_api/internal/usecase"
+	"balance_api/pkg/logger"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type serviceRouters struct {
+	b usecase.Balance
+	l logger.Interface
+}
+
+func newServiceRoutes(handler *gin.RouterGroup, b usecase.Balance, l logger.Interface) {
+	r := &serviceRouters{
+		b: b,
+		l: l,
+	}
+
+	handler.GET("", r.getServices)
+	handler.POST("", mw.ValidateJSONBody[servicePostRequest](r.l), r.createService)
+	handler.PATCH("/:id", mw.ValidateJSONBody[servicePatchRequest](r.l), r.updateService)
+}
+
+// @Summary     getServices
+// @Description Returns all services, deactivated ones too
+// @Tags  	    services
+// @Produce     json
+// @Success     200 {array} entity.Service
+// @Failure     500 {object} response
+// @Router      /services [get]
+func (r *serviceRouters) getServices(c *gin.Context) {
+	services, err := r.b.GetServices(c.Request.Context())
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	c.JSON(http.StatusOK, services)
+}
+
+type servicePostRequest struct {
+	Name string `json:"name" binding:"required" example:"Delivery"`
+}
+
+// @Summary     createService
+// @Description Creates new active service
+// @Tags  	    services
+// @Accept      json
+// @Produce     json
+// @Param       request body servicePostRequest true "service name"
+// @Success     200 {object} entity.Service
+// @Failure     400 {object} response
+// @Failure     500 {object} response
+// @Router      /services [post]
+func (r *serviceRouters) createService(c *gin.Context) {
+	b := mw.GetJSONBody[servicePostRequest](c)
+	service, err := r.b.CreateService(c.Request.Context(), b.Name)
+	if ok := r.handleServiceErr(c, err, b); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, service)
+}
+
+type servicePatchRequest struct {
+	Name   *string `json:"name" binding:"required_without=Active" example:"Delivery"`
+	Active *bool   `json:"active" binding:"required_without=Name" example:"false"`
+}
+
+// @Summary     updateService
+// @Description Renames, deactivates or activates service. Deactivated service can't be used for new orders,
+// @Description its existing orders and reports stay as they are
+// @Tags  	    services
+// @Accept      json
+// @Produce     json
+// @Param       id path int true "service id" minimum(1) example(1)
+// @Param       request body servicePatchRequest true "new name or state, at least one of them"
+// @Success     200 {object} entity.Service
+// @Failure     400 {object} response
+// @Failure     500 {object} response
+// @Router      /services/{id} [patch]
+func (r *serviceRouters) updateService(c *gin.Context) {
+	b := mw.GetJSONBody[servicePatchRequest](c)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		r.l.Infof("err \"%s\" with service id: %s", err, c.Param("id"))
+		errorResponse(c, http.StatusBadRequest, "Invalid service id")
+		return
+	}
+	service, err := r.b.UpdateService(c.Request.Context(),
+		entity.ServiceUpdate{ID: id, Name: b.Name, Active: b.Active})
+	if ok := r.handleServiceErr(c, err, b); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, service)
+}
+
+// handleServiceErr writes error response and returns false if err isn't nil
+func (r *serviceRouters) handleServiceErr(c *gin.Context, err error, b interface{}) bool {
+	errMsg := ""
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, entity.ErrNoService):
+		errMsg = "No such service"
+	case errors.Is(err, entity.ErrServiceExists):
+		errMsg = "Service with this name already exists"
+	case errors.Is(err, entity.ErrServiceName):
+		errMsg = "Service name must be from 1 to 55 characters"
+	default:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "Database error")
+		return false
+	}
+	r.l.Infof("err \"%s\" with request params: %v", err, b)
+	errorResponse(c, http.StatusBadRequest, errMsg)
+	return false
+}
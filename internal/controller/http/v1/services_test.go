@@ -0,0 +1,170 @@
+package v1
+
+import (
+	"balance_api/internal/entity"
+	ucmock "balance_api/internal/mocks/usecase"
+	"balance_api/pkg/logger"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetServices(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	services := []entity.Service{{ID: 1, Name: "Rent", Active: true}, {ID: 2, Name: "Good bought"}}
+	uc.On("GetServices", ctx).Return(services, nil).Once()
+	uc.On("GetServices", ctx).Return(nil, errors.New("aboba")).Once()
+
+	for _, tc := range []struct {
+		name    string
+		expCode int
+		resp    interface{}
+	}{
+		{name: "valid", expCode: http.StatusOK, resp: services},
+		{name: "db error", expCode: http.StatusInternalServerError, resp: response{Msg: "Database error"}},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/v1/services", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String(), tc.name)
+	}
+}
+
+func TestCreateService(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	uc.On("CreateService", ctx, "Delivery").Return(entity.Service{ID: 6, Name: "Delivery", Active: true}, nil)
+	uc.On("CreateService", ctx, "Rent").Return(entity.Service{}, entity.ErrServiceExists)
+	uc.On("CreateService", ctx, " ").Return(entity.Service{}, entity.ErrServiceName)
+	uc.On("CreateService", ctx, "aboba").Return(entity.Service{}, errors.New("aboba"))
+
+	type testCases struct {
+		name    string
+		body    interface{}
+		expCode int
+		resp    interface{}
+	}
+
+	cases := []testCases{{
+		name:    "valid",
+		body:    servicePostRequest{Name: "Delivery"},
+		expCode: http.StatusOK,
+		resp:    entity.Service{ID: 6, Name: "Delivery", Active: true},
+	}, {
+		name:    "no name",
+		body:    struct{}{},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request body format"},
+	}, {
+		name:    "name exists",
+		body:    servicePostRequest{Name: "Rent"},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Service with this name already exists"},
+	}, {
+		name:    "wrong name",
+		body:    servicePostRequest{Name: " "},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Service name must be from 1 to 55 characters"},
+	}, {
+		name:    "db error",
+		body:    servicePostRequest{Name: "aboba"},
+		expCode: http.StatusInternalServerError,
+		resp:    response{Msg: "Database error"},
+	},
+	}
+
+	for _, tc := range cases {
+		body, _ := json.Marshal(tc.body)
+		r, _ := http.NewRequest(http.MethodPost, "/v1/services", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String(), tc.name)
+	}
+}
+
+func TestUpdateService(t *testing.T) {
+	ctx := context.Background()
+	h := gin.New()
+	uc := ucmock.NewBalance(t)
+	l, _ := logger.New("debug")
+	NewRouter(h, uc, l)
+
+	name := "Delivery"
+	inactive := false
+	uc.On("UpdateService", ctx, entity.ServiceUpdate{ID: 1, Name: &name}).
+		Return(entity.Service{ID: 1, Name: "Delivery", Active: true}, nil)
+	uc.On("UpdateService", ctx, entity.ServiceUpdate{ID: 1, Active: &inactive}).
+		Return(entity.Service{ID: 1, Name: "Rent"}, nil)
+	uc.On("UpdateService", ctx, entity.ServiceUpdate{ID: 10, Active: &inactive}).
+		Return(entity.Service{}, entity.ErrNoService)
+
+	type testCases struct {
+		name    string
+		id      string
+		body    interface{}
+		expCode int
+		resp    interface{}
+	}
+
+	cases := []testCases{{
+		name:    "rename",
+		id:      "1",
+		body:    servicePatchRequest{Name: &name},
+		expCode: http.StatusOK,
+		resp:    entity.Service{ID: 1, Name: "Delivery", Active: true},
+	}, {
+		name:    "deactivate",
+		id:      "1",
+		body:    servicePatchRequest{Active: &inactive},
+		expCode: http.StatusOK,
+		resp:    entity.Service{ID: 1, Name: "Rent"},
+	}, {
+		name:    "nothing to update",
+		id:      "1",
+		body:    servicePatchRequest{},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid request body format"},
+	}, {
+		name:    "wrong id",
+		id:      "a",
+		body:    servicePatchRequest{Active: &inactive},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "Invalid service id"},
+	}, {
+		name:    "no such service",
+		id:      "10",
+		body:    servicePatchRequest{Active: &inactive},
+		expCode: http.StatusBadRequest,
+		resp:    response{Msg: "No such service"},
+	},
+	}
+
+	for _, tc := range cases {
+		body, _ := json.Marshal(tc.body)
+		r, _ := http.NewRequest(http.MethodPatch, "/v1/services/"+tc.id, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, tc.expCode, w.Code, tc.name)
+		b, _ := json.Marshal(tc.resp)
+		require.Equal(t, string(b), w.Body.String(), tc.name)
+	}
+}
@@ -1,40 +1,278 @@
 package entity
 
-// Balance -.
+import "time"
+
+// Order statuses, match status table
+const (
+	StatusPending = iota + 1
+	StatusApproved
+	StatusCanceled
+	StatusRefunded
+	StatusPartiallyRefunded
+	StatusExpired
+)
+
+// Sources of order events, actor of api request is "api" or "api:<name>" if client names itself
+const (
+	ActorAPI     = "api"
+	ActorSweeper = "sweeper"
+)
+
+// Balance keeps user's available money in Amount, money held by pending orders in Reserved
 type Balance struct {
-	ID     int    `json:"id" db:"user_id"`
-	Amount string `json:"amount" db:"amount"`
+	ID                int      `json:"id" db:"user_id"`
+	Amount            string   `json:"amount" db:"amount"`
+	Reserved          string   `json:"reserved,omitempty" db:"reserved"`
+	Total             string   `json:"total,omitempty" db:"total"`
+	PendingOrders     int      `json:"pending_orders" db:"pending_orders"`
+	LastReplenishment *MyTime  `json:"last_replenishment,omitempty" db:"last_replenishment"`
+	LastOrder         *MyTime  `json:"last_order,omitempty" db:"last_order"`
+	Comment           string   `json:"-" db:"comment"`
+	Metadata          Metadata `json:"-" db:"metadata"`
 }
 
 // Order -.
 type Order struct {
-	ID          int    `json:"-" db:"order_id"`
-	UserID      int    `json:"-" db:"user_id"`
-	Sum         string `json:"sum" db:"order_sum"`
-	ServiceID   int    `json:"-" db:"service_id"`
-	ServiceName string `json:"service" db:"service_name"`
-	StatusID    int    `json:"-" db:"status_id"`
-	Status      string `json:"status" db:"status_name"`
-	Time        MyTime `json:"time" db:"created"`
+	ID           int           `json:"-" db:"order_id"`
+	UserID       int           `json:"-" db:"user_id"`
+	Sum          string        `json:"sum" db:"order_sum"`
+	Captured     string        `json:"captured,omitempty" db:"captured"`
+	ServiceID    int           `json:"-" db:"service_id"`
+	ServiceName  string        `json:"service" db:"service_name"`
+	StatusID     int           `json:"-" db:"status_id"`
+	Status       string        `json:"status" db:"status_name"`
+	Time         MyTime        `json:"time" db:"created"`
+	Modified     MyTime        `json:"-" db:"modified"`
+	Expires      *MyTime       `json:"-" db:"expires"`
+	Kind         int           `json:"-" db:"kind"`
+	Comment      string        `json:"comment,omitempty" db:"comment"`
+	Metadata     Metadata      `json:"metadata,omitempty" db:"metadata"`
+	Refunded     string        `json:"-" db:"refunded"`
+	TTL          int           `json:"-" db:"ttl"`
+	Actor        string        `json:"-" db:"actor"`
+	Reason       string        `json:"-" db:"reason"`
+	Events       []OrderEvent  `json:"-" db:"-"`
+	BalanceAfter *BalanceAfter `json:"balance_after,omitempty" db:"balance_after"`
+}
+
+// BalanceAfter is user's balance right after operation, it is empty if operation isn't found in the ledger
+type BalanceAfter struct {
+	Available string `json:"available" db:"available"`
+	Reserved  string `json:"reserved" db:"reserved"`
+}
+
+// OrderEvent is a change of order status, FromStatusID is zero for order creation
+type OrderEvent struct {
+	OrderID      int       `db:"order_id"`
+	FromStatusID int       `db:"from_status_id"`
+	FromStatus   string    `db:"from_status"`
+	ToStatusID   int       `db:"to_status_id"`
+	ToStatus     string    `db:"to_status"`
+	Amount       string    `db:"amount"`
+	Actor        string    `db:"actor"`
+	Reason       string    `db:"reason"`
+	Time         time.Time `db:"created"`
+}
+
+// OrderFilter keeps conditions of orders list, zero fields are not used. Orders are listed from new to old
+// starting after the After position
+type OrderFilter struct {
+	UserID       int
+	ServiceID    int
+	StatusID     int
+	CreatedFrom  time.Time
+	CreatedTo    time.Time
+	ModifiedFrom time.Time
+	ModifiedTo   time.Time
+	After        *OrderCursor
+	Limit        int
+}
+
+// OrderCursor is a position in orders list
+type OrderCursor struct {
+	Created time.Time
+	ID      int
+}
+
+// Refund -.
+type Refund struct {
+	OrderID int    `db:"order_id"`
+	UserID  int    `db:"user_id"`
+	Amount  string `db:"amount"`
+	Actor   string `db:"actor"`
+	Reason  string `db:"reason"`
+}
+
+// Transfer -.
+type Transfer struct {
+	FromID  int    `db:"from_user_id"`
+	ToID    int    `db:"to_user_id"`
+	Amount  string `db:"amount"`
+	Comment string `db:"comment"`
 }
 
-// History -.
+// Kinds of operations in history, operations of the same kind have unique ids
+const (
+	KindOrder = iota + 1
+	KindReplenishment
+	KindTransferOut
+	KindTransferIn
+	KindRefund
+)
+
+// History is a page of user's operations. Zero filter fields are not used, operations match any of listed kinds,
+// services and statuses. If MetadataKey is set, only operations which metadata has this key are listed,
+// MetadataValue narrows them to ones with this value of the key. Page starts after the After position if it is
+// set, Next is a position of the next page, it is nil on the last page
 type History struct {
-	Orders  []Order `json:"orders"`
-	UserID  int     `json:"-"`
-	Limit   int     `json:"-"`
-	OrderBy string  `json:"-"`
-	Desc    bool    `json:"-"`
-	Page    int     `json:"-"`
+	Orders        []Order        `json:"orders"`
+	UserID        int            `json:"-"`
+	Limit         int            `json:"-"`
+	OrderBy       string         `json:"-"`
+	Desc          bool           `json:"-"`
+	Page          int            `json:"-"`
+	From          time.Time      `json:"-"`
+	To            time.Time      `json:"-"`
+	Kinds         []int          `json:"-"`
+	ServiceIDs    []int          `json:"-"`
+	StatusIDs     []int          `json:"-"`
+	MinSum        string         `json:"-"`
+	MaxSum        string         `json:"-"`
+	MetadataKey   string         `json:"-"`
+	MetadataValue string         `json:"-"`
+	After         *HistoryCursor `json:"-"`
+	Next          *HistoryCursor `json:"-"`
+}
+
+// HistoryCursor is a position in user's history sorted by OrderBy, operations are ordered by date or sum, then by
+// kind and id
+type HistoryCursor struct {
+	OrderBy string
+	Desc    bool
+	Created time.Time
+	Sum     string
+	Kind    int
+	ID      int
+}
+
+// StatementWriter writes operations of user's statement one by one in some format, Close writes the rest
+type StatementWriter interface {
+	Write(order Order) error
+	Close() error
 }
 
-// SumByService -.
+// Service -.
+type Service struct {
+	ID     int    `json:"id" db:"service_id"`
+	Name   string `json:"name" db:"service_name"`
+	Active bool   `json:"active" db:"active"`
+}
+
+// ServiceUpdate keeps changed fields of service, nil fields are not changed
+type ServiceUpdate struct {
+	ID     int
+	Name   *string
+	Active *bool
+}
+
+// SumByService is revenue and orders sum of a service, a user or a user in a service. Period is a start of
+// breakdown period the sums are made in, it is empty for sums of the whole report
 type SumByService struct {
-	Sum  string `db:"sums"`
-	Name string `db:"service_name"`
+	Sum      string `db:"sums"`
+	OrderSum string `db:"order_sums"`
+	Name     string `db:"service_name"`
+	UserID   int    `db:"user_id"`
+	Period   string `db:"period"`
+}
+
+// ReportParams sets days of report From and To inclusive as midnights in Location. Breakdown splits sums
+// by "day", "week" or "month", rows are grouped by services, users or both
+type ReportParams struct {
+	From      time.Time
+	To        time.Time
+	Location  *time.Location
+	Breakdown string
+	ByService bool
+	ByUser    bool
+}
+
+// ReportRow is sums of the whole report with sums of each breakdown period in the order of Report.Periods
+type ReportRow struct {
+	SumByService
+	Periods []SumByService
 }
 
-// Report -.
+// Report is revenue by services or users for given days, Total is a sum of all rows, Created is a time of
+// generation. Periods are starts of breakdown periods
 type Report struct {
-	Sums []SumByService
+	ReportParams
+	Created time.Time
+	Periods []string
+	Rows    []ReportRow
+	Total   ReportRow
+}
+
+// Report job statuses
+const (
+	ReportJobQueued  = "queued"
+	ReportJobRunning = "running"
+	ReportJobDone    = "done"
+	ReportJobFailed  = "failed"
+)
+
+// ReportJob is a generation of report file in background. Progress is in percents, Name is a file name of done
+// job, Error is a reason of failed one
+type ReportJob struct {
+	ID       int
+	Params   ReportParams
+	Format   string
+	Status   string
+	Progress int
+	Name     string
+	Error    string
+	Created  time.Time
+	Started  *time.Time
+	Finished *time.Time
+}
+
+// SavedReport is a report file kept in storage, Name is its id. Checksum is a hex sha256 of the file, Created is
+// a time of the last generation
+type SavedReport struct {
+	Name     string    `db:"file_name"`
+	Period   string    `db:"period"`
+	TimeZone string    `db:"time_zone"`
+	Format   string    `db:"format"`
+	Size     int64     `db:"size"`
+	Checksum string    `db:"checksum"`
+	Created  time.Time `db:"created"`
+}
+
+// Idempotency is a key of request with its saved response. Created is a start of the lease of in-progress key,
+// request holding the key saves it only while the lease isn't taken over by a retry
+type Idempotency struct {
+	Key         string    `db:"idem_key"`
+	Fingerprint string    `db:"fingerprint"`
+	Completed   bool      `db:"completed"`
+	Code        int       `db:"response_code"`
+	Response    []byte    `db:"response_body"`
+	Created     time.Time `db:"created"`
+}
+
+// BalanceCheck is user's cached balance with balances computed from the ledger and from user's operations
+type BalanceCheck struct {
+	UserID             int    `json:"user_id" db:"user_id"`
+	Amount             string `json:"amount" db:"amount"`
+	Reserved           string `json:"reserved" db:"reserved"`
+	LedgerAmount       string `json:"ledger_amount" db:"ledger_amount"`
+	LedgerReserved     string `json:"ledger_reserved" db:"ledger_reserved"`
+	OperationsAmount   string `json:"operations_amount" db:"operations_amount"`
+	OperationsReserved string `json:"operations_reserved" db:"operations_reserved"`
+}
+
+// Reconciliation -.
+type Reconciliation struct {
+	Started       time.Time      `json:"started"`
+	Finished      time.Time      `json:"finished"`
+	Users         int            `json:"users"`
+	Discrepancies []BalanceCheck `json:"discrepancies"`
 }
@@ -22,11 +22,50 @@ var (
 	ErrCantChangeStatus = errors.New("cant update status of committed/canceled order")
 
 	// ErrEmptyReport -.
-	ErrEmptyReport = errors.New("no any operations in this month")
+	ErrEmptyReport = errors.New("no any operations in this period")
 
-	// ErrEmptyPage -.
-	ErrEmptyPage = errors.New("this page is empty")
+	// ErrReportPeriods -.
+	ErrReportPeriods = errors.New("too many breakdown periods")
+
+	// ErrNoReport -.
+	ErrNoReport = errors.New("no such report")
+
+	// ErrNoReportJob -.
+	ErrNoReportJob = errors.New("no such report job")
 
 	// ErrNoService -.
 	ErrNoService = errors.New("no such service")
+
+	// ErrServiceExists -.
+	ErrServiceExists = errors.New("service with this name already exists")
+
+	// ErrServiceName -.
+	ErrServiceName = errors.New("service name must be from 1 to 55 characters")
+
+	// ErrCaptureExceeds -.
+	ErrCaptureExceeds = errors.New("captured sum exceeds reserved order sum")
+
+	// ErrCantRefund -.
+	ErrCantRefund = errors.New("cant refund not approved order")
+
+	// ErrRefundExceeds -.
+	ErrRefundExceeds = errors.New("refund exceeds not refunded order sum")
+
+	// ErrSameUser -.
+	ErrSameUser = errors.New("cant transfer money to the same user")
+
+	// ErrIdempotencyKeyExists -.
+	ErrIdempotencyKeyExists = errors.New("idempotency key already exists")
+
+	// ErrIdempotencyMismatch -.
+	ErrIdempotencyMismatch = errors.New("idempotency key was used with another request")
+
+	// ErrIdempotencyInProgress -.
+	ErrIdempotencyInProgress = errors.New("request with this idempotency key is in progress")
+
+	// ErrNoReconciliation -.
+	ErrNoReconciliation = errors.New("reconciliation wasnt run yet")
+
+	// ErrUnknownFormat -.
+	ErrUnknownFormat = errors.New("unknown report format")
 )
@@ -1,6 +1,9 @@
 package entity
 
 import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -27,3 +30,55 @@ func (t *MyTime) Scan(src interface{}) error {
 	}
 	return nil
 }
+
+// Metadata is a free-form JSON object attached to operation by client
+type Metadata map[string]interface{}
+
+// Scan -.
+func (m *Metadata) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, m)
+	case string:
+		return json.Unmarshal([]byte(src), m)
+	case nil:
+		*m = nil
+	default:
+		return errors.New("scan error: unknown type")
+	}
+	return nil
+}
+
+// Value -.
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Period returns report's days as 2022-07-01/2022-09-30 or as 2022-10 if it is a calendar month
+func (p ReportParams) Period() string {
+	if p.From.Day() == 1 && p.To.Equal(p.From.AddDate(0, 1, -1)) {
+		return p.From.Format("2006-01")
+	}
+	return p.From.Format("2006-01-02") + "/" + p.To.Format("2006-01-02")
+}
+
+type idempotencyCtxKey struct{}
+
+// WithIdempotency returns context of request holding idempotency key, operation made with this context saves
+// the key as completed with its response in the same transaction
+func WithIdempotency(ctx context.Context, key Idempotency) context.Context {
+	return context.WithValue(ctx, idempotencyCtxKey{}, key)
+}
+
+// IdempotencyFrom returns idempotency key of request context
+func IdempotencyFrom(ctx context.Context) (Idempotency, bool) {
+	key, ok := ctx.Value(idempotencyCtxKey{}).(Idempotency)
+	return key, ok
+}
@@ -0,0 +1,89 @@
+// Code generated by mockery v2.14.0. DO NOT EDIT.
+
+package reportmock
+
+import (
+	entity "balance_api/internal/entity"
+	context "context"
+	io "io"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// ReportDir is an autogenerated mock type for the ReportDir type
+type ReportDir struct {
+	mock.Mock
+}
+
+// GetDir provides a mock function with given fields:
+func (_m *ReportDir) GetDir() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// NewStatement provides a mock function with given fields: w, format
+func (_m *ReportDir) NewStatement(w io.Writer, format string) (entity.StatementWriter, error) {
+	ret := _m.Called(w, format)
+
+	var r0 entity.StatementWriter
+	if rf, ok := ret.Get(0).(func(io.Writer, string) entity.StatementWriter); ok {
+		r0 = rf(w, format)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(entity.StatementWriter)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(io.Writer, string) error); ok {
+		r1 = rf(w, format)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// URL provides a mock function with given fields: ctx, name
+func (_m *ReportDir) URL(ctx context.Context, name string) (string, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+type mockConstructorTestingTNewReportDir interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// NewReportDir creates a new instance of ReportDir. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func NewReportDir(t mockConstructorTestingTNewReportDir) *ReportDir {
+	mock := &ReportDir{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
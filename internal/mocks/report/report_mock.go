@@ -14,15 +14,29 @@ type ReportFile struct {
 	mock.Mock
 }
 
+// ContentType provides a mock function with given fields:
+func (_m *ReportFile) ContentType() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
 // Create provides a mock function with given fields: ctx, name, report
-func (_m *ReportFile) Create(ctx context.Context, name string, report entity.Report) (string, error) {
+func (_m *ReportFile) Create(ctx context.Context, name string, report entity.Report) (entity.SavedReport, error) {
 	ret := _m.Called(ctx, name, report)
 
-	var r0 string
-	if rf, ok := ret.Get(0).(func(context.Context, string, entity.Report) string); ok {
+	var r0 entity.SavedReport
+	if rf, ok := ret.Get(0).(func(context.Context, string, entity.Report) entity.SavedReport); ok {
 		r0 = rf(ctx, name, report)
 	} else {
-		r0 = ret.Get(0).(string)
+		r0 = ret.Get(0).(entity.SavedReport)
 	}
 
 	var r1 error
@@ -35,8 +49,8 @@ func (_m *ReportFile) Create(ctx context.Context, name string, report entity.Rep
 	return r0, r1
 }
 
-// GetDir provides a mock function with given fields:
-func (_m *ReportFile) GetDir() string {
+// Format provides a mock function with given fields:
+func (_m *ReportFile) Format() string {
 	ret := _m.Called()
 
 	var r0 string
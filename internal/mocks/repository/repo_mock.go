@@ -5,6 +5,7 @@ package repomock
 import (
 	entity "balance_api/internal/entity"
 	context "context"
+	time "time"
 
 	mock "github.com/stretchr/testify/mock"
 )
@@ -42,6 +43,41 @@ func (_m *BalanceRepo) CommitOrder(ctx context.Context, order entity.Order) erro
 	return r0
 }
 
+// CompleteIdempotencyKey provides a mock function with given fields: ctx, key
+func (_m *BalanceRepo) CompleteIdempotencyKey(ctx context.Context, key entity.Idempotency) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Idempotency) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateIdempotencyKey provides a mock function with given fields: ctx, key, stale
+func (_m *BalanceRepo) CreateIdempotencyKey(ctx context.Context, key entity.Idempotency, stale time.Time) (entity.Idempotency, error) {
+	ret := _m.Called(ctx, key, stale)
+
+	var r0 entity.Idempotency
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Idempotency, time.Time) entity.Idempotency); ok {
+		r0 = rf(ctx, key, stale)
+	} else {
+		r0 = ret.Get(0).(entity.Idempotency)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, entity.Idempotency, time.Time) error); ok {
+		r1 = rf(ctx, key, stale)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // CreateOrder provides a mock function with given fields: ctx, order
 func (_m *BalanceRepo) CreateOrder(ctx context.Context, order entity.Order) error {
 	ret := _m.Called(ctx, order)
@@ -56,6 +92,48 @@ func (_m *BalanceRepo) CreateOrder(ctx context.Context, order entity.Order) erro
 	return r0
 }
 
+// CreateReportJob provides a mock function with given fields: ctx, job
+func (_m *BalanceRepo) CreateReportJob(ctx context.Context, job entity.ReportJob) (entity.ReportJob, error) {
+	ret := _m.Called(ctx, job)
+
+	var r0 entity.ReportJob
+	if rf, ok := ret.Get(0).(func(context.Context, entity.ReportJob) entity.ReportJob); ok {
+		r0 = rf(ctx, job)
+	} else {
+		r0 = ret.Get(0).(entity.ReportJob)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, entity.ReportJob) error); ok {
+		r1 = rf(ctx, job)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateService provides a mock function with given fields: ctx, name
+func (_m *BalanceRepo) CreateService(ctx context.Context, name string) (entity.Service, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 entity.Service
+	if rf, ok := ret.Get(0).(func(context.Context, string) entity.Service); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Get(0).(entity.Service)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // CreateUser provides a mock function with given fields: ctx, balance
 func (_m *BalanceRepo) CreateUser(ctx context.Context, balance entity.Balance) error {
 	ret := _m.Called(ctx, balance)
@@ -70,6 +148,99 @@ func (_m *BalanceRepo) CreateUser(ctx context.Context, balance entity.Balance) e
 	return r0
 }
 
+// DeleteExpiredIdempotencyKeys provides a mock function with given fields: ctx, before
+func (_m *BalanceRepo) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	ret := _m.Called(ctx, before)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int64); ok {
+		r0 = rf(ctx, before)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteIdempotencyKey provides a mock function with given fields: ctx, key
+func (_m *BalanceRepo) DeleteIdempotencyKey(ctx context.Context, key entity.Idempotency) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Idempotency) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ExportHistory provides a mock function with given fields: ctx, history, fn
+func (_m *BalanceRepo) ExportHistory(ctx context.Context, history entity.History, fn func(entity.Order) error) error {
+	ret := _m.Called(ctx, history, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.History, func(entity.Order) error) error); ok {
+		r0 = rf(ctx, history, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetBalanceAt provides a mock function with given fields: ctx, id, at
+func (_m *BalanceRepo) GetBalanceAt(ctx context.Context, id int, at time.Time) (entity.Balance, error) {
+	ret := _m.Called(ctx, id, at)
+
+	var r0 entity.Balance
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) entity.Balance); ok {
+		r0 = rf(ctx, id, at)
+	} else {
+		r0 = ret.Get(0).(entity.Balance)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, time.Time) error); ok {
+		r1 = rf(ctx, id, at)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetBalanceChecks provides a mock function with given fields: ctx, afterID, limit
+func (_m *BalanceRepo) GetBalanceChecks(ctx context.Context, afterID int, limit int) ([]entity.BalanceCheck, error) {
+	ret := _m.Called(ctx, afterID, limit)
+
+	var r0 []entity.BalanceCheck
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) []entity.BalanceCheck); ok {
+		r0 = rf(ctx, afterID, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.BalanceCheck)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
+		r1 = rf(ctx, afterID, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetByID provides a mock function with given fields: ctx, id
 func (_m *BalanceRepo) GetByID(ctx context.Context, id int) (entity.Balance, error) {
 	ret := _m.Called(ctx, id)
@@ -91,6 +262,29 @@ func (_m *BalanceRepo) GetByID(ctx context.Context, id int) (entity.Balance, err
 	return r0, r1
 }
 
+// GetExpiredOrders provides a mock function with given fields: ctx, limit
+func (_m *BalanceRepo) GetExpiredOrders(ctx context.Context, limit int) ([]entity.Order, error) {
+	ret := _m.Called(ctx, limit)
+
+	var r0 []entity.Order
+	if rf, ok := ret.Get(0).(func(context.Context, int) []entity.Order); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.Order)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetHistory provides a mock function with given fields: ctx, history
 func (_m *BalanceRepo) GetHistory(ctx context.Context, history entity.History) (entity.History, error) {
 	ret := _m.Called(ctx, history)
@@ -112,6 +306,48 @@ func (_m *BalanceRepo) GetHistory(ctx context.Context, history entity.History) (
 	return r0, r1
 }
 
+// GetIdempotencyKey provides a mock function with given fields: ctx, key
+func (_m *BalanceRepo) GetIdempotencyKey(ctx context.Context, key string) (entity.Idempotency, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 entity.Idempotency
+	if rf, ok := ret.Get(0).(func(context.Context, string) entity.Idempotency); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Get(0).(entity.Idempotency)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetLastReconciliation provides a mock function with given fields: ctx
+func (_m *BalanceRepo) GetLastReconciliation(ctx context.Context) (entity.Reconciliation, error) {
+	ret := _m.Called(ctx)
+
+	var r0 entity.Reconciliation
+	if rf, ok := ret.Get(0).(func(context.Context) entity.Reconciliation); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(entity.Reconciliation)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetOrderByID provides a mock function with given fields: ctx, id
 func (_m *BalanceRepo) GetOrderByID(ctx context.Context, id int) (entity.Order, error) {
 	ret := _m.Called(ctx, id)
@@ -133,20 +369,156 @@ func (_m *BalanceRepo) GetOrderByID(ctx context.Context, id int) (entity.Order,
 	return r0, r1
 }
 
-// GetReport provides a mock function with given fields: ctx, year, month
-func (_m *BalanceRepo) GetReport(ctx context.Context, year int, month int) (entity.Report, error) {
-	ret := _m.Called(ctx, year, month)
+// GetOrderEvents provides a mock function with given fields: ctx, orderID
+func (_m *BalanceRepo) GetOrderEvents(ctx context.Context, orderID int) ([]entity.OrderEvent, error) {
+	ret := _m.Called(ctx, orderID)
 
-	var r0 entity.Report
-	if rf, ok := ret.Get(0).(func(context.Context, int, int) entity.Report); ok {
-		r0 = rf(ctx, year, month)
+	var r0 []entity.OrderEvent
+	if rf, ok := ret.Get(0).(func(context.Context, int) []entity.OrderEvent); ok {
+		r0 = rf(ctx, orderID)
 	} else {
-		r0 = ret.Get(0).(entity.Report)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.OrderEvent)
+		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
-		r1 = rf(ctx, year, month)
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, orderID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetOrders provides a mock function with given fields: ctx, filter
+func (_m *BalanceRepo) GetOrders(ctx context.Context, filter entity.OrderFilter) ([]entity.Order, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 []entity.Order
+	if rf, ok := ret.Get(0).(func(context.Context, entity.OrderFilter) []entity.Order); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.Order)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, entity.OrderFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetReport provides a mock function with given fields: ctx, params
+func (_m *BalanceRepo) GetReport(ctx context.Context, params entity.ReportParams) ([]entity.SumByService, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 []entity.SumByService
+	if rf, ok := ret.Get(0).(func(context.Context, entity.ReportParams) []entity.SumByService); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.SumByService)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, entity.ReportParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetReportJob provides a mock function with given fields: ctx, id
+func (_m *BalanceRepo) GetReportJob(ctx context.Context, id int) (entity.ReportJob, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 entity.ReportJob
+	if rf, ok := ret.Get(0).(func(context.Context, int) entity.ReportJob); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Get(0).(entity.ReportJob)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSavedReport provides a mock function with given fields: ctx, name
+func (_m *BalanceRepo) GetSavedReport(ctx context.Context, name string) (entity.SavedReport, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 entity.SavedReport
+	if rf, ok := ret.Get(0).(func(context.Context, string) entity.SavedReport); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Get(0).(entity.SavedReport)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSavedReports provides a mock function with given fields: ctx, limit
+func (_m *BalanceRepo) GetSavedReports(ctx context.Context, limit int) ([]entity.SavedReport, error) {
+	ret := _m.Called(ctx, limit)
+
+	var r0 []entity.SavedReport
+	if rf, ok := ret.Get(0).(func(context.Context, int) []entity.SavedReport); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.SavedReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetServices provides a mock function with given fields: ctx
+func (_m *BalanceRepo) GetServices(ctx context.Context) ([]entity.Service, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []entity.Service
+	if rf, ok := ret.Get(0).(func(context.Context) []entity.Service); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -168,6 +540,20 @@ func (_m *BalanceRepo) Increase(ctx context.Context, balance entity.Balance) err
 	return r0
 }
 
+// RefundOrder provides a mock function with given fields: ctx, refund
+func (_m *BalanceRepo) RefundOrder(ctx context.Context, refund entity.Refund) error {
+	ret := _m.Called(ctx, refund)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Refund) error); ok {
+		r0 = rf(ctx, refund)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // RollbackOrder provides a mock function with given fields: ctx, order
 func (_m *BalanceRepo) RollbackOrder(ctx context.Context, order entity.Order) error {
 	ret := _m.Called(ctx, order)
@@ -182,6 +568,111 @@ func (_m *BalanceRepo) RollbackOrder(ctx context.Context, order entity.Order) er
 	return r0
 }
 
+// SaveReconciliation provides a mock function with given fields: ctx, res
+func (_m *BalanceRepo) SaveReconciliation(ctx context.Context, res entity.Reconciliation) error {
+	ret := _m.Called(ctx, res)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Reconciliation) error); ok {
+		r0 = rf(ctx, res)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SaveReport provides a mock function with given fields: ctx, report
+func (_m *BalanceRepo) SaveReport(ctx context.Context, report entity.SavedReport) (entity.SavedReport, error) {
+	ret := _m.Called(ctx, report)
+
+	var r0 entity.SavedReport
+	if rf, ok := ret.Get(0).(func(context.Context, entity.SavedReport) entity.SavedReport); ok {
+		r0 = rf(ctx, report)
+	} else {
+		r0 = ret.Get(0).(entity.SavedReport)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, entity.SavedReport) error); ok {
+		r1 = rf(ctx, report)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// StartReportJob provides a mock function with given fields: ctx, stale
+func (_m *BalanceRepo) StartReportJob(ctx context.Context, stale time.Time) (entity.ReportJob, error) {
+	ret := _m.Called(ctx, stale)
+
+	var r0 entity.ReportJob
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) entity.ReportJob); ok {
+		r0 = rf(ctx, stale)
+	} else {
+		r0 = ret.Get(0).(entity.ReportJob)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, stale)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Transfer provides a mock function with given fields: ctx, transfer
+func (_m *BalanceRepo) Transfer(ctx context.Context, transfer entity.Transfer) error {
+	ret := _m.Called(ctx, transfer)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Transfer) error); ok {
+		r0 = rf(ctx, transfer)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateReportJob provides a mock function with given fields: ctx, job
+func (_m *BalanceRepo) UpdateReportJob(ctx context.Context, job entity.ReportJob) error {
+	ret := _m.Called(ctx, job)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.ReportJob) error); ok {
+		r0 = rf(ctx, job)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateService provides a mock function with given fields: ctx, update
+func (_m *BalanceRepo) UpdateService(ctx context.Context, update entity.ServiceUpdate) (entity.Service, error) {
+	ret := _m.Called(ctx, update)
+
+	var r0 entity.Service
+	if rf, ok := ret.Get(0).(func(context.Context, entity.ServiceUpdate) entity.Service); ok {
+		r0 = rf(ctx, update)
+	} else {
+		r0 = ret.Get(0).(entity.Service)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, entity.ServiceUpdate) error); ok {
+		r1 = rf(ctx, update)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 type mockConstructorTestingTNewBalanceRepo interface {
 	mock.TestingT
 	Cleanup(func())
@@ -5,6 +5,8 @@ package ucmock
 import (
 	entity "balance_api/internal/entity"
 	context "context"
+	io "io"
+	time "time"
 
 	mock "github.com/stretchr/testify/mock"
 )
@@ -14,6 +16,20 @@ type Balance struct {
 	mock.Mock
 }
 
+// CancelIdempotent provides a mock function with given fields: ctx, key
+func (_m *Balance) CancelIdempotent(ctx context.Context, key entity.Idempotency) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Idempotency) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // ChangeOrderStatus provides a mock function with given fields: ctx, order
 func (_m *Balance) ChangeOrderStatus(ctx context.Context, order entity.Order) error {
 	ret := _m.Called(ctx, order)
@@ -42,6 +58,141 @@ func (_m *Balance) CreateOrder(ctx context.Context, order entity.Order) error {
 	return r0
 }
 
+// CreateReportJob provides a mock function with given fields: ctx, params, format
+func (_m *Balance) CreateReportJob(ctx context.Context, params entity.ReportParams, format string) (entity.ReportJob, error) {
+	ret := _m.Called(ctx, params, format)
+
+	var r0 entity.ReportJob
+	if rf, ok := ret.Get(0).(func(context.Context, entity.ReportParams, string) entity.ReportJob); ok {
+		r0 = rf(ctx, params, format)
+	} else {
+		r0 = ret.Get(0).(entity.ReportJob)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, entity.ReportParams, string) error); ok {
+		r1 = rf(ctx, params, format)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateService provides a mock function with given fields: ctx, name
+func (_m *Balance) CreateService(ctx context.Context, name string) (entity.Service, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 entity.Service
+	if rf, ok := ret.Get(0).(func(context.Context, string) entity.Service); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Get(0).(entity.Service)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ExpireIdempotent provides a mock function with given fields: ctx
+func (_m *Balance) ExpireIdempotent(ctx context.Context) (int64, error) {
+	ret := _m.Called(ctx)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context) int64); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ExpireOrders provides a mock function with given fields: ctx
+func (_m *Balance) ExpireOrders(ctx context.Context) ([]entity.Order, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []entity.Order
+	if rf, ok := ret.Get(0).(func(context.Context) []entity.Order); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.Order)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ExportHistory provides a mock function with given fields: ctx, history, format, w
+func (_m *Balance) ExportHistory(ctx context.Context, history entity.History, format string, w io.Writer) error {
+	ret := _m.Called(ctx, history, format, w)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.History, string, io.Writer) error); ok {
+		r0 = rf(ctx, history, format, w)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FinishIdempotent provides a mock function with given fields: ctx, key
+func (_m *Balance) FinishIdempotent(ctx context.Context, key entity.Idempotency) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Idempotency) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetBalanceAt provides a mock function with given fields: ctx, id, at
+func (_m *Balance) GetBalanceAt(ctx context.Context, id int, at time.Time) (entity.Balance, error) {
+	ret := _m.Called(ctx, id, at)
+
+	var r0 entity.Balance
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) entity.Balance); ok {
+		r0 = rf(ctx, id, at)
+	} else {
+		r0 = ret.Get(0).(entity.Balance)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, time.Time) error); ok {
+		r1 = rf(ctx, id, at)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetByID provides a mock function with given fields: ctx, id
 func (_m *Balance) GetByID(ctx context.Context, id int) (entity.Balance, error) {
 	ret := _m.Called(ctx, id)
@@ -84,6 +235,94 @@ func (_m *Balance) GetHistory(ctx context.Context, history entity.History) (enti
 	return r0, r1
 }
 
+// GetOrder provides a mock function with given fields: ctx, id
+func (_m *Balance) GetOrder(ctx context.Context, id int) (entity.Order, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 entity.Order
+	if rf, ok := ret.Get(0).(func(context.Context, int) entity.Order); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Get(0).(entity.Order)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetOrders provides a mock function with given fields: ctx, filter
+func (_m *Balance) GetOrders(ctx context.Context, filter entity.OrderFilter) ([]entity.Order, *entity.OrderCursor, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 []entity.Order
+	if rf, ok := ret.Get(0).(func(context.Context, entity.OrderFilter) []entity.Order); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.Order)
+		}
+	}
+
+	var r1 *entity.OrderCursor
+	if rf, ok := ret.Get(1).(func(context.Context, entity.OrderFilter) *entity.OrderCursor); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*entity.OrderCursor)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, entity.OrderFilter) error); ok {
+		r2 = rf(ctx, filter)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetReconciliation provides a mock function with given fields: ctx
+func (_m *Balance) GetReconciliation(ctx context.Context) (entity.Reconciliation, error) {
+	ret := _m.Called(ctx)
+
+	var r0 entity.Reconciliation
+	if rf, ok := ret.Get(0).(func(context.Context) entity.Reconciliation); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(entity.Reconciliation)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetReportContentType provides a mock function with given fields: name
+func (_m *Balance) GetReportContentType(name string) string {
+	ret := _m.Called(name)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(name)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
 // GetReportDir provides a mock function with given fields:
 func (_m *Balance) GetReportDir() string {
 	ret := _m.Called()
@@ -98,6 +337,115 @@ func (_m *Balance) GetReportDir() string {
 	return r0
 }
 
+// GetReportJob provides a mock function with given fields: ctx, id
+func (_m *Balance) GetReportJob(ctx context.Context, id int) (entity.ReportJob, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 entity.ReportJob
+	if rf, ok := ret.Get(0).(func(context.Context, int) entity.ReportJob); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Get(0).(entity.ReportJob)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetReportURL provides a mock function with given fields: ctx, name
+func (_m *Balance) GetReportURL(ctx context.Context, name string) (string, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSavedReport provides a mock function with given fields: ctx, name
+func (_m *Balance) GetSavedReport(ctx context.Context, name string) (entity.SavedReport, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 entity.SavedReport
+	if rf, ok := ret.Get(0).(func(context.Context, string) entity.SavedReport); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Get(0).(entity.SavedReport)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSavedReports provides a mock function with given fields: ctx, limit
+func (_m *Balance) GetSavedReports(ctx context.Context, limit int) ([]entity.SavedReport, error) {
+	ret := _m.Called(ctx, limit)
+
+	var r0 []entity.SavedReport
+	if rf, ok := ret.Get(0).(func(context.Context, int) []entity.SavedReport); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.SavedReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetServices provides a mock function with given fields: ctx
+func (_m *Balance) GetServices(ctx context.Context) ([]entity.Service, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []entity.Service
+	if rf, ok := ret.Get(0).(func(context.Context) []entity.Service); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]entity.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Increase provides a mock function with given fields: ctx, balance
 func (_m *Balance) Increase(ctx context.Context, balance entity.Balance) error {
 	ret := _m.Called(ctx, balance)
@@ -112,20 +460,111 @@ func (_m *Balance) Increase(ctx context.Context, balance entity.Balance) error {
 	return r0
 }
 
-// UpdateReport provides a mock function with given fields: ctx, year, month
-func (_m *Balance) UpdateReport(ctx context.Context, year int, month int) (string, error) {
-	ret := _m.Called(ctx, year, month)
+// Reconcile provides a mock function with given fields: ctx
+func (_m *Balance) Reconcile(ctx context.Context) (entity.Reconciliation, error) {
+	ret := _m.Called(ctx)
+
+	var r0 entity.Reconciliation
+	if rf, ok := ret.Get(0).(func(context.Context) entity.Reconciliation); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(entity.Reconciliation)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RefundOrder provides a mock function with given fields: ctx, order, amount
+func (_m *Balance) RefundOrder(ctx context.Context, order entity.Order, amount string) error {
+	ret := _m.Called(ctx, order, amount)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Order, string) error); ok {
+		r0 = rf(ctx, order, amount)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// StartIdempotent provides a mock function with given fields: ctx, key
+func (_m *Balance) StartIdempotent(ctx context.Context, key entity.Idempotency) (entity.Idempotency, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 entity.Idempotency
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Idempotency) entity.Idempotency); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Get(0).(entity.Idempotency)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, entity.Idempotency) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Transfer provides a mock function with given fields: ctx, transfer
+func (_m *Balance) Transfer(ctx context.Context, transfer entity.Transfer) error {
+	ret := _m.Called(ctx, transfer)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, entity.Transfer) error); ok {
+		r0 = rf(ctx, transfer)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateReport provides a mock function with given fields: ctx, params, format
+func (_m *Balance) UpdateReport(ctx context.Context, params entity.ReportParams, format string) (string, error) {
+	ret := _m.Called(ctx, params, format)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(context.Context, int, int) string); ok {
-		r0 = rf(ctx, year, month)
+	if rf, ok := ret.Get(0).(func(context.Context, entity.ReportParams, string) string); ok {
+		r0 = rf(ctx, params, format)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
-		r1 = rf(ctx, year, month)
+	if rf, ok := ret.Get(1).(func(context.Context, entity.ReportParams, string) error); ok {
+		r1 = rf(ctx, params, format)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateService provides a mock function with given fields: ctx, update
+func (_m *Balance) UpdateService(ctx context.Context, update entity.ServiceUpdate) (entity.Service, error) {
+	ret := _m.Called(ctx, update)
+
+	var r0 entity.Service
+	if rf, ok := ret.Get(0).(func(context.Context, entity.ServiceUpdate) entity.Service); ok {
+		r0 = rf(ctx, update)
+	} else {
+		r0 = ret.Get(0).(entity.Service)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, entity.ServiceUpdate) error); ok {
+		r1 = rf(ctx, update)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -6,21 +6,48 @@ import (
 	"errors"
 	"fmt"
 	"github.com/shopspring/decimal"
-	"strconv"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	expireBatch     = 100
+	reconcileBatch  = 1000
+	serviceNameSize = 55
+	// maxReportPeriods limits columns of report's breakdown, it is a year of days
+	maxReportPeriods = 366
+	// idempotencyLease is how long in-progress key is held by its request, after that retry takes it over
+	idempotencyLease = time.Minute
+	// defaultIdempotencyTTL is how long keys with saved responses are kept
+	defaultIdempotencyTTL = 24 * time.Hour
 )
 
 // BalanceUseCase keeps all it needs to perform business logic
 type BalanceUseCase struct {
-	repo   BalanceRepo
-	report ReportFile
+	repo     BalanceRepo
+	report   ReportDir
+	formats  map[string]ReportFile
+	orderTTL time.Duration
+	idemTTL  time.Duration
+
+	jobs *reportWorkers
 }
 
 // New is a constructor for BalanceUseCase
-func New(r BalanceRepo, f ReportFile) *BalanceUseCase {
-	return &BalanceUseCase{
-		repo:   r,
-		report: f,
+func New(r BalanceRepo, d ReportDir, opts ...Option) *BalanceUseCase {
+	uc := &BalanceUseCase{
+		repo:    r,
+		report:  d,
+		formats: make(map[string]ReportFile),
+		idemTTL: defaultIdempotencyTTL,
+	}
+	for _, opt := range opts {
+		opt(uc)
 	}
+	return uc
 }
 
 // GetByID returns entity.Balance of given id from repo, entity.ErrNoID in case if there is no such one
@@ -35,45 +62,77 @@ func (uc *BalanceUseCase) GetByID(ctx context.Context, id int) (entity.Balance,
 	return balance, nil
 }
 
-// CreateOrder puts new order in repo, returns entity.ErrNoID if there is no such user,
-// entity.ErrOrderExists if order exists, entity.ErrNotEnoughMoney if user doesn't have
-// enough money for this order, entity.ErrNoService if service id is wrong
-func (uc *BalanceUseCase) CreateOrder(ctx context.Context, order entity.Order) error {
-	balance, err := uc.repo.GetByID(ctx, order.UserID)
+// GetBalanceAt returns entity.Balance of given id as it was at given moment, entity.ErrNoID in case if there
+// is no such one
+func (uc *BalanceUseCase) GetBalanceAt(ctx context.Context, id int, at time.Time) (entity.Balance, error) {
+	balance, err := uc.repo.GetBalanceAt(ctx, id, at)
 	switch {
 	case errors.Is(err, entity.ErrNoID):
-		return err
+		return entity.Balance{}, err
 	case err != nil:
-		return fmt.Errorf("BalanceUseCase - CreateOrder: %w", err)
+		return entity.Balance{}, fmt.Errorf("BalanceUseCase - GetBalanceAt: %w", err)
 	}
-	err = isLess(balance.Amount, order.Sum)
-	if err != nil {
-		return err
+	return balance, nil
+}
+
+// CreateOrder puts new order in repo, returns entity.ErrNoID if there is no such user,
+// entity.ErrOrderExists if order exists, entity.ErrNotEnoughMoney if user doesn't have
+// enough money for this order, entity.ErrNoService if service id is wrong. Order without ttl
+// gets default one
+func (uc *BalanceUseCase) CreateOrder(ctx context.Context, order entity.Order) error {
+	if order.TTL == 0 {
+		order.TTL = int(uc.orderTTL.Seconds())
 	}
-	err = uc.repo.CheckServiceID(ctx, order.ServiceID)
+	err := uc.repo.CreateOrder(ctx, order)
 	switch {
-	case errors.Is(err, entity.ErrNoService):
+	case errors.Is(err, entity.ErrNoID), errors.Is(err, entity.ErrNotEnoughMoney),
+		errors.Is(err, entity.ErrNoService), errors.Is(err, entity.ErrOrderExists):
 		return err
 	case err != nil:
 		return fmt.Errorf("BalanceUseCase - CreateOrder: %w", err)
 	}
-	_, err = uc.repo.GetOrderByID(ctx, order.ID)
+	return nil
+}
+
+// GetOrder returns order with given id and timeline of its status changes, entity.ErrOrderNoExists if there is
+// no such one
+func (uc *BalanceUseCase) GetOrder(ctx context.Context, id int) (entity.Order, error) {
+	order, err := uc.repo.GetOrderByID(ctx, id)
 	switch {
-	case err == nil:
-		return entity.ErrOrderExists
-	case !errors.Is(err, entity.ErrOrderNoExists):
-		return fmt.Errorf("BalanceUseCase - CreateOrder: %w", err)
+	case errors.Is(err, entity.ErrOrderNoExists):
+		return entity.Order{}, err
+	case err != nil:
+		return entity.Order{}, fmt.Errorf("BalanceUseCase - GetOrder: %w", err)
 	}
-	err = uc.repo.CreateOrder(ctx, order)
+	order.Events, err = uc.repo.GetOrderEvents(ctx, id)
 	if err != nil {
-		return fmt.Errorf("BalanceUseCase - CreateOrder: %w", err)
+		return entity.Order{}, fmt.Errorf("BalanceUseCase - GetOrder: %w", err)
 	}
-	return nil
+	return order, nil
+}
+
+// GetOrders returns page of orders matching filter and position of the next page, it is nil on the last page
+func (uc *BalanceUseCase) GetOrders(ctx context.Context, filter entity.OrderFilter) ([]entity.Order,
+	*entity.OrderCursor, error) {
+	limit := filter.Limit
+	// one more order shows if there is the next page
+	filter.Limit++
+	orders, err := uc.repo.GetOrders(ctx, filter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("BalanceUseCase - GetOrders: %w", err)
+	}
+	if len(orders) <= limit {
+		return orders, nil, nil
+	}
+	orders = orders[:limit]
+	last := orders[limit-1]
+	return orders, &entity.OrderCursor{Created: last.Time.Time, ID: last.ID}, nil
 }
 
 // ChangeOrderStatus commits or rollback order, returns entity.ErrOrderNoExists if there is no order with that id,
 // entity.ErrOrderMismatch if order in request is not the same as database one, entity.ErrCantChangeStatus if order
-// already committed/canceled
+// already committed/canceled. Commit charges order.Captured, the rest of reserved sum is returned to user, empty
+// order.Captured charges the whole sum. Returns entity.ErrCaptureExceeds if order.Captured is more than reserved sum
 func (uc *BalanceUseCase) ChangeOrderStatus(ctx context.Context, order entity.Order) error {
 	dbOrder, err := uc.repo.GetOrderByID(ctx, order.ID)
 	switch {
@@ -85,23 +144,89 @@ func (uc *BalanceUseCase) ChangeOrderStatus(ctx context.Context, order entity.Or
 	if order.ServiceID != dbOrder.ServiceID || order.UserID != dbOrder.UserID || !isEqual(order.Sum, dbOrder.Sum) {
 		return entity.ErrOrderMismatch
 	}
-	if dbOrder.StatusID != 1 {
+	if dbOrder.StatusID != entity.StatusPending {
 		return entity.ErrCantChangeStatus
 	}
-	if order.StatusID == 2 {
-		err = uc.repo.CommitOrder(ctx, order)
-		if err != nil {
-			return fmt.Errorf("BalanceUseCase - ChangeOrderStatus: %w", err)
+	if order.StatusID == entity.StatusApproved {
+		switch {
+		case order.Captured == "":
+			order.Captured = order.Sum
+		case toDecimal(dbOrder.Sum).LessThan(toDecimal(order.Captured)):
+			return entity.ErrCaptureExceeds
 		}
-		return nil
+		err = uc.repo.CommitOrder(ctx, order)
+	} else {
+		err = uc.repo.RollbackOrder(ctx, order)
 	}
-	err = uc.repo.RollbackOrder(ctx, order)
-	if err != nil {
+	switch {
+	case errors.Is(err, entity.ErrCantChangeStatus):
+		return err
+	case err != nil:
 		return fmt.Errorf("BalanceUseCase - ChangeOrderStatus: %w", err)
 	}
 	return nil
 }
 
+// ExpireOrders cancels pending orders which ttl is over and marks them as expired, returns released orders
+func (uc *BalanceUseCase) ExpireOrders(ctx context.Context) ([]entity.Order, error) {
+	orders, err := uc.repo.GetExpiredOrders(ctx, expireBatch)
+	if err != nil {
+		return nil, fmt.Errorf("BalanceUseCase - ExpireOrders: %w", err)
+	}
+	released := make([]entity.Order, 0, len(orders))
+	for _, order := range orders {
+		order.StatusID = entity.StatusExpired
+		order.Actor = entity.ActorSweeper
+		order.Reason = "ttl is over"
+		err = uc.repo.RollbackOrder(ctx, order)
+		switch {
+		case errors.Is(err, entity.ErrCantChangeStatus):
+			// order was approved or canceled after it was selected
+			continue
+		case err != nil:
+			return released, fmt.Errorf("BalanceUseCase - ExpireOrders: %w", err)
+		}
+		released = append(released, order)
+	}
+	return released, nil
+}
+
+// RefundOrder returns money of approved order back to user. Refund is partial if amount is less than not refunded
+// rest of captured sum, empty amount refunds the whole rest. Returns entity.ErrOrderNoExists if there is no order with that id,
+// entity.ErrOrderMismatch if order in request is not the same as database one, entity.ErrCantRefund if order isn't
+// approved, entity.ErrRefundExceeds if amount is more than not refunded rest of order
+func (uc *BalanceUseCase) RefundOrder(ctx context.Context, order entity.Order, amount string) error {
+	dbOrder, err := uc.repo.GetOrderByID(ctx, order.ID)
+	switch {
+	case errors.Is(err, entity.ErrOrderNoExists):
+		return err
+	case err != nil:
+		return fmt.Errorf("BalanceUseCase - RefundOrder: %w", err)
+	}
+	if order.ServiceID != dbOrder.ServiceID || order.UserID != dbOrder.UserID || !isEqual(order.Sum, dbOrder.Sum) {
+		return entity.ErrOrderMismatch
+	}
+	if dbOrder.StatusID != entity.StatusApproved && dbOrder.StatusID != entity.StatusPartiallyRefunded {
+		return entity.ErrCantRefund
+	}
+	rest := sub(dbOrder.Captured, dbOrder.Refunded)
+	switch {
+	case amount == "":
+		amount = rest.StringFixed(2)
+	case rest.LessThan(toDecimal(amount)):
+		return entity.ErrRefundExceeds
+	}
+	err = uc.repo.RefundOrder(ctx, entity.Refund{OrderID: dbOrder.ID, UserID: dbOrder.UserID, Amount: amount,
+		Actor: order.Actor, Reason: order.Reason})
+	switch {
+	case errors.Is(err, entity.ErrRefundExceeds):
+		return err
+	case err != nil:
+		return fmt.Errorf("BalanceUseCase - RefundOrder: %w", err)
+	}
+	return nil
+}
+
 // Increase adds money to user or creates it if there is no one
 func (uc *BalanceUseCase) Increase(ctx context.Context, balance entity.Balance) error {
 	_, err := uc.repo.GetByID(ctx, balance.ID)
@@ -122,7 +247,24 @@ func (uc *BalanceUseCase) Increase(ctx context.Context, balance entity.Balance)
 	return nil
 }
 
-// GetHistory gets list of orders from db of given user, returns entity.ErrNoID if there is no such user or
+// Transfer moves money from one user to another, returns entity.ErrSameUser if sender and receiver are the same,
+// entity.ErrNoID if there is no such sender or receiver, entity.ErrNotEnoughMoney if sender doesn't have enough money
+func (uc *BalanceUseCase) Transfer(ctx context.Context, transfer entity.Transfer) error {
+	if transfer.FromID == transfer.ToID {
+		return entity.ErrSameUser
+	}
+	err := uc.repo.Transfer(ctx, transfer)
+	switch {
+	case errors.Is(err, entity.ErrNoID), errors.Is(err, entity.ErrNotEnoughMoney):
+		return err
+	case err != nil:
+		return fmt.Errorf("BalanceUseCase - Transfer: %w", err)
+	}
+	return nil
+}
+
+// GetHistory gets page of user's operations and position of the next page, returns entity.ErrNoID if there is no
+// such user. Page after the last one is empty
 func (uc *BalanceUseCase) GetHistory(ctx context.Context, history entity.History) (entity.History, error) {
 	_, err := uc.repo.GetByID(ctx, history.UserID)
 	switch {
@@ -132,52 +274,431 @@ func (uc *BalanceUseCase) GetHistory(ctx context.Context, history entity.History
 		return entity.History{}, fmt.Errorf("BalanceUseCase - GetHistory: %w", err)
 	}
 	history, err = uc.repo.GetHistory(ctx, history)
-	switch {
-	case errors.Is(err, entity.ErrEmptyPage):
-		return entity.History{}, err
-	case err != nil:
+	if err != nil {
 		return entity.History{}, fmt.Errorf("BalanceUseCase - GetHistory: %w", err)
 	}
 	return history, nil
 }
 
-// UpdateReport creates a report, returns entity.ErrEmptyReport if report is empty
-func (uc *BalanceUseCase) UpdateReport(ctx context.Context, year, month int) (string, error) {
-	r, err := uc.repo.GetReport(ctx, year, month)
+// ExportHistory writes statement of all user's operations matching history filters to w in given format. Operations
+// are sorted by date and streamed from repo one by one. Returns entity.ErrNoID if there is no such user, nothing is
+// written in this case
+func (uc *BalanceUseCase) ExportHistory(ctx context.Context, history entity.History, format string, w io.Writer) error {
+	_, err := uc.repo.GetByID(ctx, history.UserID)
+	switch {
+	case errors.Is(err, entity.ErrNoID):
+		return err
+	case err != nil:
+		return fmt.Errorf("BalanceUseCase - ExportHistory: %w", err)
+	}
+	s, err := uc.report.NewStatement(w, format)
+	if err != nil {
+		return fmt.Errorf("BalanceUseCase - ExportHistory: %w", err)
+	}
+	history.OrderBy, history.Desc = "date", false
+	err = uc.repo.ExportHistory(ctx, history, s.Write)
+	if err != nil {
+		s.Close()
+		return fmt.Errorf("BalanceUseCase - ExportHistory: %w", err)
+	}
+	err = s.Close()
+	if err != nil {
+		return fmt.Errorf("BalanceUseCase - ExportHistory: %w", err)
+	}
+	return nil
+}
+
+// UpdateReport creates a report of given format with totals, returns entity.ErrEmptyReport if report is empty,
+// entity.ErrUnknownFormat if there is no such format, entity.ErrReportPeriods if breakdown has too many periods.
+// Rows are grouped by services if no grouping is set
+func (uc *BalanceUseCase) UpdateReport(ctx context.Context, params entity.ReportParams, format string) (string, error) {
+	f, params, err := uc.checkReport(params, format)
+	if err != nil {
+		return "", err
+	}
+	sums, err := uc.repo.GetReport(ctx, params)
 	switch {
 	case errors.Is(err, entity.ErrEmptyReport):
 		return "", err
 	case err != nil:
 		return "", fmt.Errorf("BalanceUseCase - UpdateReport: %w", err)
 	}
-	zero := ""
-	if month < 10 {
-		zero = "0"
-	}
-	name := strconv.Itoa(year) + "-" + zero + strconv.Itoa(month)
-	name, err = uc.report.Create(ctx, name, r)
+	name, err := uc.createReport(ctx, f, params, sums)
 	if err != nil {
 		return "", fmt.Errorf("BalanceUseCase - UpdateReport: %w", err)
 	}
 	return name, nil
 }
 
+// checkReport returns file of report's format and params with default grouping, entity.ErrUnknownFormat if there
+// is no such format, entity.ErrReportPeriods if breakdown has too many periods
+func (uc *BalanceUseCase) checkReport(params entity.ReportParams, format string) (ReportFile, entity.ReportParams,
+	error) {
+	f, ok := uc.formats[format]
+	if !ok {
+		return nil, params, entity.ErrUnknownFormat
+	}
+	if !params.ByUser {
+		params.ByService = true
+	}
+	if len(reportPeriods(params)) > maxReportPeriods {
+		return nil, params, entity.ErrReportPeriods
+	}
+	return f, params, nil
+}
+
+// createReport writes report of sums to a file and saves it to the list of known reports, returns its name
+func (uc *BalanceUseCase) createReport(ctx context.Context, f ReportFile, params entity.ReportParams,
+	sums []entity.SumByService) (string, error) {
+	r, err := newReport(params, reportPeriods(params), sums)
+	if err != nil {
+		return "", err
+	}
+	r.Created = time.Now().UTC()
+	saved, err := f.Create(ctx, reportName(params), r)
+	if err != nil {
+		return "", err
+	}
+	_, err = uc.repo.SaveReport(ctx, saved)
+	if err != nil {
+		return "", err
+	}
+	return saved.Name, nil
+}
+
+// reportName makes file name of report from its days, breakdown, grouping and location, e.g. 2022-10 or
+// 2022-07-01_2022-09-30_week_service-user_Europe-Moscow
+func reportName(params entity.ReportParams) string {
+	name := strings.ReplaceAll(params.Period(), "/", "_")
+	if params.Breakdown != "" {
+		name += "_" + params.Breakdown
+	}
+	switch {
+	case params.ByService && params.ByUser:
+		name += "_service-user"
+	case params.ByUser:
+		name += "_user"
+	}
+	if loc := params.Location.String(); loc != "UTC" {
+		name += "_" + strings.ReplaceAll(loc, "/", "-")
+	}
+	return name
+}
+
+// reportPeriods returns starts of breakdown periods which report's days fall in, nil if there is no breakdown
+func reportPeriods(params entity.ReportParams) []string {
+	var start time.Time
+	var next func(t time.Time) time.Time
+	switch params.Breakdown {
+	case "day":
+		start, next = params.From, func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case "week":
+		start = params.From.AddDate(0, 0, -(int(params.From.Weekday())+6)%7)
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case "month":
+		start = params.From.AddDate(0, 0, 1-params.From.Day())
+		next = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	default:
+		return nil
+	}
+	var periods []string
+	for t := start; !t.After(params.To) && len(periods) <= maxReportPeriods; t = next(t) {
+		periods = append(periods, t.Format("2006-01-02"))
+	}
+	return periods
+}
+
+// reportSums accumulates revenue and orders sum
+type reportSums struct {
+	sum, orderSum decimal.Decimal
+}
+
+func (s *reportSums) add(v entity.SumByService) error {
+	sum, err := decimal.NewFromString(v.Sum)
+	if err != nil {
+		return err
+	}
+	orderSum, err := decimal.NewFromString(v.OrderSum)
+	if err != nil {
+		return err
+	}
+	s.sum, s.orderSum = s.sum.Add(sum), s.orderSum.Add(orderSum)
+	return nil
+}
+
+func (s reportSums) entity(v entity.SumByService) entity.SumByService {
+	v.Sum, v.OrderSum = s.sum.StringFixed(2), s.orderSum.StringFixed(2)
+	return v
+}
+
+// reportRowSums accumulates sums of report row and of its breakdown periods
+type reportRowSums struct {
+	reportSums
+	periods []reportSums
+}
+
+func (s *reportRowSums) add(v entity.SumByService, period int) error {
+	err := s.reportSums.add(v)
+	if err != nil || period < 0 {
+		return err
+	}
+	return s.periods[period].add(v)
+}
+
+func (s reportRowSums) row(v entity.SumByService, periods []string) entity.ReportRow {
+	v.Period = ""
+	row := entity.ReportRow{SumByService: s.entity(v)}
+	for i, p := range s.periods {
+		row.Periods = append(row.Periods, p.entity(entity.SumByService{Name: v.Name, UserID: v.UserID,
+			Period: periods[i]}))
+	}
+	return row
+}
+
+// newReport collects sums of rows by breakdown periods, ordered by service and user, into report rows with
+// sums of every period and the total of all rows
+func newReport(params entity.ReportParams, periods []string, sums []entity.SumByService) (entity.Report, error) {
+	index := make(map[string]int, len(periods))
+	for i, p := range periods {
+		index[p] = i
+	}
+	total := reportRowSums{periods: make([]reportSums, len(periods))}
+	var keys []entity.SumByService
+	var rows []reportRowSums
+	for _, v := range sums {
+		if n := len(keys); n == 0 || keys[n-1].Name != v.Name || keys[n-1].UserID != v.UserID {
+			keys = append(keys, v)
+			rows = append(rows, reportRowSums{periods: make([]reportSums, len(periods))})
+		}
+		period := -1
+		if len(periods) != 0 {
+			i, ok := index[v.Period]
+			if !ok {
+				return entity.Report{}, fmt.Errorf("unknown report period %q", v.Period)
+			}
+			period = i
+		}
+		err := rows[len(rows)-1].add(v, period)
+		if err == nil {
+			err = total.add(v, period)
+		}
+		if err != nil {
+			return entity.Report{}, err
+		}
+	}
+	r := entity.Report{ReportParams: params, Periods: periods, Rows: make([]entity.ReportRow, 0, len(rows))}
+	for i, row := range rows {
+		r.Rows = append(r.Rows, row.row(keys[i], periods))
+	}
+	r.Total = total.row(entity.SumByService{Name: "Total"}, periods)
+	return r, nil
+}
+
 // GetReportDir is getter of report dir
 func (uc *BalanceUseCase) GetReportDir() string {
 	return uc.report.GetDir()
 }
 
-func isLess(gotStr, decStr string) error {
-	got, _ := decimal.NewFromString(gotStr)
-	dec, _ := decimal.NewFromString(decStr)
-	if got.LessThan(dec) {
-		return entity.ErrNotEnoughMoney
+// GetReportURL returns pre-signed link to download report file, empty if files are served from report dir
+func (uc *BalanceUseCase) GetReportURL(ctx context.Context, name string) (string, error) {
+	url, err := uc.report.URL(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("BalanceUseCase - GetReportURL: %w", err)
+	}
+	return url, nil
+}
+
+// GetSavedReport returns report file by name, entity.ErrNoReport if it isn't known
+func (uc *BalanceUseCase) GetSavedReport(ctx context.Context, name string) (entity.SavedReport, error) {
+	report, err := uc.repo.GetSavedReport(ctx, name)
+	switch {
+	case errors.Is(err, entity.ErrNoReport):
+		return entity.SavedReport{}, err
+	case err != nil:
+		return entity.SavedReport{}, fmt.Errorf("BalanceUseCase - GetSavedReport: %w", err)
+	}
+	return report, nil
+}
+
+// GetSavedReports returns at most limit report files, the latest generated first
+func (uc *BalanceUseCase) GetSavedReports(ctx context.Context, limit int) ([]entity.SavedReport, error) {
+	reports, err := uc.repo.GetSavedReports(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BalanceUseCase - GetSavedReports: %w", err)
+	}
+	return reports, nil
+}
+
+// GetReportContentType returns content type of report file by its extension, empty string if it isn't known
+func (uc *BalanceUseCase) GetReportContentType(name string) string {
+	if f, ok := uc.formats[strings.TrimPrefix(filepath.Ext(name), ".")]; ok {
+		return f.ContentType()
+	}
+	return ""
+}
+
+// StartIdempotent reserves idempotency key for a new request and returns it with start of its lease, in-progress
+// key which lease is over is taken over. If key was already used, returns saved request,
+// entity.ErrIdempotencyMismatch if saved request has another fingerprint, entity.ErrIdempotencyInProgress
+// if saved request hasn't finished yet
+func (uc *BalanceUseCase) StartIdempotent(ctx context.Context, key entity.Idempotency) (entity.Idempotency, error) {
+	taken, err := uc.repo.CreateIdempotencyKey(ctx, key, time.Now().Add(-idempotencyLease))
+	switch {
+	case err == nil:
+		return taken, nil
+	case !errors.Is(err, entity.ErrIdempotencyKeyExists):
+		return entity.Idempotency{}, fmt.Errorf("BalanceUseCase - StartIdempotent: %w", err)
+	}
+	saved, err := uc.repo.GetIdempotencyKey(ctx, key.Key)
+	if err != nil {
+		return entity.Idempotency{}, fmt.Errorf("BalanceUseCase - StartIdempotent: %w", err)
+	}
+	if saved.Fingerprint != key.Fingerprint {
+		return entity.Idempotency{}, entity.ErrIdempotencyMismatch
+	}
+	if !saved.Completed {
+		return entity.Idempotency{}, entity.ErrIdempotencyInProgress
+	}
+	return saved, nil
+}
+
+// FinishIdempotent saves response of request, so it can be replayed
+func (uc *BalanceUseCase) FinishIdempotent(ctx context.Context, key entity.Idempotency) error {
+	err := uc.repo.CompleteIdempotencyKey(ctx, key)
+	if err != nil {
+		return fmt.Errorf("BalanceUseCase - FinishIdempotent: %w", err)
 	}
 	return nil
 }
 
+// CancelIdempotent releases idempotency key, so failed request can be retried
+func (uc *BalanceUseCase) CancelIdempotent(ctx context.Context, key entity.Idempotency) error {
+	err := uc.repo.DeleteIdempotencyKey(ctx, key)
+	if err != nil {
+		return fmt.Errorf("BalanceUseCase - CancelIdempotent: %w", err)
+	}
+	return nil
+}
+
+// ExpireIdempotent removes idempotency keys older than their ttl, returns number of removed keys
+func (uc *BalanceUseCase) ExpireIdempotent(ctx context.Context) (int64, error) {
+	n, err := uc.repo.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(-uc.idemTTL))
+	if err != nil {
+		return 0, fmt.Errorf("BalanceUseCase - ExpireIdempotent: %w", err)
+	}
+	return n, nil
+}
+
+// Reconcile checks cached balance of every user against the ledger and user's operations, result is saved
+// to db, so it is shared by all instances and runs of cmd/reconcile
+func (uc *BalanceUseCase) Reconcile(ctx context.Context) (entity.Reconciliation, error) {
+	res := entity.Reconciliation{Started: time.Now(), Discrepancies: []entity.BalanceCheck{}}
+	afterID := 0
+	for {
+		checks, err := uc.repo.GetBalanceChecks(ctx, afterID, reconcileBatch)
+		if err != nil {
+			return entity.Reconciliation{}, fmt.Errorf("BalanceUseCase - Reconcile: %w", err)
+		}
+		for _, c := range checks {
+			if !isEqual(c.Amount, c.LedgerAmount) || !isEqual(c.Amount, c.OperationsAmount) ||
+				!isEqual(c.Reserved, c.LedgerReserved) || !isEqual(c.Reserved, c.OperationsReserved) {
+				res.Discrepancies = append(res.Discrepancies, c)
+			}
+		}
+		res.Users += len(checks)
+		if len(checks) < reconcileBatch {
+			break
+		}
+		afterID = checks[len(checks)-1].UserID
+	}
+	res.Finished = time.Now()
+
+	err := uc.repo.SaveReconciliation(ctx, res)
+	if err != nil {
+		return entity.Reconciliation{}, fmt.Errorf("BalanceUseCase - Reconcile: %w", err)
+	}
+	return res, nil
+}
+
+// GetReconciliation returns result of the last reconciliation, entity.ErrNoReconciliation if there was no one
+func (uc *BalanceUseCase) GetReconciliation(ctx context.Context) (entity.Reconciliation, error) {
+	res, err := uc.repo.GetLastReconciliation(ctx)
+	switch {
+	case errors.Is(err, entity.ErrNoReconciliation):
+		return entity.Reconciliation{}, err
+	case err != nil:
+		return entity.Reconciliation{}, fmt.Errorf("BalanceUseCase - GetReconciliation: %w", err)
+	}
+	return res, nil
+}
+
+// GetServices returns all services, deactivated ones too
+func (uc *BalanceUseCase) GetServices(ctx context.Context) ([]entity.Service, error) {
+	services, err := uc.repo.GetServices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("BalanceUseCase - GetServices: %w", err)
+	}
+	return services, nil
+}
+
+// CreateService creates new active service, returns entity.ErrServiceName if name is empty or too long,
+// entity.ErrServiceExists if there is a service with the same name
+func (uc *BalanceUseCase) CreateService(ctx context.Context, name string) (entity.Service, error) {
+	name, err := checkServiceName(name)
+	if err != nil {
+		return entity.Service{}, err
+	}
+	service, err := uc.repo.CreateService(ctx, name)
+	switch {
+	case errors.Is(err, entity.ErrServiceExists):
+		return entity.Service{}, err
+	case err != nil:
+		return entity.Service{}, fmt.Errorf("BalanceUseCase - CreateService: %w", err)
+	}
+	return service, nil
+}
+
+// UpdateService renames, deactivates or activates service. Deactivated service can't be used for new orders,
+// but its existing orders stay as they are. Returns entity.ErrNoService if there is no such service,
+// entity.ErrServiceName and entity.ErrServiceExists if new name is wrong
+func (uc *BalanceUseCase) UpdateService(ctx context.Context, update entity.ServiceUpdate) (entity.Service, error) {
+	if update.Name != nil {
+		name, err := checkServiceName(*update.Name)
+		if err != nil {
+			return entity.Service{}, err
+		}
+		update.Name = &name
+	}
+	service, err := uc.repo.UpdateService(ctx, update)
+	switch {
+	case errors.Is(err, entity.ErrNoService), errors.Is(err, entity.ErrServiceExists):
+		return entity.Service{}, err
+	case err != nil:
+		return entity.Service{}, fmt.Errorf("BalanceUseCase - UpdateService: %w", err)
+	}
+	return service, nil
+}
+
+func checkServiceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > serviceNameSize {
+		return "", entity.ErrServiceName
+	}
+	return name, nil
+}
+
 func isEqual(orderStr, dbStr string) bool {
 	order, _ := decimal.NewFromString(orderStr)
 	db, _ := decimal.NewFromString(dbStr)
 	return order.Equal(db)
 }
+
+func sub(aStr, bStr string) decimal.Decimal {
+	return toDecimal(aStr).Sub(toDecimal(bStr))
+}
+
+// toDecimal converts money string to decimal, empty string is zero
+func toDecimal(str string) decimal.Decimal {
+	d, _ := decimal.NewFromString(str)
+	return d
+}
@@ -4,8 +4,13 @@ import (
 	"balance_api/internal/entity"
 	reportmock "balance_api/internal/mocks/report"
 	repomock "balance_api/internal/mocks/repository"
+	"bytes"
 	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"strings"
 	"testing"
 	"time"
 )
@@ -13,7 +18,7 @@ import (
 func TestGetByID(t *testing.T) {
 	ctx := context.Background()
 	r := repomock.NewBalanceRepo(t)
-	uc := New(r, reportmock.NewReportFile(t))
+	uc := New(r, reportmock.NewReportDir(t))
 
 	r.On("GetByID", ctx, 1).Return(entity.Balance{ID: 1, Amount: "200"}, nil)
 	r.On("GetByID", ctx, 2).Return(entity.Balance{}, entity.ErrNoID)
@@ -48,28 +53,62 @@ func TestGetByID(t *testing.T) {
 	}
 }
 
-func TestCreateOrder(t *testing.T) {
+func TestGetBalanceAt(t *testing.T) {
 	ctx := context.Background()
 	r := repomock.NewBalanceRepo(t)
-	uc := New(r, reportmock.NewReportFile(t))
+	uc := New(r, reportmock.NewReportDir(t))
+	at := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	dbErr := errors.New("aboba")
 
-	r.On("GetByID", ctx, 1).Return(entity.Balance{ID: 1, Amount: "300"}, nil)
-	r.On("CheckServiceID", ctx, 1).Return(nil)
-	r.On("GetOrderByID", ctx, 1).Return(entity.Order{}, entity.ErrOrderNoExists)
-	r.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 1, UserID: 1, Sum: "200"}).
-		Return(nil)
+	r.On("GetBalanceAt", ctx, 1, at).Return(entity.Balance{ID: 1, Amount: "150.00", Reserved: "50.00"}, nil)
+	r.On("GetBalanceAt", ctx, 2, at).Return(entity.Balance{}, entity.ErrNoID)
+	r.On("GetBalanceAt", ctx, 3, at).Return(entity.Balance{}, dbErr)
 
-	r.On("GetByID", ctx, 2).Return(entity.Balance{ID: 2, Amount: "300"}, nil)
-	r.On("CheckServiceID", ctx, 2).Return(nil)
-	r.On("GetOrderByID", ctx, 2).
-		Return(entity.Order{ID: 2, ServiceID: 2, UserID: 2, Sum: "200"}, nil)
+	type TestCase struct {
+		name        string
+		id          int
+		expectedVal entity.Balance
+		expectedErr error
+	}
+
+	cases := []TestCase{{
+		name:        "valid",
+		id:          1,
+		expectedVal: entity.Balance{ID: 1, Amount: "150.00", Reserved: "50.00"},
+		expectedErr: nil,
+	}, {
+		name:        "no such id",
+		id:          2,
+		expectedVal: entity.Balance{},
+		expectedErr: entity.ErrNoID,
+	},
+	}
 
-	r.On("GetByID", ctx, 3).Return(entity.Balance{}, entity.ErrNoID)
+	for _, tc := range cases {
+		val, err := uc.GetBalanceAt(ctx, tc.id, at)
+		assert.Equal(t, tc.expectedVal, val, tc.name)
+		assert.Equal(t, tc.expectedErr, err, tc.name)
+	}
 
-	r.On("GetByID", ctx, 4).Return(entity.Balance{ID: 4, Amount: "100"}, nil)
+	_, err := uc.GetBalanceAt(ctx, 3, at)
+	assert.ErrorIs(t, err, dbErr)
+}
 
-	r.On("GetByID", ctx, 5).Return(entity.Balance{ID: 5, Amount: "300"}, nil)
-	r.On("CheckServiceID", ctx, 5).Return(entity.ErrNoService)
+func TestCreateOrder(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	r.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 1, UserID: 1, Sum: "200"}).
+		Return(nil)
+	r.On("CreateOrder", ctx, entity.Order{ID: 2, ServiceID: 2, UserID: 2, Sum: "200"}).
+		Return(entity.ErrOrderExists)
+	r.On("CreateOrder", ctx, entity.Order{ID: 3, ServiceID: 3, UserID: 3, Sum: "200"}).
+		Return(entity.ErrNoID)
+	r.On("CreateOrder", ctx, entity.Order{ID: 4, ServiceID: 4, UserID: 4, Sum: "200"}).
+		Return(entity.ErrNotEnoughMoney)
+	r.On("CreateOrder", ctx, entity.Order{ID: 5, ServiceID: 5, UserID: 5, Sum: "200"}).
+		Return(entity.ErrNoService)
 
 	type TestCase struct {
 		name        string
@@ -106,14 +145,47 @@ func TestCreateOrder(t *testing.T) {
 	}
 }
 
+func TestCreateOrderTTL(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t), OrderTTL(time.Hour))
+
+	r.On("CreateOrder", ctx, entity.Order{ID: 1, ServiceID: 1, UserID: 1, Sum: "200", TTL: 3600}).
+		Return(nil)
+	r.On("CreateOrder", ctx, entity.Order{ID: 2, ServiceID: 1, UserID: 1, Sum: "200", TTL: 60}).
+		Return(nil)
+
+	type TestCase struct {
+		name        string
+		val         entity.Order
+		expectedErr error
+	}
+
+	cases := []TestCase{{
+		name:        "default ttl",
+		val:         entity.Order{ID: 1, ServiceID: 1, UserID: 1, Sum: "200"},
+		expectedErr: nil,
+	}, {
+		name:        "custom ttl",
+		val:         entity.Order{ID: 2, ServiceID: 1, UserID: 1, Sum: "200", TTL: 60},
+		expectedErr: nil,
+	},
+	}
+
+	for _, tc := range cases {
+		err := uc.CreateOrder(ctx, tc.val)
+		assert.Equal(t, tc.expectedErr, err)
+	}
+}
+
 func TestChangeOrderStatus(t *testing.T) {
 	ctx := context.Background()
 	r := repomock.NewBalanceRepo(t)
-	uc := New(r, reportmock.NewReportFile(t))
+	uc := New(r, reportmock.NewReportDir(t))
 
 	r.On("GetOrderByID", ctx, 1).
 		Return(entity.Order{ID: 1, ServiceID: 1, UserID: 1, Sum: "200", StatusID: 1}, nil)
-	r.On("CommitOrder", ctx, entity.Order{ID: 1, ServiceID: 1, UserID: 1, Sum: "200", StatusID: 2}).
+	r.On("CommitOrder", ctx, entity.Order{ID: 1, ServiceID: 1, UserID: 1, Sum: "200", Captured: "200", StatusID: 2}).
 		Return(nil)
 
 	r.On("GetOrderByID", ctx, 2).
@@ -128,6 +200,18 @@ func TestChangeOrderStatus(t *testing.T) {
 	r.On("GetOrderByID", ctx, 5).
 		Return(entity.Order{ID: 5, ServiceID: 5, UserID: 5, Sum: "200", StatusID: 2}, nil)
 
+	r.On("GetOrderByID", ctx, 6).
+		Return(entity.Order{ID: 6, ServiceID: 6, UserID: 6, Sum: "200", StatusID: 1}, nil)
+	r.On("CommitOrder", ctx, entity.Order{ID: 6, ServiceID: 6, UserID: 6, Sum: "200", Captured: "150", StatusID: 2}).
+		Return(nil)
+	r.On("GetOrderByID", ctx, 7).
+		Return(entity.Order{ID: 7, ServiceID: 7, UserID: 7, Sum: "200", StatusID: 1}, nil)
+
+	r.On("GetOrderByID", ctx, 8).
+		Return(entity.Order{ID: 8, ServiceID: 8, UserID: 8, Sum: "200", StatusID: 1}, nil)
+	r.On("RollbackOrder", ctx, entity.Order{ID: 8, ServiceID: 8, UserID: 8, Sum: "200", StatusID: 3}).
+		Return(entity.ErrCantChangeStatus)
+
 	type TestCase struct {
 		name        string
 		val         entity.Order
@@ -154,6 +238,18 @@ func TestChangeOrderStatus(t *testing.T) {
 		name:        "order already committed",
 		val:         entity.Order{ID: 5, ServiceID: 5, UserID: 5, Sum: "200", StatusID: 2},
 		expectedErr: entity.ErrCantChangeStatus,
+	}, {
+		name:        "partial capture",
+		val:         entity.Order{ID: 6, ServiceID: 6, UserID: 6, Sum: "200", Captured: "150", StatusID: 2},
+		expectedErr: nil,
+	}, {
+		name:        "capture exceeds",
+		val:         entity.Order{ID: 7, ServiceID: 7, UserID: 7, Sum: "200", Captured: "250", StatusID: 2},
+		expectedErr: entity.ErrCaptureExceeds,
+	}, {
+		name:        "changed concurrently",
+		val:         entity.Order{ID: 8, ServiceID: 8, UserID: 8, Sum: "200", StatusID: 3},
+		expectedErr: entity.ErrCantChangeStatus,
 	},
 	}
 
@@ -163,10 +259,118 @@ func TestChangeOrderStatus(t *testing.T) {
 	}
 }
 
+func TestRefundOrder(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	r.On("GetOrderByID", ctx, 1).
+		Return(entity.Order{ID: 1, ServiceID: 1, UserID: 1, Sum: "200", Captured: "200", StatusID: 2, Refunded: "0"}, nil)
+	r.On("RefundOrder", ctx, entity.Refund{OrderID: 1, UserID: 1, Amount: "50", Actor: "api:support",
+		Reason: "damaged"}).Return(nil)
+
+	r.On("GetOrderByID", ctx, 2).
+		Return(entity.Order{ID: 2, ServiceID: 2, UserID: 2, Sum: "200", Captured: "180", StatusID: 5, Refunded: "50"}, nil)
+	r.On("RefundOrder", ctx, entity.Refund{OrderID: 2, UserID: 2, Amount: "130.00"}).Return(nil)
+
+	r.On("GetOrderByID", ctx, 3).
+		Return(entity.Order{}, entity.ErrOrderNoExists)
+	r.On("GetOrderByID", ctx, 4).
+		Return(entity.Order{ID: 4, ServiceID: 4, UserID: 4, Sum: "200", StatusID: 2, Refunded: "0"}, nil)
+	r.On("GetOrderByID", ctx, 5).
+		Return(entity.Order{ID: 5, ServiceID: 5, UserID: 5, Sum: "200", StatusID: 1, Refunded: "0"}, nil)
+	r.On("GetOrderByID", ctx, 6).
+		Return(entity.Order{ID: 6, ServiceID: 6, UserID: 6, Sum: "200", Captured: "200", StatusID: 5, Refunded: "150"}, nil)
+
+	r.On("GetOrderByID", ctx, 7).
+		Return(entity.Order{ID: 7, ServiceID: 7, UserID: 7, Sum: "200", Captured: "200", StatusID: 2, Refunded: "0"}, nil)
+	r.On("RefundOrder", ctx, entity.Refund{OrderID: 7, UserID: 7, Amount: "200"}).Return(entity.ErrRefundExceeds)
+
+	type TestCase struct {
+		name        string
+		val         entity.Order
+		amount      string
+		expectedErr error
+	}
+
+	cases := []TestCase{{
+		name:        "valid partial",
+		val:         entity.Order{ID: 1, ServiceID: 1, UserID: 1, Sum: "200", Actor: "api:support", Reason: "damaged"},
+		amount:      "50",
+		expectedErr: nil,
+	}, {
+		name:        "valid rest",
+		val:         entity.Order{ID: 2, ServiceID: 2, UserID: 2, Sum: "200"},
+		amount:      "",
+		expectedErr: nil,
+	}, {
+		name:        "no such order id",
+		val:         entity.Order{ID: 3, ServiceID: 3, UserID: 3, Sum: "200"},
+		amount:      "50",
+		expectedErr: entity.ErrOrderNoExists,
+	}, {
+		name:        "wrong order data",
+		val:         entity.Order{ID: 4, ServiceID: 4, UserID: 4, Sum: "300"},
+		amount:      "50",
+		expectedErr: entity.ErrOrderMismatch,
+	}, {
+		name:        "not approved",
+		val:         entity.Order{ID: 5, ServiceID: 5, UserID: 5, Sum: "200"},
+		amount:      "50",
+		expectedErr: entity.ErrCantRefund,
+	}, {
+		name:        "exceeds rest",
+		val:         entity.Order{ID: 6, ServiceID: 6, UserID: 6, Sum: "200"},
+		amount:      "100",
+		expectedErr: entity.ErrRefundExceeds,
+	}, {
+		name:        "concurrent refund",
+		val:         entity.Order{ID: 7, ServiceID: 7, UserID: 7, Sum: "200"},
+		amount:      "200",
+		expectedErr: entity.ErrRefundExceeds,
+	},
+	}
+
+	for _, tc := range cases {
+		err := uc.RefundOrder(ctx, tc.val, tc.amount)
+		assert.Equal(t, tc.expectedErr, err)
+	}
+}
+
+func TestExpireOrders(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	r.On("GetExpiredOrders", ctx, expireBatch).Return([]entity.Order{
+		{ID: 1, ServiceID: 1, UserID: 1, Sum: "200", StatusID: 1},
+		{ID: 2, ServiceID: 1, UserID: 2, Sum: "100", StatusID: 1},
+		{ID: 3, ServiceID: 1, UserID: 3, Sum: "50", StatusID: 1},
+	}, nil)
+	r.On("RollbackOrder", ctx, entity.Order{ID: 1, ServiceID: 1, UserID: 1, Sum: "200", StatusID: 6,
+		Actor: entity.ActorSweeper, Reason: "ttl is over"}).
+		Return(nil)
+	r.On("RollbackOrder", ctx, entity.Order{ID: 2, ServiceID: 1, UserID: 2, Sum: "100", StatusID: 6,
+		Actor: entity.ActorSweeper, Reason: "ttl is over"}).
+		Return(entity.ErrCantChangeStatus)
+	r.On("RollbackOrder", ctx, entity.Order{ID: 3, ServiceID: 1, UserID: 3, Sum: "50", StatusID: 6,
+		Actor: entity.ActorSweeper, Reason: "ttl is over"}).
+		Return(nil)
+
+	orders, err := uc.ExpireOrders(ctx)
+	assert.Equal(t, []entity.Order{
+		{ID: 1, ServiceID: 1, UserID: 1, Sum: "200", StatusID: 6, Actor: entity.ActorSweeper,
+			Reason: "ttl is over"},
+		{ID: 3, ServiceID: 1, UserID: 3, Sum: "50", StatusID: 6, Actor: entity.ActorSweeper,
+			Reason: "ttl is over"},
+	}, orders)
+	assert.Equal(t, nil, err)
+}
+
 func TestIncrease(t *testing.T) {
 	ctx := context.Background()
 	r := repomock.NewBalanceRepo(t)
-	uc := New(r, reportmock.NewReportFile(t))
+	uc := New(r, reportmock.NewReportDir(t))
 
 	r.On("GetByID", ctx, 1).Return(entity.Balance{}, entity.ErrNoID)
 	r.On("CreateUser", ctx, entity.Balance{ID: 1, Amount: "200"}).Return(nil)
@@ -196,10 +400,50 @@ func TestIncrease(t *testing.T) {
 	}
 }
 
+func TestTransfer(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	r.On("Transfer", ctx, entity.Transfer{FromID: 1, ToID: 2, Amount: "200"}).Return(nil)
+	r.On("Transfer", ctx, entity.Transfer{FromID: 1, ToID: 3, Amount: "200"}).Return(entity.ErrNoID)
+	r.On("Transfer", ctx, entity.Transfer{FromID: 1, ToID: 2, Amount: "1000"}).Return(entity.ErrNotEnoughMoney)
+
+	type TestCase struct {
+		name        string
+		val         entity.Transfer
+		expectedErr error
+	}
+
+	cases := []TestCase{{
+		name:        "valid",
+		val:         entity.Transfer{FromID: 1, ToID: 2, Amount: "200"},
+		expectedErr: nil,
+	}, {
+		name:        "same user",
+		val:         entity.Transfer{FromID: 1, ToID: 1, Amount: "200"},
+		expectedErr: entity.ErrSameUser,
+	}, {
+		name:        "no such user",
+		val:         entity.Transfer{FromID: 1, ToID: 3, Amount: "200"},
+		expectedErr: entity.ErrNoID,
+	}, {
+		name:        "not enough money",
+		val:         entity.Transfer{FromID: 1, ToID: 2, Amount: "1000"},
+		expectedErr: entity.ErrNotEnoughMoney,
+	},
+	}
+
+	for _, tc := range cases {
+		err := uc.Transfer(ctx, tc.val)
+		assert.Equal(t, tc.expectedErr, err)
+	}
+}
+
 func TestGetHistory(t *testing.T) {
 	ctx := context.Background()
 	r := repomock.NewBalanceRepo(t)
-	uc := New(r, reportmock.NewReportFile(t))
+	uc := New(r, reportmock.NewReportDir(t))
 
 	r.On("GetByID", ctx, 1).Return(entity.Balance{ID: 1, Amount: "200"}, nil)
 	r.On("GetHistory", ctx, entity.History{UserID: 1, Limit: 10, OrderBy: "date", Desc: true, Page: 1}).
@@ -212,7 +456,11 @@ func TestGetHistory(t *testing.T) {
 
 	r.On("GetByID", ctx, 3).Return(entity.Balance{ID: 3, Amount: "200"}, nil)
 	r.On("GetHistory", ctx, entity.History{UserID: 3, Limit: 2, OrderBy: "sum", Desc: false, Page: 10}).
-		Return(entity.History{}, entity.ErrEmptyPage)
+		Return(entity.History{Orders: []entity.Order{}, UserID: 3, Limit: 2, OrderBy: "sum", Page: 10}, nil)
+
+	r.On("GetByID", ctx, 4).Return(entity.Balance{ID: 4, Amount: "200"}, nil)
+	r.On("GetHistory", ctx, entity.History{UserID: 4, OrderBy: "date"}).
+		Return(entity.History{}, errors.New("aboba"))
 
 	type TestCase struct {
 		name        string
@@ -237,64 +485,645 @@ func TestGetHistory(t *testing.T) {
 	}, {
 		name:        "empty page",
 		val:         entity.History{UserID: 3, Limit: 2, OrderBy: "sum", Desc: false, Page: 10},
+		expectedVal: entity.History{Orders: []entity.Order{}, UserID: 3, Limit: 2, OrderBy: "sum", Page: 10},
+		expectedErr: nil,
+	}, {
+		name:        "db error",
+		val:         entity.History{UserID: 4, OrderBy: "date"},
 		expectedVal: entity.History{},
-		expectedErr: entity.ErrEmptyPage,
+		expectedErr: errors.New("aboba"),
 	},
 	}
 
 	for _, tc := range cases {
 		history, err := uc.GetHistory(ctx, tc.val)
-		assert.Equal(t, tc.expectedVal, history)
-		assert.Equal(t, tc.expectedErr, err)
+		assert.Equal(t, tc.expectedVal, history, tc.name)
+		if tc.expectedErr == nil {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.ErrorContains(t, err, tc.expectedErr.Error(), tc.name)
+		}
 	}
 }
 
-func TestUpdateReport(t *testing.T) {
+// statement keeps written operations in memory
+type statement struct {
+	orders []entity.Order
+	closed bool
+}
+
+func (s *statement) Write(order entity.Order) error {
+	s.orders = append(s.orders, order)
+	return nil
+}
+
+func (s *statement) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestExportHistory(t *testing.T) {
 	ctx := context.Background()
 	r := repomock.NewBalanceRepo(t)
-	f := reportmock.NewReportFile(t)
+	f := reportmock.NewReportDir(t)
 	uc := New(r, f)
+	var w bytes.Buffer
+
+	orders := []entity.Order{{ID: 1, Sum: "100.00"}, {ID: 2, Sum: "30.00"}}
+	export := func(orders []entity.Order) func(args mock.Arguments) {
+		return func(args mock.Arguments) {
+			fn := args.Get(2).(func(entity.Order) error)
+			for _, o := range orders {
+				_ = fn(o)
+			}
+		}
+	}
 
-	r.On("GetReport", ctx, 2022, 9).
-		Return(entity.Report{Sums: []entity.SumByService{{Sum: "1", Name: "a"}}}, nil)
-	f.On("Create", ctx, "2022-09", entity.Report{Sums: []entity.SumByService{{Sum: "1", Name: "a"}}}).
-		Return("2022-09.csv", nil)
+	r.On("GetByID", ctx, 1).Return(entity.Balance{ID: 1, Amount: "70"}, nil)
+	s1 := &statement{}
+	f.On("NewStatement", &w, "csv").Return(s1, nil).Once()
+	r.On("ExportHistory", ctx, entity.History{UserID: 1, OrderBy: "date", MinSum: "10"}, mock.Anything).
+		Run(export(orders)).Return(nil).Once()
 
-	r.On("GetReport", ctx, 1980, 1).Return(entity.Report{Sums: nil}, entity.ErrEmptyReport)
+	r.On("GetByID", ctx, 2).Return(entity.Balance{}, entity.ErrNoID)
+
+	r.On("GetByID", ctx, 3).Return(entity.Balance{ID: 3, Amount: "70"}, nil)
+	f.On("NewStatement", &w, "pdf").Return(nil, errors.New("unknown format"))
+
+	r.On("GetByID", ctx, 4).Return(entity.Balance{ID: 4, Amount: "70"}, nil)
+	s4 := &statement{}
+	f.On("NewStatement", &w, "csv").Return(s4, nil).Once()
+	r.On("ExportHistory", ctx, entity.History{UserID: 4, OrderBy: "date"}, mock.Anything).
+		Run(export(orders[:1])).Return(errors.New("aboba")).Once()
 
 	type TestCase struct {
 		name        string
-		date        []int
+		val         entity.History
+		format      string
+		s           *statement
+		expected    []entity.Order
+		expectedErr error
+	}
+
+	cases := []TestCase{{
+		name:     "valid",
+		val:      entity.History{UserID: 1, OrderBy: "sum", Desc: true, MinSum: "10"},
+		format:   "csv",
+		s:        s1,
+		expected: orders,
+	}, {
+		name:        "no such user",
+		val:         entity.History{UserID: 2},
+		format:      "csv",
+		expectedErr: entity.ErrNoID,
+	}, {
+		name:        "wrong format",
+		val:         entity.History{UserID: 3},
+		format:      "pdf",
+		expectedErr: errors.New("unknown format"),
+	}, {
+		name:        "db error",
+		val:         entity.History{UserID: 4},
+		format:      "csv",
+		s:           s4,
+		expected:    orders[:1],
+		expectedErr: errors.New("aboba"),
+	},
+	}
+
+	for _, tc := range cases {
+		err := uc.ExportHistory(ctx, tc.val, tc.format, &w)
+		if tc.expectedErr == nil {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.ErrorContains(t, err, tc.expectedErr.Error(), tc.name)
+		}
+		if tc.s != nil {
+			assert.Equal(t, tc.expected, tc.s.orders, tc.name)
+			assert.True(t, tc.s.closed, tc.name)
+		}
+	}
+}
+
+func TestUpdateReport(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	csvFile := reportmock.NewReportFile(t)
+	jsonFile := reportmock.NewReportFile(t)
+	csvFile.On("Format").Return("csv")
+	jsonFile.On("Format").Return("json")
+	uc := New(r, reportmock.NewReportDir(t), ReportFormats(csvFile, jsonFile))
+
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	month := entity.ReportParams{From: time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC),
+		To: time.Date(2022, 9, 30, 0, 0, 0, 0, time.UTC), Location: time.UTC, ByService: true}
+	weeks := entity.ReportParams{From: time.Date(2022, 10, 5, 0, 0, 0, 0, moscow),
+		To: time.Date(2022, 10, 12, 0, 0, 0, 0, moscow), Location: moscow, Breakdown: "week", ByService: true,
+		ByUser: true}
+	users := entity.ReportParams{From: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		To: time.Date(2022, 10, 31, 0, 0, 0, 0, time.UTC), Location: time.UTC, ByUser: true}
+	empty := month
+	empty.From, empty.To = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(1980, 1, 31, 0, 0, 0, 0, time.UTC)
+	days := month
+	days.To, days.Breakdown = time.Date(2023, 9, 2, 0, 0, 0, 0, time.UTC), "day"
+
+	r.On("GetReport", ctx, month).Return([]entity.SumByService{{Sum: "150.00", OrderSum: "200.00", Name: "a"},
+		{Sum: "-20.50", OrderSum: "0", Name: "b"}}, nil)
+	csvFile.On("Create", ctx, "2022-09", mock.MatchedBy(func(r entity.Report) bool {
+		return r.ReportParams == month && !r.Created.IsZero() && r.Periods == nil && assert.ObjectsAreEqual(
+			[]entity.ReportRow{{SumByService: entity.SumByService{Sum: "150.00", OrderSum: "200.00", Name: "a"}},
+				{SumByService: entity.SumByService{Sum: "-20.50", OrderSum: "0.00", Name: "b"}}}, r.Rows) &&
+			assert.ObjectsAreEqual(entity.ReportRow{SumByService: entity.SumByService{Name: "Total", Sum: "129.50",
+				OrderSum: "200.00"}}, r.Total)
+	})).Return(entity.SavedReport{Name: "2022-09.csv", Format: "csv"}, nil)
+	r.On("SaveReport", ctx, entity.SavedReport{Name: "2022-09.csv", Format: "csv"}).
+		Return(entity.SavedReport{Name: "2022-09.csv", Format: "csv", Created: time.Now()}, nil)
+
+	r.On("GetReport", ctx, weeks).Return([]entity.SumByService{
+		{Sum: "100", OrderSum: "100", Name: "a", UserID: 1, Period: "2022-10-03"},
+		{Sum: "50", OrderSum: "60", Name: "a", UserID: 1, Period: "2022-10-10"},
+		{Sum: "10", OrderSum: "10", Name: "a", UserID: 2, Period: "2022-10-10"}}, nil)
+	jsonFile.On("Create", ctx, "2022-10-05_2022-10-12_week_service-user_Europe-Moscow",
+		mock.MatchedBy(func(r entity.Report) bool {
+			return r.ReportParams == weeks && assert.ObjectsAreEqual([]string{"2022-10-03", "2022-10-10"}, r.Periods) &&
+				assert.ObjectsAreEqual([]entity.ReportRow{{
+					SumByService: entity.SumByService{Sum: "150.00", OrderSum: "160.00", Name: "a", UserID: 1},
+					Periods: []entity.SumByService{
+						{Sum: "100.00", OrderSum: "100.00", Name: "a", UserID: 1, Period: "2022-10-03"},
+						{Sum: "50.00", OrderSum: "60.00", Name: "a", UserID: 1, Period: "2022-10-10"}},
+				}, {
+					SumByService: entity.SumByService{Sum: "10.00", OrderSum: "10.00", Name: "a", UserID: 2},
+					Periods: []entity.SumByService{
+						{Sum: "0.00", OrderSum: "0.00", Name: "a", UserID: 2, Period: "2022-10-03"},
+						{Sum: "10.00", OrderSum: "10.00", Name: "a", UserID: 2, Period: "2022-10-10"}},
+				}}, r.Rows) &&
+				assert.ObjectsAreEqual(entity.ReportRow{
+					SumByService: entity.SumByService{Sum: "160.00", OrderSum: "170.00", Name: "Total"},
+					Periods: []entity.SumByService{
+						{Sum: "100.00", OrderSum: "100.00", Name: "Total", Period: "2022-10-03"},
+						{Sum: "60.00", OrderSum: "70.00", Name: "Total", Period: "2022-10-10"}},
+				}, r.Total)
+		})).Return(entity.SavedReport{Name: "2022-10-05_2022-10-12_week_service-user_Europe-Moscow.json"}, nil)
+	r.On("SaveReport", ctx, entity.SavedReport{Name: "2022-10-05_2022-10-12_week_service-user_Europe-Moscow.json"}).
+		Return(entity.SavedReport{}, nil)
+
+	r.On("GetReport", ctx, users).Return([]entity.SumByService{{Sum: "1", OrderSum: "1", UserID: 1}}, nil)
+	csvFile.On("Create", ctx, "2022-10_user", mock.Anything).Return(entity.SavedReport{Name: "2022-10_user.csv"}, nil)
+	r.On("SaveReport", ctx, entity.SavedReport{Name: "2022-10_user.csv"}).Return(entity.SavedReport{}, nil)
+
+	r.On("GetReport", ctx, empty).Return(nil, entity.ErrEmptyReport)
+
+	type TestCase struct {
+		name        string
+		params      entity.ReportParams
+		format      string
 		expectedVal string
 		expectedErr error
 	}
 
 	cases := []TestCase{{
 		name:        "valid",
-		date:        []int{2022, 9},
+		params:      month,
+		format:      "csv",
 		expectedVal: "2022-09.csv",
 		expectedErr: nil,
+	}, {
+		name:        "no grouping is by services",
+		params:      entity.ReportParams{From: month.From, To: month.To, Location: time.UTC},
+		format:      "csv",
+		expectedVal: "2022-09.csv",
+		expectedErr: nil,
+	}, {
+		name:        "weeks by services and users in time zone",
+		params:      weeks,
+		format:      "json",
+		expectedVal: "2022-10-05_2022-10-12_week_service-user_Europe-Moscow.json",
+		expectedErr: nil,
+	}, {
+		name:        "users",
+		params:      users,
+		format:      "csv",
+		expectedVal: "2022-10_user.csv",
+		expectedErr: nil,
 	}, {
 		name:        "empty report",
-		date:        []int{1980, 1},
+		params:      empty,
+		format:      "csv",
 		expectedVal: "",
 		expectedErr: entity.ErrEmptyReport,
+	}, {
+		name:        "unknown format",
+		params:      month,
+		format:      "html",
+		expectedVal: "",
+		expectedErr: entity.ErrUnknownFormat,
+	}, {
+		name:        "too many periods",
+		params:      days,
+		format:      "csv",
+		expectedVal: "",
+		expectedErr: entity.ErrReportPeriods,
 	},
 	}
 
 	for _, tc := range cases {
-		name, err := uc.UpdateReport(ctx, tc.date[0], tc.date[1])
-		assert.Equal(t, tc.expectedVal, name)
-		assert.Equal(t, tc.expectedErr, err)
+		name, err := uc.UpdateReport(ctx, tc.params, tc.format)
+		assert.Equal(t, tc.expectedVal, name, tc.name)
+		assert.Equal(t, tc.expectedErr, err, tc.name)
 	}
+
+	// file isn't served until it is saved to the list of reports
+	unsaved := users
+	unsaved.ByService = true
+	r.On("GetReport", ctx, unsaved).Return([]entity.SumByService{{Sum: "1", OrderSum: "1", UserID: 1}}, nil)
+	csvFile.On("Create", ctx, "2022-10_service-user", mock.Anything).
+		Return(entity.SavedReport{Name: "2022-10_service-user.csv"}, nil)
+	r.On("SaveReport", ctx, entity.SavedReport{Name: "2022-10_service-user.csv"}).
+		Return(entity.SavedReport{}, errors.New("aboba"))
+	_, err = uc.UpdateReport(ctx, unsaved, "csv")
+	assert.Error(t, err)
 }
 
-func TestGetDir(t *testing.T) {
+func TestGetSavedReport(t *testing.T) {
+	ctx := context.Background()
 	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	saved := entity.SavedReport{Name: "2022-10.csv", Period: "2022-10", TimeZone: "UTC", Format: "csv", Size: 42,
+		Checksum: strings.Repeat("a", 64), Created: time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)}
+	r.On("GetSavedReport", ctx, "2022-10.csv").Return(saved, nil)
+	r.On("GetSavedReport", ctx, "../config.env").Return(entity.SavedReport{}, entity.ErrNoReport)
+	r.On("GetSavedReport", ctx, "2022-11.csv").Return(entity.SavedReport{}, errors.New("aboba"))
+	r.On("GetSavedReports", ctx, 10).Return([]entity.SavedReport{saved}, nil)
+	r.On("GetSavedReports", ctx, 20).Return(nil, errors.New("aboba"))
+
+	report, err := uc.GetSavedReport(ctx, "2022-10.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, saved, report)
+	_, err = uc.GetSavedReport(ctx, "../config.env")
+	assert.ErrorIs(t, err, entity.ErrNoReport)
+	_, err = uc.GetSavedReport(ctx, "2022-11.csv")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, entity.ErrNoReport)
+
+	reports, err := uc.GetSavedReports(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []entity.SavedReport{saved}, reports)
+	_, err = uc.GetSavedReports(ctx, 20)
+	assert.Error(t, err)
+}
+
+func TestReportPeriods(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		from, to  time.Time
+		breakdown string
+		expected  []string
+	}{{
+		name:     "no breakdown",
+		from:     time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		to:       time.Date(2022, 10, 31, 0, 0, 0, 0, time.UTC),
+		expected: nil,
+	}, {
+		name:      "days",
+		from:      time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC),
+		to:        time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC),
+		breakdown: "day",
+		expected:  []string{"2022-10-30", "2022-10-31", "2022-11-01"},
+	}, {
+		name:      "weeks start on monday",
+		from:      time.Date(2022, 10, 2, 0, 0, 0, 0, time.UTC),
+		to:        time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+		breakdown: "week",
+		expected:  []string{"2022-09-26", "2022-10-03", "2022-10-10"},
+	}, {
+		name:      "months",
+		from:      time.Date(2022, 7, 15, 0, 0, 0, 0, time.UTC),
+		to:        time.Date(2022, 9, 30, 0, 0, 0, 0, time.UTC),
+		breakdown: "month",
+		expected:  []string{"2022-07-01", "2022-08-01", "2022-09-01"},
+	}} {
+		periods := reportPeriods(entity.ReportParams{From: tc.from, To: tc.to, Location: time.UTC,
+			Breakdown: tc.breakdown})
+		assert.Equal(t, tc.expected, periods, tc.name)
+	}
+}
+
+func TestGetReportContentType(t *testing.T) {
 	f := reportmock.NewReportFile(t)
+	f.On("Format").Return("xlsx")
+	f.On("ContentType").Return("application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	uc := New(repomock.NewBalanceRepo(t), reportmock.NewReportDir(t), ReportFormats(f))
+
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		uc.GetReportContentType("2022-10.xlsx"))
+	assert.Equal(t, "", uc.GetReportContentType("2022-10.pdf"))
+	assert.Equal(t, "", uc.GetReportContentType("2022-10"))
+}
+
+func TestGetDir(t *testing.T) {
+	r := repomock.NewBalanceRepo(t)
+	f := reportmock.NewReportDir(t)
 	uc := New(r, f)
 
 	f.On("GetDir").Return("reports/")
 
 	assert.Equal(t, "reports/", uc.GetReportDir())
 }
+
+func TestGetReportURL(t *testing.T) {
+	ctx := context.Background()
+	f := reportmock.NewReportDir(t)
+	uc := New(repomock.NewBalanceRepo(t), f)
+
+	f.On("URL", ctx, "2022-10.csv").Return("http://localhost:9000/reports/2022-10.csv?X-Amz-Signature=a", nil)
+	f.On("URL", ctx, "2022-11.csv").Return("", errors.New("aboba"))
+
+	url, err := uc.GetReportURL(ctx, "2022-10.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:9000/reports/2022-10.csv?X-Amz-Signature=a", url)
+	_, err = uc.GetReportURL(ctx, "2022-11.csv")
+	assert.Error(t, err)
+}
+
+func TestStartIdempotent(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	lease := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	// keys in progress longer than lease are taken over
+	stale := mock.MatchedBy(func(t time.Time) bool {
+		d := time.Since(t)
+		return d >= idempotencyLease && d < idempotencyLease+time.Minute
+	})
+	r.On("CreateIdempotencyKey", ctx, entity.Idempotency{Key: "1", Fingerprint: "a"}, stale).
+		Return(entity.Idempotency{Key: "1", Fingerprint: "a", Created: lease}, nil)
+
+	r.On("CreateIdempotencyKey", ctx, entity.Idempotency{Key: "2", Fingerprint: "a"}, stale).
+		Return(entity.Idempotency{}, entity.ErrIdempotencyKeyExists)
+	r.On("GetIdempotencyKey", ctx, "2").
+		Return(entity.Idempotency{Key: "2", Fingerprint: "a", Completed: true, Code: 200, Response: []byte("{}")}, nil)
+
+	r.On("CreateIdempotencyKey", ctx, entity.Idempotency{Key: "3", Fingerprint: "a"}, stale).
+		Return(entity.Idempotency{}, entity.ErrIdempotencyKeyExists)
+	r.On("GetIdempotencyKey", ctx, "3").
+		Return(entity.Idempotency{Key: "3", Fingerprint: "b", Completed: true, Code: 200, Response: []byte("{}")}, nil)
+
+	r.On("CreateIdempotencyKey", ctx, entity.Idempotency{Key: "4", Fingerprint: "a"}, stale).
+		Return(entity.Idempotency{}, entity.ErrIdempotencyKeyExists)
+	r.On("GetIdempotencyKey", ctx, "4").
+		Return(entity.Idempotency{Key: "4", Fingerprint: "a"}, nil)
+
+	type TestCase struct {
+		name        string
+		val         entity.Idempotency
+		expectedVal entity.Idempotency
+		expectedErr error
+	}
+
+	cases := []TestCase{{
+		name:        "new key",
+		val:         entity.Idempotency{Key: "1", Fingerprint: "a"},
+		expectedVal: entity.Idempotency{Key: "1", Fingerprint: "a", Created: lease},
+		expectedErr: nil,
+	}, {
+		name:        "replay",
+		val:         entity.Idempotency{Key: "2", Fingerprint: "a"},
+		expectedVal: entity.Idempotency{Key: "2", Fingerprint: "a", Completed: true, Code: 200, Response: []byte("{}")},
+		expectedErr: nil,
+	}, {
+		name:        "another request",
+		val:         entity.Idempotency{Key: "3", Fingerprint: "a"},
+		expectedVal: entity.Idempotency{},
+		expectedErr: entity.ErrIdempotencyMismatch,
+	}, {
+		name:        "in progress",
+		val:         entity.Idempotency{Key: "4", Fingerprint: "a"},
+		expectedVal: entity.Idempotency{},
+		expectedErr: entity.ErrIdempotencyInProgress,
+	},
+	}
+
+	for _, tc := range cases {
+		val, err := uc.StartIdempotent(ctx, tc.val)
+		assert.Equal(t, tc.expectedVal, val)
+		assert.Equal(t, tc.expectedErr, err)
+	}
+}
+
+func TestExpireIdempotent(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t), IdempotencyTTL(time.Hour))
+
+	before := mock.MatchedBy(func(t time.Time) bool {
+		d := time.Since(t)
+		return d >= time.Hour && d < time.Hour+time.Minute
+	})
+	r.On("DeleteExpiredIdempotencyKeys", ctx, before).Return(int64(3), nil).Once()
+	n, err := uc.ExpireIdempotent(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	dbErr := errors.New("aboba")
+	r.On("DeleteExpiredIdempotencyKeys", ctx, before).Return(int64(0), dbErr).Once()
+	_, err = uc.ExpireIdempotent(ctx)
+	assert.ErrorIs(t, err, dbErr)
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	page := make([]entity.BalanceCheck, reconcileBatch)
+	for i := range page {
+		page[i] = entity.BalanceCheck{UserID: i + 1, Amount: "100", Reserved: "0", LedgerAmount: "100.00",
+			LedgerReserved: "0.00", OperationsAmount: "100.00", OperationsReserved: "0.00"}
+	}
+	wrong := []entity.BalanceCheck{{UserID: reconcileBatch + 1, Amount: "100.00", Reserved: "50.00",
+		LedgerAmount: "100.00", LedgerReserved: "50.00", OperationsAmount: "150.00", OperationsReserved: "0.00"}}
+	r.On("GetBalanceChecks", ctx, 0, reconcileBatch).Return(page, nil).Once()
+	r.On("GetBalanceChecks", ctx, reconcileBatch, reconcileBatch).Return(wrong, nil).Once()
+	r.On("SaveReconciliation", ctx, mock.MatchedBy(func(res entity.Reconciliation) bool {
+		return res.Users == reconcileBatch+1 && len(res.Discrepancies) == 1 && !res.Finished.Before(res.Started)
+	})).Return(nil).Once()
+
+	res, err := uc.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, reconcileBatch+1, res.Users)
+	assert.Equal(t, wrong, res.Discrepancies)
+
+	dbErr := errors.New("aboba")
+	r.On("GetBalanceChecks", ctx, 0, reconcileBatch).Return(nil, dbErr).Once()
+	_, err = uc.Reconcile(ctx)
+	assert.ErrorIs(t, err, dbErr)
+
+	r.On("GetBalanceChecks", ctx, 0, reconcileBatch).Return(wrong, nil).Once()
+	r.On("SaveReconciliation", ctx, mock.Anything).Return(dbErr).Once()
+	_, err = uc.Reconcile(ctx)
+	assert.ErrorIs(t, err, dbErr)
+}
+
+func TestGetReconciliation(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	res := entity.Reconciliation{Started: time.Unix(10, 0), Finished: time.Unix(20, 0), Users: 1,
+		Discrepancies: []entity.BalanceCheck{}}
+	r.On("GetLastReconciliation", ctx).Return(entity.Reconciliation{}, entity.ErrNoReconciliation).Once()
+	r.On("GetLastReconciliation", ctx).Return(res, nil).Once()
+	r.On("GetLastReconciliation", ctx).Return(entity.Reconciliation{}, errors.New("aboba")).Once()
+
+	_, err := uc.GetReconciliation(ctx)
+	assert.Equal(t, entity.ErrNoReconciliation, err)
+	last, err := uc.GetReconciliation(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, res, last)
+	_, err = uc.GetReconciliation(ctx)
+	assert.Error(t, err)
+}
+
+func TestCreateService(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	r.On("CreateService", ctx, "Delivery").Return(entity.Service{ID: 6, Name: "Delivery", Active: true}, nil)
+	r.On("CreateService", ctx, "Rent").Return(entity.Service{}, entity.ErrServiceExists)
+
+	type TestCase struct {
+		name        string
+		val         string
+		expectedVal entity.Service
+		expectedErr error
+	}
+
+	cases := []TestCase{{
+		name:        "valid",
+		val:         "  Delivery ",
+		expectedVal: entity.Service{ID: 6, Name: "Delivery", Active: true},
+	}, {
+		name:        "exists",
+		val:         "Rent",
+		expectedErr: entity.ErrServiceExists,
+	}, {
+		name:        "empty name",
+		val:         "   ",
+		expectedErr: entity.ErrServiceName,
+	}, {
+		name:        "too long name",
+		val:         strings.Repeat("я", serviceNameSize+1),
+		expectedErr: entity.ErrServiceName,
+	},
+	}
+
+	for _, tc := range cases {
+		val, err := uc.CreateService(ctx, tc.val)
+		assert.Equal(t, tc.expectedVal, val, tc.name)
+		assert.Equal(t, tc.expectedErr, err, tc.name)
+	}
+}
+
+func TestUpdateService(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	name, spaced, empty := "Delivery", " Delivery ", ""
+	inactive := false
+	r.On("UpdateService", ctx, entity.ServiceUpdate{ID: 1, Name: &name}).
+		Return(entity.Service{ID: 1, Name: "Delivery", Active: true}, nil)
+	r.On("UpdateService", ctx, entity.ServiceUpdate{ID: 1, Active: &inactive}).
+		Return(entity.Service{ID: 1, Name: "Rent"}, nil)
+	r.On("UpdateService", ctx, entity.ServiceUpdate{ID: 10, Active: &inactive}).
+		Return(entity.Service{}, entity.ErrNoService)
+
+	type TestCase struct {
+		name        string
+		val         entity.ServiceUpdate
+		expectedVal entity.Service
+		expectedErr error
+	}
+
+	cases := []TestCase{{
+		name:        "rename",
+		val:         entity.ServiceUpdate{ID: 1, Name: &spaced},
+		expectedVal: entity.Service{ID: 1, Name: "Delivery", Active: true},
+	}, {
+		name:        "deactivate",
+		val:         entity.ServiceUpdate{ID: 1, Active: &inactive},
+		expectedVal: entity.Service{ID: 1, Name: "Rent"},
+	}, {
+		name:        "empty name",
+		val:         entity.ServiceUpdate{ID: 1, Name: &empty},
+		expectedErr: entity.ErrServiceName,
+	}, {
+		name:        "no such service",
+		val:         entity.ServiceUpdate{ID: 10, Active: &inactive},
+		expectedErr: entity.ErrNoService,
+	},
+	}
+
+	for _, tc := range cases {
+		val, err := uc.UpdateService(ctx, tc.val)
+		assert.Equal(t, tc.expectedVal, val, tc.name)
+		assert.Equal(t, tc.expectedErr, err, tc.name)
+	}
+}
+
+func TestGetOrder(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	created := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	events := []entity.OrderEvent{
+		{OrderID: 1, ToStatusID: 1, ToStatus: "Pending", Amount: "200.00", Actor: "api", Time: created},
+		{OrderID: 1, FromStatusID: 1, FromStatus: "Pending", ToStatusID: 2, ToStatus: "Approved", Amount: "150.00",
+			Actor: "api:support", Reason: "delivered", Time: created.Add(time.Hour)},
+	}
+	r.On("GetOrderByID", ctx, 1).Return(entity.Order{ID: 1, Sum: "200.00", StatusID: 2}, nil)
+	r.On("GetOrderEvents", ctx, 1).Return(events, nil)
+	r.On("GetOrderByID", ctx, 2).Return(entity.Order{}, entity.ErrOrderNoExists)
+	r.On("GetOrderByID", ctx, 3).Return(entity.Order{ID: 3, Sum: "200.00", StatusID: 1}, nil)
+	r.On("GetOrderEvents", ctx, 3).Return(nil, errors.New("aboba"))
+
+	order, err := uc.GetOrder(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.Order{ID: 1, Sum: "200.00", StatusID: 2, Events: events}, order)
+
+	_, err = uc.GetOrder(ctx, 2)
+	assert.Equal(t, entity.ErrOrderNoExists, err)
+
+	_, err = uc.GetOrder(ctx, 3)
+	assert.Error(t, err)
+}
+
+func TestGetOrders(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	created := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	orders := []entity.Order{
+		{ID: 3, Time: entity.MyTime{Time: created.Add(2 * time.Minute)}},
+		{ID: 2, Time: entity.MyTime{Time: created.Add(time.Minute)}},
+		{ID: 1, Time: entity.MyTime{Time: created}},
+	}
+	r.On("GetOrders", ctx, entity.OrderFilter{UserID: 1, Limit: 3}).Return(orders, nil)
+	r.On("GetOrders", ctx, entity.OrderFilter{UserID: 1, Limit: 4}).Return(orders, nil)
+
+	res, next, err := uc.GetOrders(ctx, entity.OrderFilter{UserID: 1, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, orders[:2], res)
+	assert.Equal(t, &entity.OrderCursor{Created: created.Add(time.Minute), ID: 2}, next)
+
+	res, next, err = uc.GetOrders(ctx, entity.OrderFilter{UserID: 1, Limit: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, orders, res)
+	assert.Nil(t, next)
+}
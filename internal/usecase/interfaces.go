@@ -3,35 +3,92 @@ package usecase
 import (
 	"balance_api/internal/entity"
 	"context"
+	"io"
+	"time"
 )
 
 // Balance is an interface for model layer
 type Balance interface {
 	GetByID(ctx context.Context, id int) (entity.Balance, error)
+	GetBalanceAt(ctx context.Context, id int, at time.Time) (entity.Balance, error)
 	CreateOrder(ctx context.Context, order entity.Order) error
+	GetOrder(ctx context.Context, id int) (entity.Order, error)
+	GetOrders(ctx context.Context, filter entity.OrderFilter) ([]entity.Order, *entity.OrderCursor, error)
 	ChangeOrderStatus(ctx context.Context, order entity.Order) error
+	RefundOrder(ctx context.Context, order entity.Order, amount string) error
+	ExpireOrders(ctx context.Context) ([]entity.Order, error)
 	Increase(ctx context.Context, balance entity.Balance) error
+	Transfer(ctx context.Context, transfer entity.Transfer) error
 	GetHistory(ctx context.Context, history entity.History) (entity.History, error)
-	UpdateReport(ctx context.Context, year, month int) (string, error)
+	ExportHistory(ctx context.Context, history entity.History, format string, w io.Writer) error
+	UpdateReport(ctx context.Context, params entity.ReportParams, format string) (string, error)
+	CreateReportJob(ctx context.Context, params entity.ReportParams, format string) (entity.ReportJob, error)
+	GetReportJob(ctx context.Context, id int) (entity.ReportJob, error)
 	GetReportDir() string
+	GetReportURL(ctx context.Context, name string) (string, error)
+	GetSavedReport(ctx context.Context, name string) (entity.SavedReport, error)
+	GetSavedReports(ctx context.Context, limit int) ([]entity.SavedReport, error)
+	GetReportContentType(name string) string
+	StartIdempotent(ctx context.Context, key entity.Idempotency) (entity.Idempotency, error)
+	FinishIdempotent(ctx context.Context, key entity.Idempotency) error
+	CancelIdempotent(ctx context.Context, key entity.Idempotency) error
+	ExpireIdempotent(ctx context.Context) (int64, error)
+	Reconcile(ctx context.Context) (entity.Reconciliation, error)
+	GetReconciliation(ctx context.Context) (entity.Reconciliation, error)
+	GetServices(ctx context.Context) ([]entity.Service, error)
+	CreateService(ctx context.Context, name string) (entity.Service, error)
+	UpdateService(ctx context.Context, update entity.ServiceUpdate) (entity.Service, error)
 }
 
 // BalanceRepo is an interface for repository layer
 type BalanceRepo interface {
 	GetByID(ctx context.Context, id int) (entity.Balance, error)
+	GetBalanceAt(ctx context.Context, id int, at time.Time) (entity.Balance, error)
 	CreateOrder(ctx context.Context, order entity.Order) error
 	GetOrderByID(ctx context.Context, id int) (entity.Order, error)
+	GetOrderEvents(ctx context.Context, orderID int) ([]entity.OrderEvent, error)
+	GetOrders(ctx context.Context, filter entity.OrderFilter) ([]entity.Order, error)
+	GetExpiredOrders(ctx context.Context, limit int) ([]entity.Order, error)
 	CheckServiceID(ctx context.Context, id int) error
 	CommitOrder(ctx context.Context, order entity.Order) error
 	RollbackOrder(ctx context.Context, order entity.Order) error
+	RefundOrder(ctx context.Context, refund entity.Refund) error
 	CreateUser(ctx context.Context, balance entity.Balance) error
 	Increase(ctx context.Context, balance entity.Balance) error
+	Transfer(ctx context.Context, transfer entity.Transfer) error
 	GetHistory(ctx context.Context, history entity.History) (entity.History, error)
-	GetReport(ctx context.Context, year, month int) (entity.Report, error)
+	ExportHistory(ctx context.Context, history entity.History, fn func(order entity.Order) error) error
+	GetReport(ctx context.Context, params entity.ReportParams) ([]entity.SumByService, error)
+	CreateReportJob(ctx context.Context, job entity.ReportJob) (entity.ReportJob, error)
+	GetReportJob(ctx context.Context, id int) (entity.ReportJob, error)
+	StartReportJob(ctx context.Context, stale time.Time) (entity.ReportJob, error)
+	UpdateReportJob(ctx context.Context, job entity.ReportJob) error
+	SaveReport(ctx context.Context, report entity.SavedReport) (entity.SavedReport, error)
+	GetSavedReport(ctx context.Context, name string) (entity.SavedReport, error)
+	GetSavedReports(ctx context.Context, limit int) ([]entity.SavedReport, error)
+	CreateIdempotencyKey(ctx context.Context, key entity.Idempotency, stale time.Time) (entity.Idempotency, error)
+	GetIdempotencyKey(ctx context.Context, key string) (entity.Idempotency, error)
+	CompleteIdempotencyKey(ctx context.Context, key entity.Idempotency) error
+	DeleteIdempotencyKey(ctx context.Context, key entity.Idempotency) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+	GetBalanceChecks(ctx context.Context, afterID, limit int) ([]entity.BalanceCheck, error)
+	SaveReconciliation(ctx context.Context, res entity.Reconciliation) error
+	GetLastReconciliation(ctx context.Context) (entity.Reconciliation, error)
+	GetServices(ctx context.Context) ([]entity.Service, error)
+	CreateService(ctx context.Context, name string) (entity.Service, error)
+	UpdateService(ctx context.Context, update entity.ServiceUpdate) (entity.Service, error)
 }
 
-// ReportFile interface serves for saving reports as files
-type ReportFile interface {
-	Create(ctx context.Context, name string, report entity.Report) (string, error)
+// ReportDir interface serves for keeping report files and writing statements of operations
+type ReportDir interface {
 	GetDir() string
+	URL(ctx context.Context, name string) (string, error)
+	NewStatement(w io.Writer, format string) (entity.StatementWriter, error)
+}
+
+// ReportFile interface serves for saving reports as files of one format, format is also an extension of files
+type ReportFile interface {
+	Create(ctx context.Context, name string, report entity.Report) (entity.SavedReport, error)
+	Format() string
+	ContentType() string
 }
@@ -0,0 +1,33 @@
+package usecase
+
+import "time"
+
+// Option is a type of functions-setters
+type Option func(*BalanceUseCase)
+
+// OrderTTL sets up default lifetime of pending orders, orders without ttl never expire
+func OrderTTL(ttl time.Duration) Option {
+	return func(uc *BalanceUseCase) {
+		if ttl > 0 {
+			uc.orderTTL = ttl
+		}
+	}
+}
+
+// IdempotencyTTL sets up how long idempotency keys with saved responses are kept, retry after that is done again
+func IdempotencyTTL(ttl time.Duration) Option {
+	return func(uc *BalanceUseCase) {
+		if ttl > 0 {
+			uc.idemTTL = ttl
+		}
+	}
+}
+
+// ReportFormats sets up formats of reports, format of a report is chosen by its name
+func ReportFormats(files ...ReportFile) Option {
+	return func(uc *BalanceUseCase) {
+		for _, f := range files {
+			uc.formats[f.Format()] = f
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package report
+
+import (
+	"balance_api/internal/entity"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// BalanceReport keeps a storage of report files
+type BalanceReport struct {
+	storage Storage
+}
+
+// New constructs BalanceReport
+func New(s Storage) *BalanceReport {
+	return &BalanceReport{
+		storage: s,
+	}
+}
+
+// GetDir returns local dir of report files, empty if storage doesn't keep them on disk
+func (r *BalanceReport) GetDir() string {
+	return r.storage.Dir()
+}
+
+// URL returns pre-signed link to download report file, empty if storage has no links
+func (r *BalanceReport) URL(ctx context.Context, name string) (string, error) {
+	url, err := r.storage.URL(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("ReportDir - URL: %w", err)
+	}
+	return url, nil
+}
+
+// CSV returns report file saving reports to storage as csv
+func (r *BalanceReport) CSV() *CSVFile {
+	return &CSVFile{storage: r.storage}
+}
+
+// JSON returns report file saving reports to storage as json
+func (r *BalanceReport) JSON() *JSONFile {
+	return &JSONFile{storage: r.storage}
+}
+
+// XLSX returns report file saving reports to storage as xlsx
+func (r *BalanceReport) XLSX() *XLSXFile {
+	return &XLSXFile{storage: r.storage}
+}
+
+// HTML returns report file saving reports to storage as html page
+func (r *BalanceReport) HTML() *HTMLFile {
+	return &HTMLFile{storage: r.storage}
+}
+
+// reportInfo returns rows describing report: its days, time zone and generation time
+func reportInfo(report entity.Report) [][]string {
+	return [][]string{{"period", report.Period()}, {"time_zone", report.Location.String()},
+		{"generated", created(report)}}
+}
+
+func created(report entity.Report) string {
+	return report.Created.UTC().Format(time.RFC3339)
+}
+
+// reportHeader names columns of report: service name and user id the rows are grouped by, revenue and sum of
+// approved orders before capture for the whole report and for each breakdown period
+func reportHeader(report entity.Report) []string {
+	var header []string
+	if report.ByService {
+		header = append(header, "service")
+	}
+	if report.ByUser {
+		header = append(header, "user_id")
+	}
+	header = append(header, "revenue", "orders_sum")
+	for _, p := range report.Periods {
+		header = append(header, "revenue "+p, "orders_sum "+p)
+	}
+	return header
+}
+
+// reportRow returns cells of report row in the order of reportHeader
+func reportRow(report entity.Report, row entity.ReportRow) []string {
+	var cells []string
+	if report.ByService {
+		cells = append(cells, row.Name)
+	}
+	if report.ByUser {
+		user := ""
+		if row.UserID != 0 {
+			user = strconv.Itoa(row.UserID)
+		}
+		cells = append(cells, user)
+	}
+	cells = append(cells, row.Sum, row.OrderSum)
+	for _, p := range row.Periods {
+		cells = append(cells, p.Sum, p.OrderSum)
+	}
+	return cells
+}
+
+// reportTotal returns cells of report total, its name is in the first column
+func reportTotal(report entity.Report) []string {
+	cells := reportRow(report, report.Total)
+	cells[0] = report.Total.Name
+	return cells
+}
+
+// reportGroups is a number of columns the rows are grouped by, sums follow them
+func reportGroups(report entity.Report) int {
+	n := 0
+	for _, ok := range []bool{report.ByService, report.ByUser} {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
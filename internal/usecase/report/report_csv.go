@@ -5,47 +5,42 @@ import (
 	"context"
 	"encoding/csv"
 	"fmt"
-	"os"
-	"strings"
+	"io"
 )
 
-// BalanceReport keeps a report dir name
-type BalanceReport struct {
-	reportDir string
+// CSVFile saves reports as csv: period, time zone and generation time, empty line, header, rows and total
+type CSVFile struct {
+	storage Storage
 }
 
-// New creates new dir and constructs BalanceReport
-func New(d string) (*BalanceReport, error) {
-	err := os.Mkdir(strings.Trim(d, "/"), 0750)
-	if err != nil {
-		return nil, err
-	}
-	return &BalanceReport{
-		reportDir: d,
-	}, nil
+// Format -.
+func (f *CSVFile) Format() string {
+	return "csv"
 }
 
-// GetDir is a getter for reportDir field of BalanceReport
-func (r *BalanceReport) GetDir() string {
-	return r.reportDir
+// ContentType -.
+func (f *CSVFile) ContentType() string {
+	return "text/csv"
 }
 
-// Create writes entity.Report to a csv file
-func (r *BalanceReport) Create(ctx context.Context, name string, report entity.Report) (string, error) {
+// Create writes entity.Report to a csv file, returns saved file with its size and checksum
+func (f *CSVFile) Create(ctx context.Context, name string, report entity.Report) (entity.SavedReport, error) {
 	name = name + ".csv"
-	file, err := os.Create(r.reportDir + name)
-	if err != nil {
-		return "", fmt.Errorf("ReportFile - Create: %w", err)
-	}
-	defer file.Close()
-	w := csv.NewWriter(file)
-	for _, v := range report.Sums {
-		line := []string{v.Name, v.Sum}
-		err = w.Write(line)
+	saved, err := saveReport(ctx, f.storage, name, f, report, func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		rows := append(reportInfo(report), []string{}, reportHeader(report))
+		for _, row := range report.Rows {
+			rows = append(rows, reportRow(report, row))
+		}
+		rows = append(rows, reportTotal(report))
+		err := cw.WriteAll(rows)
 		if err != nil {
-			return "", fmt.Errorf("ReportFile - Create: %w", err)
+			return err
 		}
-		w.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return entity.SavedReport{}, fmt.Errorf("ReportFile - Create: %w", err)
 	}
-	return name, nil
+	return saved, nil
 }
@@ -0,0 +1,74 @@
+package report
+
+import (
+	"balance_api/internal/entity"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+)
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Report {{.Period}}</title>
+</head>
+<body>
+<h1>Report {{.Period}}</h1>
+<p>Time zone {{.TimeZone}}</p>
+<p>Generated {{.Generated}}</p>
+<table>
+<thead>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+</thead>
+<tbody>
+{{- range .Rows}}
+<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{- end}}
+</tbody>
+<tfoot>
+<tr>{{range .Total}}<th>{{.}}</th>{{end}}</tr>
+</tfoot>
+</table>
+</body>
+</html>
+`))
+
+// HTMLFile saves reports as html page with a table of rows and total in its footer
+type HTMLFile struct {
+	storage Storage
+}
+
+// Format -.
+func (f *HTMLFile) Format() string {
+	return "html"
+}
+
+// ContentType -.
+func (f *HTMLFile) ContentType() string {
+	return "text/html; charset=utf-8"
+}
+
+// Create writes entity.Report to a html file, returns saved file with its size and checksum
+func (f *HTMLFile) Create(ctx context.Context, name string, report entity.Report) (entity.SavedReport, error) {
+	name = name + ".html"
+	saved, err := saveReport(ctx, f.storage, name, f, report, func(w io.Writer) error {
+		page := struct {
+			Period    string
+			TimeZone  string
+			Generated string
+			Header    []string
+			Rows      [][]string
+			Total     []string
+		}{report.Period(), report.Location.String(), created(report), reportHeader(report), nil, reportTotal(report)}
+		for _, row := range report.Rows {
+			page.Rows = append(page.Rows, reportRow(report, row))
+		}
+		return htmlReport.Execute(w, page)
+	})
+	if err != nil {
+		return entity.SavedReport{}, fmt.Errorf("ReportFile - Create: %w", err)
+	}
+	return saved, nil
+}
@@ -0,0 +1,76 @@
+package report
+
+import (
+	"balance_api/internal/entity"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type jsonReportPeriod struct {
+	Period    string `json:"period"`
+	Revenue   string `json:"revenue"`
+	OrdersSum string `json:"orders_sum"`
+}
+
+type jsonReportRow struct {
+	Service   string             `json:"service,omitempty"`
+	UserID    int                `json:"user_id,omitempty"`
+	Revenue   string             `json:"revenue"`
+	OrdersSum string             `json:"orders_sum"`
+	Periods   []jsonReportPeriod `json:"periods,omitempty"`
+}
+
+type jsonReport struct {
+	Period    string          `json:"period"`
+	TimeZone  string          `json:"time_zone"`
+	Generated string          `json:"generated"`
+	Breakdown string          `json:"breakdown,omitempty"`
+	Rows      []jsonReportRow `json:"rows"`
+	Total     jsonReportRow   `json:"total"`
+}
+
+func newJSONReportRow(row entity.ReportRow) jsonReportRow {
+	res := jsonReportRow{Service: row.Name, UserID: row.UserID, Revenue: row.Sum, OrdersSum: row.OrderSum}
+	for _, p := range row.Periods {
+		res.Periods = append(res.Periods, jsonReportPeriod{Period: p.Period, Revenue: p.Sum, OrdersSum: p.OrderSum})
+	}
+	return res
+}
+
+// JSONFile saves reports as json object with period, time zone, generation time, rows and total
+type JSONFile struct {
+	storage Storage
+}
+
+// Format -.
+func (f *JSONFile) Format() string {
+	return "json"
+}
+
+// ContentType -.
+func (f *JSONFile) ContentType() string {
+	return "application/json"
+}
+
+// Create writes entity.Report to a json file, returns saved file with its size and checksum
+func (f *JSONFile) Create(ctx context.Context, name string, report entity.Report) (entity.SavedReport, error) {
+	name = name + ".json"
+	res := jsonReport{Period: report.Period(), TimeZone: report.Location.String(), Generated: created(report),
+		Breakdown: report.Breakdown, Rows: make([]jsonReportRow, 0, len(report.Rows))}
+	for _, row := range report.Rows {
+		res.Rows = append(res.Rows, newJSONReportRow(row))
+	}
+	res.Total = newJSONReportRow(report.Total)
+	res.Total.Service = ""
+	saved, err := saveReport(ctx, f.storage, name, f, report, func(w io.Writer) error {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "    ")
+		return e.Encode(res)
+	})
+	if err != nil {
+		return entity.SavedReport{}, fmt.Errorf("ReportFile - Create: %w", err)
+	}
+	return saved, nil
+}
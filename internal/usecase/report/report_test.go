@@ -0,0 +1,200 @@
+package report
+
+import (
+	"balance_api/internal/entity"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testReport = entity.Report{
+	ReportParams: entity.ReportParams{From: time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC),
+		To: time.Date(2022, 9, 30, 0, 0, 0, 0, time.UTC), Location: time.UTC, ByService: true},
+	Created: time.Date(2022, 10, 1, 12, 0, 0, 0, time.FixedZone("", 3*3600)),
+	Rows: []entity.ReportRow{
+		{SumByService: entity.SumByService{Name: "Rent", Sum: "150.00", OrderSum: "200.00"}},
+		{SumByService: entity.SumByService{Name: "Good <bought>", Sum: "-20.50", OrderSum: "0.00"}},
+	},
+	Total: entity.ReportRow{SumByService: entity.SumByService{Name: "Total", Sum: "129.50", OrderSum: "200.00"}},
+}
+
+// testUserReport is grouped by users only with breakdown by months
+var testUserReport = entity.Report{
+	ReportParams: entity.ReportParams{From: time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC),
+		To: time.Date(2022, 8, 31, 0, 0, 0, 0, time.UTC), Location: time.UTC, Breakdown: "month", ByUser: true},
+	Created: time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC),
+	Periods: []string{"2022-07-01", "2022-08-01"},
+	Rows: []entity.ReportRow{{
+		SumByService: entity.SumByService{UserID: 7, Sum: "30.00", OrderSum: "40.00"},
+		Periods: []entity.SumByService{{UserID: 7, Period: "2022-07-01", Sum: "10.00", OrderSum: "10.00"},
+			{UserID: 7, Period: "2022-08-01", Sum: "20.00", OrderSum: "30.00"}},
+	}},
+	Total: entity.ReportRow{
+		SumByService: entity.SumByService{Name: "Total", Sum: "30.00", OrderSum: "40.00"},
+		Periods: []entity.SumByService{{Name: "Total", Period: "2022-07-01", Sum: "10.00", OrderSum: "10.00"},
+			{Name: "Total", Period: "2022-08-01", Sum: "20.00", OrderSum: "30.00"}},
+	},
+}
+
+// newTestReport returns BalanceReport keeping files in a temporary dir
+func newTestReport(t *testing.T) *BalanceReport {
+	s, err := NewLocalStorage(t.TempDir() + "/")
+	require.NoError(t, err)
+	return New(s)
+}
+
+func TestCSVFile(t *testing.T) {
+	r := newTestReport(t)
+	f := r.CSV()
+	require.Equal(t, "csv", f.Format())
+	require.Equal(t, "text/csv", f.ContentType())
+
+	saved, err := f.Create(context.Background(), "2022-09", testReport)
+	require.NoError(t, err)
+	require.Equal(t, "2022-09.csv", saved.Name)
+	b, err := os.ReadFile(r.GetDir() + saved.Name)
+	require.NoError(t, err)
+	require.Equal(t, "period,2022-09\n"+
+		"time_zone,UTC\n"+
+		"generated,2022-10-01T09:00:00Z\n"+
+		"\n"+
+		"service,revenue,orders_sum\n"+
+		"Rent,150.00,200.00\n"+
+		"Good <bought>,-20.50,0.00\n"+
+		"Total,129.50,200.00\n", string(b))
+	sum := sha256.Sum256(b)
+	require.Equal(t, entity.SavedReport{Name: "2022-09.csv", Period: "2022-09", TimeZone: "UTC", Format: "csv",
+		Size: int64(len(b)), Checksum: hex.EncodeToString(sum[:])}, saved)
+}
+
+func TestJSONFile(t *testing.T) {
+	r := newTestReport(t)
+	f := r.JSON()
+	require.Equal(t, "json", f.Format())
+	require.Equal(t, "application/json", f.ContentType())
+
+	saved, err := f.Create(context.Background(), "2022-09", testReport)
+	require.NoError(t, err)
+	require.Equal(t, "2022-09.json", saved.Name)
+	b, err := os.ReadFile(r.GetDir() + saved.Name)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"period": "2022-09",
+		"time_zone": "UTC",
+		"generated": "2022-10-01T09:00:00Z",
+		"rows": [
+			{"service": "Rent", "revenue": "150.00", "orders_sum": "200.00"},
+			{"service": "Good <bought>", "revenue": "-20.50", "orders_sum": "0.00"}
+		],
+		"total": {"revenue": "129.50", "orders_sum": "200.00"}
+	}`, string(b))
+}
+
+func TestXLSXFile(t *testing.T) {
+	r := newTestReport(t)
+	f := r.XLSX()
+	require.Equal(t, "xlsx", f.Format())
+	require.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", f.ContentType())
+
+	saved, err := f.Create(context.Background(), "2022-09", testReport)
+	require.NoError(t, err)
+	require.Equal(t, "2022-09.xlsx", saved.Name)
+	b, err := os.ReadFile(r.GetDir() + saved.Name)
+	require.NoError(t, err)
+	cells := readXLSX(t, b)
+	require.Equal(t, [][]string{
+		{"period", "2022-09"},
+		{"time_zone", "UTC"},
+		{"generated", "2022-10-01T09:00:00Z"},
+		{},
+		{"service", "revenue", "orders_sum"},
+		{"Rent", "150.00", "200.00"},
+		{"Good <bought>", "-20.50", "0.00"},
+		{"Total", "129.50", "200.00"},
+	}, xlsxValues(cells))
+	require.Equal(t, "", cells[7][1].Type, "total is a number")
+}
+
+func TestHTMLFile(t *testing.T) {
+	r := newTestReport(t)
+	f := r.HTML()
+	require.Equal(t, "html", f.Format())
+	require.Equal(t, "text/html; charset=utf-8", f.ContentType())
+
+	saved, err := f.Create(context.Background(), "2022-09", testReport)
+	require.NoError(t, err)
+	require.Equal(t, "2022-09.html", saved.Name)
+	b, err := os.ReadFile(r.GetDir() + saved.Name)
+	require.NoError(t, err)
+	page := string(b)
+	for _, s := range []string{
+		"<title>Report 2022-09</title>",
+		"<p>Time zone UTC</p>",
+		"<p>Generated 2022-10-01T09:00:00Z</p>",
+		"<tr><th>service</th><th>revenue</th><th>orders_sum</th></tr>",
+		"<tr><td>Rent</td><td>150.00</td><td>200.00</td></tr>",
+		"<tr><td>Good &lt;bought&gt;</td><td>-20.50</td><td>0.00</td></tr>",
+		"<tfoot>\n<tr><th>Total</th><th>129.50</th><th>200.00</th></tr>\n</tfoot>",
+	} {
+		require.True(t, strings.Contains(page, s), s)
+	}
+}
+
+func TestUserReport(t *testing.T) {
+	r := newTestReport(t)
+
+	saved, err := r.CSV().Create(context.Background(), "2022-07-01_2022-08-31_month_user", testUserReport)
+	require.NoError(t, err)
+	b, err := os.ReadFile(r.GetDir() + saved.Name)
+	require.NoError(t, err)
+	require.Equal(t, "period,2022-07-01/2022-08-31\n"+
+		"time_zone,UTC\n"+
+		"generated,2022-10-01T12:00:00Z\n"+
+		"\n"+
+		"user_id,revenue,orders_sum,revenue 2022-07-01,orders_sum 2022-07-01,revenue 2022-08-01,orders_sum 2022-08-01\n"+
+		"7,30.00,40.00,10.00,10.00,20.00,30.00\n"+
+		"Total,30.00,40.00,10.00,10.00,20.00,30.00\n", string(b))
+
+	saved, err = r.JSON().Create(context.Background(), "2022-07-01_2022-08-31_month_user", testUserReport)
+	require.NoError(t, err)
+	b, err = os.ReadFile(r.GetDir() + saved.Name)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"period": "2022-07-01/2022-08-31",
+		"time_zone": "UTC",
+		"generated": "2022-10-01T12:00:00Z",
+		"breakdown": "month",
+		"rows": [{"user_id": 7, "revenue": "30.00", "orders_sum": "40.00", "periods": [
+			{"period": "2022-07-01", "revenue": "10.00", "orders_sum": "10.00"},
+			{"period": "2022-08-01", "revenue": "20.00", "orders_sum": "30.00"}
+		]}],
+		"total": {"revenue": "30.00", "orders_sum": "40.00", "periods": [
+			{"period": "2022-07-01", "revenue": "10.00", "orders_sum": "10.00"},
+			{"period": "2022-08-01", "revenue": "20.00", "orders_sum": "30.00"}
+		]}
+	}`, string(b))
+
+	saved, err = r.XLSX().Create(context.Background(), "2022-07-01_2022-08-31_month_user", testUserReport)
+	require.NoError(t, err)
+	b, err = os.ReadFile(r.GetDir() + saved.Name)
+	require.NoError(t, err)
+	cells := readXLSX(t, b)
+	require.Equal(t, []string{"7", "30.00", "40.00", "10.00", "10.00", "20.00", "30.00"}, xlsxValues(cells)[5])
+	require.Equal(t, "", cells[5][0].Type, "user id is a number")
+	require.Equal(t, "inlineStr", cells[6][0].Type, "total name is a string")
+}
+
+func TestCreateFileError(t *testing.T) {
+	dir := t.TempDir() + "/reports/"
+	s, err := NewLocalStorage(dir)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(dir))
+	r := New(s)
+	_, err = r.CSV().Create(context.Background(), "2022-09", testReport)
+	require.Error(t, err)
+}
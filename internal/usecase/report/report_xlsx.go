@@ -0,0 +1,73 @@
+package report
+
+import (
+	"balance_api/internal/entity"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XLSXFile saves reports as xlsx sheet laid out as csv one
+type XLSXFile struct {
+	storage Storage
+}
+
+// Format -.
+func (f *XLSXFile) Format() string {
+	return "xlsx"
+}
+
+// ContentType -.
+func (f *XLSXFile) ContentType() string {
+	return xlsxContentType
+}
+
+// reportNumbers marks columns of reportHeader which are written to xlsx as numbers: user ids and sums
+func reportNumbers(report entity.Report) map[int]bool {
+	first := reportGroups(report)
+	if report.ByUser {
+		first--
+	}
+	numbers := make(map[int]bool)
+	for i := first; i < len(reportHeader(report)); i++ {
+		numbers[i] = true
+	}
+	return numbers
+}
+
+// Create writes entity.Report to a xlsx file, returns saved file with its size and checksum
+func (f *XLSXFile) Create(ctx context.Context, name string, report entity.Report) (entity.SavedReport, error) {
+	name = name + ".xlsx"
+	saved, err := saveReport(ctx, f.storage, name, f, report, func(w io.Writer) error {
+		// sheet names can't have slashes
+		x, err := newXLSXWriter(w, "Report "+strings.ReplaceAll(report.Period(), "/", " - "))
+		if err != nil {
+			return err
+		}
+		for _, row := range append(reportInfo(report), []string{}, reportHeader(report)) {
+			err = x.writeRow(row, nil)
+			if err != nil {
+				return err
+			}
+		}
+		numbers := reportNumbers(report)
+		for _, row := range report.Rows {
+			err = x.writeRow(reportRow(report, row), numbers)
+			if err != nil {
+				return err
+			}
+		}
+		// total has no user id, its name takes the first column if report is grouped by users only
+		delete(numbers, reportGroups(report)-1)
+		err = x.writeRow(reportTotal(report), numbers)
+		if err != nil {
+			return err
+		}
+		return x.close()
+	})
+	if err != nil {
+		return entity.SavedReport{}, fmt.Errorf("ReportFile - Create: %w", err)
+	}
+	return saved, nil
+}
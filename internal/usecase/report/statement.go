@@ -0,0 +1,179 @@
+package report
+
+import (
+	"balance_api/internal/entity"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// statementHeader names columns of statement, sums and balances are decimal strings
+var statementHeader = []string{"time", "operation", "description", "status", "sum", "captured", "comment",
+	"metadata", "available_after", "reserved_after"}
+
+var kindNames = map[int]string{
+	entity.KindOrder:         "order",
+	entity.KindReplenishment: "replenishment",
+	entity.KindTransferOut:   "transfer_out",
+	entity.KindTransferIn:    "transfer_in",
+	entity.KindRefund:        "refund",
+}
+
+// NewStatement returns entity.StatementWriter of given format writing to w: csv, jsonl or xlsx
+func (r *BalanceReport) NewStatement(w io.Writer, format string) (entity.StatementWriter, error) {
+	switch format {
+	case "csv":
+		return newCSVStatement(w)
+	case "jsonl":
+		return newJSONLStatement(w), nil
+	case "xlsx":
+		return newXLSXStatement(w)
+	}
+	return nil, fmt.Errorf("ReportFile - NewStatement: unknown format %q", format)
+}
+
+// statementRow makes values of statement columns, metadata is a JSON object or empty string if there is no one
+func statementRow(o entity.Order) ([]string, error) {
+	metadata := ""
+	if len(o.Metadata) > 0 {
+		b, err := json.Marshal(o.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		metadata = string(b)
+	}
+	after := balanceAfter(o)
+	return []string{o.Time.Time.UTC().Format(time.RFC3339), kindNames[o.Kind], o.ServiceName, o.Status, o.Sum,
+		o.Captured, o.Comment, metadata, after.Available, after.Reserved}, nil
+}
+
+func balanceAfter(o entity.Order) entity.BalanceAfter {
+	if o.BalanceAfter == nil {
+		return entity.BalanceAfter{}
+	}
+	return *o.BalanceAfter
+}
+
+type csvStatement struct {
+	w *csv.Writer
+}
+
+func newCSVStatement(w io.Writer) (*csvStatement, error) {
+	s := &csvStatement{w: csv.NewWriter(w)}
+	err := s.w.Write(statementHeader)
+	if err != nil {
+		return nil, fmt.Errorf("ReportFile - NewStatement: %w", err)
+	}
+	return s, nil
+}
+
+// Write -.
+func (s *csvStatement) Write(o entity.Order) error {
+	row, err := statementRow(o)
+	if err != nil {
+		return fmt.Errorf("ReportFile - Write: %w", err)
+	}
+	err = s.w.Write(row)
+	if err != nil {
+		return fmt.Errorf("ReportFile - Write: %w", err)
+	}
+	return nil
+}
+
+// Close -.
+func (s *csvStatement) Close() error {
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return fmt.Errorf("ReportFile - Close: %w", err)
+	}
+	return nil
+}
+
+// jsonlLine is a line of JSON Lines statement
+type jsonlLine struct {
+	Time           string          `json:"time"`
+	Operation      string          `json:"operation"`
+	Description    string          `json:"description"`
+	Status         string          `json:"status"`
+	Sum            string          `json:"sum"`
+	Captured       string          `json:"captured,omitempty"`
+	Comment        string          `json:"comment,omitempty"`
+	Metadata       entity.Metadata `json:"metadata,omitempty"`
+	AvailableAfter string          `json:"available_after"`
+	ReservedAfter  string          `json:"reserved_after"`
+}
+
+type jsonlStatement struct {
+	b *bufio.Writer
+	e *json.Encoder
+}
+
+func newJSONLStatement(w io.Writer) *jsonlStatement {
+	b := bufio.NewWriter(w)
+	e := json.NewEncoder(b)
+	e.SetEscapeHTML(false)
+	return &jsonlStatement{b: b, e: e}
+}
+
+// Write -.
+func (s *jsonlStatement) Write(o entity.Order) error {
+	after := balanceAfter(o)
+	err := s.e.Encode(jsonlLine{Time: o.Time.Time.UTC().Format(time.RFC3339), Operation: kindNames[o.Kind],
+		Description: o.ServiceName, Status: o.Status, Sum: o.Sum, Captured: o.Captured, Comment: o.Comment,
+		Metadata: o.Metadata, AvailableAfter: after.Available, ReservedAfter: after.Reserved})
+	if err != nil {
+		return fmt.Errorf("ReportFile - Write: %w", err)
+	}
+	return nil
+}
+
+// Close -.
+func (s *jsonlStatement) Close() error {
+	if err := s.b.Flush(); err != nil {
+		return fmt.Errorf("ReportFile - Close: %w", err)
+	}
+	return nil
+}
+
+// statementNumbers marks columns of statementHeader which are written to xlsx as numbers
+var statementNumbers = map[int]bool{4: true, 5: true, 8: true, 9: true}
+
+type xlsxStatement struct {
+	x *xlsxWriter
+}
+
+func newXLSXStatement(w io.Writer) (*xlsxStatement, error) {
+	x, err := newXLSXWriter(w, "Statement")
+	if err != nil {
+		return nil, fmt.Errorf("ReportFile - NewStatement: %w", err)
+	}
+	err = x.writeRow(statementHeader, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ReportFile - NewStatement: %w", err)
+	}
+	return &xlsxStatement{x: x}, nil
+}
+
+// Write -.
+func (s *xlsxStatement) Write(o entity.Order) error {
+	row, err := statementRow(o)
+	if err != nil {
+		return fmt.Errorf("ReportFile - Write: %w", err)
+	}
+	err = s.x.writeRow(row, statementNumbers)
+	if err != nil {
+		return fmt.Errorf("ReportFile - Write: %w", err)
+	}
+	return nil
+}
+
+// Close -.
+func (s *xlsxStatement) Close() error {
+	if err := s.x.close(); err != nil {
+		return fmt.Errorf("ReportFile - Close: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package report
+
+import (
+	"archive/zip"
+	"balance_api/internal/entity"
+	"bytes"
+	"encoding/xml"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+var statementOrders = []entity.Order{{
+	Kind: entity.KindReplenishment, ServiceName: "Replenishment", Status: "Approved", Sum: "500.00",
+	Time: entity.MyTime{Time: time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)}, Comment: "by card",
+	Metadata: entity.Metadata{}, BalanceAfter: &entity.BalanceAfter{Available: "500.00", Reserved: "0.00"},
+}, {
+	Kind: entity.KindOrder, ServiceName: "Rent", Status: "Approved", Sum: "100.00", Captured: "80.00",
+	Time:    entity.MyTime{Time: time.Date(2022, 10, 24, 14, 0, 0, 0, time.FixedZone("", 3600))},
+	Comment: "a, \"b\" & <c>", Metadata: entity.Metadata{"invoice": "INV-42"},
+	BalanceAfter: &entity.BalanceAfter{Available: "400.00", Reserved: "100.00"},
+}}
+
+func writeStatement(t *testing.T, format string) string {
+	var b bytes.Buffer
+	s, err := (&BalanceReport{}).NewStatement(&b, format)
+	require.NoError(t, err)
+	for _, o := range statementOrders {
+		require.NoError(t, s.Write(o))
+	}
+	require.NoError(t, s.Close())
+	return b.String()
+}
+
+func TestStatementCSV(t *testing.T) {
+	require.Equal(t, "time,operation,description,status,sum,captured,comment,metadata,available_after,reserved_after\n"+
+		"2022-10-24T13:00:00Z,replenishment,Replenishment,Approved,500.00,,by card,,500.00,0.00\n"+
+		"2022-10-24T13:00:00Z,order,Rent,Approved,100.00,80.00,\"a, \"\"b\"\" & <c>\",\"{\"\"invoice\"\":\"\"INV-42\"\"}\",400.00,100.00\n",
+		writeStatement(t, "csv"))
+}
+
+func TestStatementJSONL(t *testing.T) {
+	require.Equal(t, `{"time":"2022-10-24T13:00:00Z","operation":"replenishment","description":"Replenishment",`+
+		`"status":"Approved","sum":"500.00","comment":"by card",`+
+		`"available_after":"500.00","reserved_after":"0.00"}`+"\n"+
+		`{"time":"2022-10-24T13:00:00Z","operation":"order","description":"Rent","status":"Approved",`+
+		`"sum":"100.00","captured":"80.00","comment":"a, \"b\" & <c>","metadata":{"invoice":"INV-42"},`+
+		`"available_after":"400.00","reserved_after":"100.00"}`+"\n",
+		writeStatement(t, "jsonl"))
+}
+
+// xlsxCell is a cell of sheet, Type is empty for numbers
+type xlsxCell struct {
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline string `xml:"is>t"`
+}
+
+// readXLSX checks that file has all parts and they are well-formed and returns cells of the sheet
+func readXLSX(t *testing.T, file []byte) [][]xlsxCell {
+	z, err := zip.NewReader(bytes.NewReader(file), int64(len(file)))
+	require.NoError(t, err)
+
+	var names []string
+	var sheet []byte
+	for _, f := range z.File {
+		names = append(names, f.Name)
+		r, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+		d := xml.NewDecoder(bytes.NewReader(b))
+		for {
+			_, err = d.Token()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, f.Name)
+		}
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			sheet = b
+		}
+	}
+	require.Equal(t, []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels",
+		"xl/worksheets/sheet1.xml"}, names)
+
+	var ws struct {
+		Rows []struct {
+			Cells []xlsxCell `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	require.NoError(t, xml.Unmarshal(sheet, &ws))
+	var rows [][]xlsxCell
+	for _, r := range ws.Rows {
+		rows = append(rows, r.Cells)
+	}
+	return rows
+}
+
+// xlsxValues returns values of cells
+func xlsxValues(rows [][]xlsxCell) [][]string {
+	var res [][]string
+	for _, r := range rows {
+		row := []string{}
+		for _, c := range r {
+			if c.Type == "inlineStr" {
+				row = append(row, c.Inline)
+			} else {
+				row = append(row, c.Value)
+			}
+		}
+		res = append(res, row)
+	}
+	return res
+}
+
+func TestStatementXLSX(t *testing.T) {
+	cells := readXLSX(t, []byte(writeStatement(t, "xlsx")))
+	rows := xlsxValues(cells)
+	require.Len(t, rows, 3)
+	require.Equal(t, statementHeader, rows[0])
+	require.Equal(t, []string{"2022-10-24T13:00:00Z", "order", "Rent", "Approved", "100.00", "80.00",
+		"a, \"b\" & <c>", `{"invoice":"INV-42"}`, "400.00", "100.00"}, rows[2])
+	require.Equal(t, "", cells[2][4].Type, "sum is a number")
+}
+
+func TestStatementUnknownFormat(t *testing.T) {
+	_, err := (&BalanceReport{}).NewStatement(&bytes.Buffer{}, "pdf")
+	require.Error(t, err)
+}
@@ -0,0 +1,147 @@
+package report
+
+import (
+	"balance_api/internal/entity"
+	"balance_api/pkg/s3"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Storage keeps report files
+type Storage interface {
+	// Save writes file name by write, file isn't kept if write fails
+	Save(ctx context.Context, name, contentType string, write func(w io.Writer) error) error
+	// URL returns pre-signed link to download file or empty string if files are served by api from Dir
+	URL(ctx context.Context, name string) (string, error)
+	// Dir returns local dir of files or empty string if they aren't kept on disk
+	Dir() string
+}
+
+// fileFormat is a format of report file
+type fileFormat interface {
+	Format() string
+	ContentType() string
+}
+
+// saveReport writes report file name to storage by write, size and checksum of the file are counted on the way
+func saveReport(ctx context.Context, s Storage, name string, f fileFormat, report entity.Report,
+	write func(w io.Writer) error) (entity.SavedReport, error) {
+	h := sha256.New()
+	c := &countWriter{}
+	err := s.Save(ctx, name, f.ContentType(), func(w io.Writer) error {
+		c.w = io.MultiWriter(w, h)
+		return write(c)
+	})
+	if err != nil {
+		return entity.SavedReport{}, err
+	}
+	return entity.SavedReport{
+		Name:     name,
+		Period:   report.Period(),
+		TimeZone: report.Location.String(),
+		Format:   f.Format(),
+		Size:     c.n,
+		Checksum: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// countWriter counts written bytes
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// LocalStorage keeps report files in a local dir
+type LocalStorage struct {
+	dir string
+}
+
+// NewLocalStorage creates dir if it doesn't exist and constructs LocalStorage, dir ends with slash
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalStorage{dir: dir}, nil
+}
+
+// Save writes file to a temporary file and renames it, so a file being written is never served
+func (s *LocalStorage) Save(ctx context.Context, name, contentType string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(s.dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	b := bufio.NewWriter(file)
+	err = write(b)
+	if err == nil {
+		err = b.Flush()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0640)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+// URL -.
+func (s *LocalStorage) URL(ctx context.Context, name string) (string, error) {
+	return "", nil
+}
+
+// Dir -.
+func (s *LocalStorage) Dir() string {
+	return s.dir
+}
+
+// S3Storage keeps report files in a bucket of S3-compatible storage shared by all api instances
+type S3Storage struct {
+	c   *s3.Client
+	ttl time.Duration
+}
+
+// NewS3Storage constructs S3Storage, pre-signed links to files expire in ttl
+func NewS3Storage(c *s3.Client, ttl time.Duration) *S3Storage {
+	return &S3Storage{c: c, ttl: ttl}
+}
+
+// Save writes file to memory and uploads it
+func (s *S3Storage) Save(ctx context.Context, name, contentType string, write func(w io.Writer) error) error {
+	var b bytes.Buffer
+	err := write(&b)
+	if err != nil {
+		return err
+	}
+	return s.c.PutObject(ctx, name, contentType, b.Bytes())
+}
+
+// URL -.
+func (s *S3Storage) URL(ctx context.Context, name string) (string, error) {
+	return s.c.PresignGetObject(name, s.ttl), nil
+}
+
+// Dir -.
+func (s *S3Storage) Dir() string {
+	return ""
+}
@@ -0,0 +1,78 @@
+package report
+
+import (
+	"balance_api/pkg/s3"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir() + "/reports/"
+	s, err := NewLocalStorage(dir)
+	require.NoError(t, err)
+	// the dir is kept by previous start or other instance
+	s, err = NewLocalStorage(dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, s.Dir())
+
+	err = s.Save(ctx, "2022-10.csv", "text/csv", func(w io.Writer) error {
+		_, err := io.WriteString(w, "a,b")
+		return err
+	})
+	require.NoError(t, err)
+	err = s.Save(ctx, "2022-11.csv", "text/csv", func(w io.Writer) error {
+		_, _ = io.WriteString(w, "a")
+		return errors.New("aboba")
+	})
+	require.Error(t, err)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1, "failed and temporary files are removed")
+	b, err := os.ReadFile(dir + "2022-10.csv")
+	require.NoError(t, err)
+	assert.Equal(t, "a,b", string(b))
+	url, err := s.URL(ctx, "2022-10.csv")
+	assert.NoError(t, err)
+	assert.Empty(t, url)
+}
+
+func TestS3Storage(t *testing.T) {
+	ctx := context.Background()
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			b, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = r.Header.Get("Content-Type") + " " + string(b)
+		}
+	}))
+	defer srv.Close()
+	c, err := s3.New(srv.URL, "reports", "key", "secret")
+	require.NoError(t, err)
+	s := NewS3Storage(c, time.Hour)
+	assert.Empty(t, s.Dir())
+
+	saved, err := New(s).CSV().Create(ctx, "2022-09", testReport)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(objects["/reports/2022-09.csv"], "text/csv period,2022-09\n"))
+	err = s.Save(ctx, "2022-11.csv", "text/csv", func(w io.Writer) error {
+		return errors.New("aboba")
+	})
+	require.Error(t, err)
+	assert.Len(t, objects, 1)
+
+	url, err := s.URL(ctx, saved.Name)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(url, srv.URL+"/reports/2022-09.csv?X-Amz-Algorithm="), url)
+	assert.Contains(t, url, "X-Amz-Expires=3600")
+}
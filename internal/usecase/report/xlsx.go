@@ -0,0 +1,105 @@
+package report
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// Minimal SpreadsheetML package with one sheet. Sheet is the last part of zip, so its rows are streamed to
+// the output as they come and only the tail of zip is written on close
+const (
+	xlsxContentType  = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	xlsxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ` +
+		`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ` +
+		`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+	xlsxRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Target="xl/workbook.xml" ` +
+		`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument"/>` +
+		`</Relationships>`
+	// sheet name is put by fmt
+	xlsxWorkbook = xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = xml.Header +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Target="worksheets/sheet1.xml" ` +
+		`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet"/>` +
+		`</Relationships>`
+	xlsxSheetStart = xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// xlsxWriter writes xlsx file with one sheet row by row
+type xlsxWriter struct {
+	z     *zip.Writer
+	sheet *bufio.Writer
+}
+
+func newXLSXWriter(w io.Writer, sheetName string) (*xlsxWriter, error) {
+	z := zip.NewWriter(w)
+	for _, part := range []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, sheetName)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	} {
+		f, err := z.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(f, part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x := &xlsxWriter{z: z, sheet: bufio.NewWriter(f)}
+	_, err = x.sheet.WriteString(xlsxSheetStart)
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// writeRow writes cells as inline strings, cells of numbers columns are written as numbers.
+// Error of bufio.Writer is sticky, so error of the last write is returned
+func (x *xlsxWriter) writeRow(cells []string, numbers map[int]bool) error {
+	x.sheet.WriteString("<row>")
+	for i, v := range cells {
+		switch {
+		case v == "":
+			x.sheet.WriteString("<c/>")
+		case numbers[i]:
+			x.sheet.WriteString("<c><v>")
+			xml.EscapeText(x.sheet, []byte(v))
+			x.sheet.WriteString("</v></c>")
+		default:
+			x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(x.sheet, []byte(v))
+			x.sheet.WriteString("</t></is></c>")
+		}
+	}
+	_, err := x.sheet.WriteString("</row>")
+	return err
+}
+
+func (x *xlsxWriter) close() error {
+	x.sheet.WriteString(xlsxSheetEnd)
+	err := x.sheet.Flush()
+	if err != nil {
+		return err
+	}
+	return x.z.Close()
+}
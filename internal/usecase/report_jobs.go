@@ -0,0 +1,241 @@
+package usecase
+
+import (
+	"balance_api/internal/entity"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// reportJobTimeout limits generation of one report
+	reportJobTimeout = 30 * time.Minute
+	// reportJobHeartbeat is how often running job saves its progress even if it hasn't changed
+	reportJobHeartbeat = 10 * time.Second
+	// reportJobStale is how long running job may stay without heartbeat before it is taken again,
+	// so jobs of stopped or crashed instances aren't lost
+	reportJobStale = 6 * reportJobHeartbeat
+	// reportJobPoll is how often idle workers look for jobs queued by other instances
+	reportJobPoll = 5 * time.Second
+	// reportJobChunks is a number of parts which days of job are split into to get sums from db
+	reportJobChunks = 10
+	// reportJobAggregated is a progress of job which sums are got from db
+	reportJobAggregated = 80
+)
+
+// reportWorkers is a pool of goroutines generating reports of queued jobs, wake tells them there is a new job
+type reportWorkers struct {
+	cancel context.CancelFunc
+	wake   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// CreateReportJob queues generation of report file. Errors of UpdateReport about format and breakdown are
+// returned before job is queued
+func (uc *BalanceUseCase) CreateReportJob(ctx context.Context, params entity.ReportParams,
+	format string) (entity.ReportJob, error) {
+	_, params, err := uc.checkReport(params, format)
+	if err != nil {
+		return entity.ReportJob{}, err
+	}
+	job, err := uc.repo.CreateReportJob(ctx, entity.ReportJob{Params: params, Format: format})
+	if err != nil {
+		return entity.ReportJob{}, fmt.Errorf("BalanceUseCase - CreateReportJob: %w", err)
+	}
+	if uc.jobs != nil {
+		select {
+		case uc.jobs.wake <- struct{}{}:
+		default:
+		}
+	}
+	return job, nil
+}
+
+// GetReportJob returns report job, entity.ErrNoReportJob if there is no such one
+func (uc *BalanceUseCase) GetReportJob(ctx context.Context, id int) (entity.ReportJob, error) {
+	job, err := uc.repo.GetReportJob(ctx, id)
+	switch {
+	case errors.Is(err, entity.ErrNoReportJob):
+		return entity.ReportJob{}, err
+	case err != nil:
+		return entity.ReportJob{}, fmt.Errorf("BalanceUseCase - GetReportJob: %w", err)
+	}
+	return job, nil
+}
+
+// StartReportJobs starts n workers generating reports of queued jobs, unexpected errors are passed to onError.
+// Jobs are taken from db, so they are shared with workers of other instances
+func (uc *BalanceUseCase) StartReportJobs(n int, onError func(err error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	uc.jobs = &reportWorkers{
+		cancel: cancel,
+		wake:   make(chan struct{}, n),
+	}
+	for i := 0; i < n; i++ {
+		uc.jobs.wg.Add(1)
+		go func() {
+			defer uc.jobs.wg.Done()
+			uc.runReportWorker(ctx, onError)
+		}()
+	}
+}
+
+// StopReportJobs stops workers and waits for them, interrupted jobs are queued again
+func (uc *BalanceUseCase) StopReportJobs() {
+	if uc.jobs == nil {
+		return
+	}
+	uc.jobs.cancel()
+	uc.jobs.wg.Wait()
+}
+
+// runReportWorker takes jobs until there are no more of them, then waits for a new one or a poll
+func (uc *BalanceUseCase) runReportWorker(ctx context.Context, onError func(err error)) {
+	t := time.NewTicker(reportJobPoll)
+	defer t.Stop()
+	for {
+		for ctx.Err() == nil && uc.runReportJob(ctx, onError) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-uc.jobs.wake:
+		case <-t.C:
+		}
+	}
+}
+
+// runReportJob generates report of the next job and saves its result, returns false if there was no job to take
+func (uc *BalanceUseCase) runReportJob(ctx context.Context, onError func(err error)) bool {
+	job, err := uc.repo.StartReportJob(ctx, time.Now().Add(-reportJobStale))
+	switch {
+	case errors.Is(err, entity.ErrNoReportJob):
+		return false
+	case err != nil:
+		if ctx.Err() == nil {
+			onError(fmt.Errorf("BalanceUseCase - runReportJob: %w", err))
+		}
+		return false
+	}
+
+	jobCtx, cancel := context.WithTimeout(ctx, reportJobTimeout)
+	progress := make(chan int, 1)
+	lost := make(chan bool, 1)
+	go func(job entity.ReportJob) {
+		lost <- uc.heartbeatReportJob(jobCtx, job, progress, cancel, onError)
+	}(job)
+	job.Name, err = uc.generateReport(jobCtx, job, progress)
+	cancel()
+	if <-lost {
+		// job is generated by another worker now
+		return true
+	}
+	switch {
+	case ctx.Err() != nil:
+		job.Status, job.Progress, job.Name = entity.ReportJobQueued, 0, ""
+	case errors.Is(err, entity.ErrEmptyReport), errors.Is(err, entity.ErrUnknownFormat),
+		errors.Is(err, entity.ErrReportPeriods):
+		job.Status, job.Error = entity.ReportJobFailed, err.Error()
+	case err != nil:
+		onError(fmt.Errorf("BalanceUseCase - runReportJob: job %d: %w", job.ID, err))
+		job.Status, job.Error = entity.ReportJobFailed, "internal error"
+	default:
+		job.Status, job.Progress = entity.ReportJobDone, 100
+	}
+	// result is saved even if workers are stopped, so interrupted job is queued again
+	err = uc.repo.UpdateReportJob(context.Background(), job)
+	if err != nil && !errors.Is(err, entity.ErrNoReportJob) {
+		onError(fmt.Errorf("BalanceUseCase - runReportJob: %w", err))
+	}
+	return true
+}
+
+// heartbeatReportJob saves progress of running job when it changes and every reportJobHeartbeat until ctx is
+// done. If job was taken again by another worker, generation is canceled and true is returned
+func (uc *BalanceUseCase) heartbeatReportJob(ctx context.Context, job entity.ReportJob, progress <-chan int,
+	cancel context.CancelFunc, onError func(err error)) bool {
+	t := time.NewTicker(reportJobHeartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case job.Progress = <-progress:
+		case <-t.C:
+		}
+		err := uc.repo.UpdateReportJob(ctx, job)
+		switch {
+		case errors.Is(err, entity.ErrNoReportJob):
+			cancel()
+			return true
+		case err != nil && ctx.Err() == nil:
+			onError(fmt.Errorf("BalanceUseCase - heartbeatReportJob: job %d: %w", job.ID, err))
+		}
+	}
+}
+
+// generateReport writes report file of job. Sums are got from db by parts of job's days, progress is sent
+// after each of them
+func (uc *BalanceUseCase) generateReport(ctx context.Context, job entity.ReportJob,
+	progress chan int) (string, error) {
+	f, params, err := uc.checkReport(job.Params, job.Format)
+	if err != nil {
+		return "", err
+	}
+	chunks := reportChunks(params, reportJobChunks)
+	var sums []entity.SumByService
+	for i, chunk := range chunks {
+		part, err := uc.repo.GetReport(ctx, chunk)
+		if err != nil && !errors.Is(err, entity.ErrEmptyReport) {
+			return "", err
+		}
+		sums = append(sums, part...)
+		setProgress(progress, reportJobAggregated*(i+1)/len(chunks))
+	}
+	if len(sums) == 0 {
+		return "", entity.ErrEmptyReport
+	}
+	// rows of one service and user are made of sums of all parts
+	sort.SliceStable(sums, func(i, j int) bool {
+		a, b := sums[i], sums[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.Period < b.Period
+	})
+	return uc.createReport(ctx, f, params, sums)
+}
+
+// setProgress replaces progress which isn't saved yet
+func setProgress(progress chan int, p int) {
+	select {
+	case <-progress:
+	default:
+	}
+	progress <- p
+}
+
+// reportChunks splits days of report into at most n parts of the same length
+func reportChunks(params entity.ReportParams, n int) []entity.ReportParams {
+	days := 0
+	for t := params.From; !t.After(params.To); t = t.AddDate(0, 0, 1) {
+		days++
+	}
+	if days < n {
+		n = days
+	}
+	chunks := make([]entity.ReportParams, 0, n)
+	for i := 0; i < n; i++ {
+		chunk := params
+		chunk.From = params.From.AddDate(0, 0, days*i/n)
+		chunk.To = params.From.AddDate(0, 0, days*(i+1)/n-1)
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
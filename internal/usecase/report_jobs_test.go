@@ -0,0 +1,297 @@
+package usecase
+
+import (
+	"balance_api/internal/entity"
+	reportmock "balance_api/internal/mocks/report"
+	repomock "balance_api/internal/mocks/repository"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testReportParams = entity.ReportParams{From: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+	To: time.Date(2022, 10, 31, 0, 0, 0, 0, time.UTC), Location: time.UTC, ByService: true}
+
+func TestCreateReportJob(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	f := reportmock.NewReportFile(t)
+	f.On("Format").Return("csv")
+	uc := New(r, reportmock.NewReportDir(t), ReportFormats(f))
+
+	days := testReportParams
+	days.To, days.Breakdown = time.Date(2023, 10, 31, 0, 0, 0, 0, time.UTC), "day"
+	r.On("CreateReportJob", ctx, entity.ReportJob{Params: testReportParams, Format: "csv"}).
+		Return(entity.ReportJob{ID: 1, Params: testReportParams, Format: "csv", Status: entity.ReportJobQueued}, nil)
+	users := testReportParams
+	users.ByService, users.ByUser = false, true
+	r.On("CreateReportJob", ctx, entity.ReportJob{Params: users, Format: "csv"}).
+		Return(entity.ReportJob{}, errors.New("aboba"))
+
+	type TestCase struct {
+		name        string
+		params      entity.ReportParams
+		format      string
+		expectedVal entity.ReportJob
+		expectedErr bool
+	}
+
+	cases := []TestCase{{
+		name:        "valid",
+		params:      entity.ReportParams{From: testReportParams.From, To: testReportParams.To, Location: time.UTC},
+		format:      "csv",
+		expectedVal: entity.ReportJob{ID: 1, Params: testReportParams, Format: "csv", Status: entity.ReportJobQueued},
+	}, {
+		name:        "unknown format",
+		params:      testReportParams,
+		format:      "xlsx",
+		expectedErr: true,
+	}, {
+		name:        "too many periods",
+		params:      days,
+		format:      "csv",
+		expectedErr: true,
+	}, {
+		name:        "db error",
+		params:      users,
+		format:      "csv",
+		expectedErr: true,
+	},
+	}
+
+	for _, tc := range cases {
+		job, err := uc.CreateReportJob(ctx, tc.params, tc.format)
+		assert.Equal(t, tc.expectedVal, job, tc.name)
+		assert.Equal(t, tc.expectedErr, err != nil, tc.name)
+	}
+}
+
+func TestGetReportJob(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+
+	r.On("GetReportJob", ctx, 1).Return(entity.ReportJob{ID: 1, Status: entity.ReportJobDone}, nil)
+	r.On("GetReportJob", ctx, 2).Return(entity.ReportJob{}, entity.ErrNoReportJob)
+
+	job, err := uc.GetReportJob(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.ReportJob{ID: 1, Status: entity.ReportJobDone}, job)
+
+	_, err = uc.GetReportJob(ctx, 2)
+	assert.ErrorIs(t, err, entity.ErrNoReportJob)
+}
+
+func TestRunReportJob(t *testing.T) {
+	ctx := context.Background()
+	sums := []entity.SumByService{{Sum: "10.00", OrderSum: "10.00", Name: "a"}}
+	job := entity.ReportJob{ID: 1, Params: testReportParams, Format: "csv", Status: entity.ReportJobRunning}
+	saved := func(status string, progress int, name, msg string) entity.ReportJob {
+		j := job
+		j.Status, j.Progress, j.Name, j.Error = status, progress, name, msg
+		return j
+	}
+	running := mock.MatchedBy(func(j entity.ReportJob) bool {
+		return j.Status == entity.ReportJobRunning
+	})
+
+	for _, tc := range []struct {
+		name     string
+		setup    func(r *repomock.BalanceRepo, f *reportmock.ReportFile)
+		taken    bool
+		errors   int
+		canceled bool
+	}{{
+		name: "no jobs",
+		setup: func(r *repomock.BalanceRepo, f *reportmock.ReportFile) {
+			r.On("StartReportJob", mock.Anything, mock.Anything).Return(entity.ReportJob{}, entity.ErrNoReportJob)
+		},
+	}, {
+		name: "db error on start",
+		setup: func(r *repomock.BalanceRepo, f *reportmock.ReportFile) {
+			r.On("StartReportJob", mock.Anything, mock.Anything).Return(entity.ReportJob{}, errors.New("aboba"))
+		},
+		errors: 1,
+	}, {
+		name: "done",
+		setup: func(r *repomock.BalanceRepo, f *reportmock.ReportFile) {
+			r.On("StartReportJob", mock.Anything, mock.Anything).Return(job, nil)
+			r.On("GetReport", mock.Anything, mock.Anything).Return(sums, nil)
+			r.On("UpdateReportJob", mock.Anything, running).Return(nil).Maybe()
+			f.On("Create", mock.Anything, "2022-10", mock.Anything).
+				Return(entity.SavedReport{Name: "2022-10.csv"}, nil)
+			r.On("SaveReport", mock.Anything, entity.SavedReport{Name: "2022-10.csv"}).
+				Return(entity.SavedReport{Name: "2022-10.csv"}, nil)
+			r.On("UpdateReportJob", mock.Anything, saved(entity.ReportJobDone, 100, "2022-10.csv", "")).
+				Return(nil)
+		},
+		taken: true,
+	}, {
+		name: "empty report",
+		setup: func(r *repomock.BalanceRepo, f *reportmock.ReportFile) {
+			r.On("StartReportJob", mock.Anything, mock.Anything).Return(job, nil)
+			r.On("GetReport", mock.Anything, mock.Anything).Return(nil, entity.ErrEmptyReport)
+			r.On("UpdateReportJob", mock.Anything, running).Return(nil).Maybe()
+			r.On("UpdateReportJob", mock.Anything,
+				saved(entity.ReportJobFailed, 0, "", entity.ErrEmptyReport.Error())).Return(nil)
+		},
+		taken: true,
+	}, {
+		name: "file error",
+		setup: func(r *repomock.BalanceRepo, f *reportmock.ReportFile) {
+			r.On("StartReportJob", mock.Anything, mock.Anything).Return(job, nil)
+			r.On("GetReport", mock.Anything, mock.Anything).Return(sums, nil)
+			r.On("UpdateReportJob", mock.Anything, running).Return(nil).Maybe()
+			f.On("Create", mock.Anything, "2022-10", mock.Anything).Return(entity.SavedReport{}, errors.New("aboba"))
+			r.On("UpdateReportJob", mock.Anything, saved(entity.ReportJobFailed, 0, "", "internal error")).
+				Return(nil)
+		},
+		taken:  true,
+		errors: 1,
+	}, {
+		name: "taken by another worker",
+		setup: func(r *repomock.BalanceRepo, f *reportmock.ReportFile) {
+			r.On("StartReportJob", mock.Anything, mock.Anything).Return(job, nil)
+			r.On("GetReport", mock.Anything, mock.Anything).Return(sums, nil).Once()
+			r.On("GetReport", mock.Anything, mock.Anything).Return(nil, context.Canceled).Run(func(args mock.Arguments) {
+				<-args.Get(0).(context.Context).Done()
+			})
+			r.On("UpdateReportJob", mock.Anything, running).Return(entity.ErrNoReportJob)
+		},
+		taken: true,
+	}, {
+		name: "stopped",
+		setup: func(r *repomock.BalanceRepo, f *reportmock.ReportFile) {
+			r.On("StartReportJob", mock.Anything, mock.Anything).Return(job, nil)
+			r.On("GetReport", mock.Anything, mock.Anything).Return(nil, context.Canceled)
+			r.On("UpdateReportJob", mock.Anything, saved(entity.ReportJobQueued, 0, "", "")).Return(nil)
+		},
+		taken:    true,
+		canceled: true,
+	}} {
+		r := repomock.NewBalanceRepo(t)
+		f := reportmock.NewReportFile(t)
+		f.On("Format").Return("csv")
+		tc.setup(r, f)
+		uc := New(r, reportmock.NewReportDir(t), ReportFormats(f))
+
+		jobCtx, cancel := context.WithCancel(ctx)
+		if tc.canceled {
+			cancel()
+		}
+		var errs []error
+		taken := uc.runReportJob(jobCtx, func(err error) {
+			errs = append(errs, err)
+		})
+		cancel()
+		assert.Equal(t, tc.taken, taken, tc.name)
+		assert.Len(t, errs, tc.errors, tc.name)
+	}
+}
+
+func TestHeartbeatReportJob(t *testing.T) {
+	job := entity.ReportJob{ID: 1, Params: testReportParams, Format: "csv", Status: entity.ReportJobRunning}
+	progress := job
+	progress.Progress = 40
+
+	r := repomock.NewBalanceRepo(t)
+	uc := New(r, reportmock.NewReportDir(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	r.On("UpdateReportJob", mock.Anything, progress).Return(nil).Run(func(mock.Arguments) {
+		cancel()
+	}).Once()
+	ch := make(chan int, 1)
+	setProgress(ch, 20)
+	setProgress(ch, 40)
+	assert.False(t, uc.heartbeatReportJob(ctx, job, ch, cancel, func(err error) {
+		t.Error(err)
+	}))
+
+	// job taken again by another worker is canceled
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	r.On("UpdateReportJob", mock.Anything, progress).Return(entity.ErrNoReportJob).Once()
+	setProgress(ch, 40)
+	assert.True(t, uc.heartbeatReportJob(ctx, job, ch, cancel, func(err error) {
+		t.Error(err)
+	}))
+	assert.Error(t, ctx.Err())
+}
+
+func TestReportChunks(t *testing.T) {
+	chunks := reportChunks(testReportParams, 10)
+	assert.Len(t, chunks, 10)
+	assert.Equal(t, testReportParams.From, chunks[0].From)
+	assert.Equal(t, time.Date(2022, 10, 3, 0, 0, 0, 0, time.UTC), chunks[0].To)
+	for i := 1; i < len(chunks); i++ {
+		assert.Equal(t, chunks[i-1].To.AddDate(0, 0, 1), chunks[i].From)
+	}
+	assert.Equal(t, testReportParams.To, chunks[9].To)
+
+	days := testReportParams
+	days.To = time.Date(2022, 10, 3, 0, 0, 0, 0, time.UTC)
+	chunks = reportChunks(days, 10)
+	assert.Len(t, chunks, 3)
+	for i, c := range chunks {
+		assert.Equal(t, c.From, c.To)
+		assert.Equal(t, time.Date(2022, 10, 1+i, 0, 0, 0, 0, time.UTC), c.From)
+	}
+}
+
+func TestReportJobs(t *testing.T) {
+	ctx := context.Background()
+	r := repomock.NewBalanceRepo(t)
+	f := reportmock.NewReportFile(t)
+	f.On("Format").Return("csv")
+	uc := New(r, reportmock.NewReportDir(t), ReportFormats(f))
+
+	job := entity.ReportJob{ID: 1, Params: testReportParams, Format: "csv", Status: entity.ReportJobQueued}
+	running := job
+	running.Status = entity.ReportJobRunning
+	var mu sync.Mutex
+	var idle, done bool
+	set := func(b *bool) func(args mock.Arguments) {
+		return func(args mock.Arguments) {
+			mu.Lock()
+			*b = true
+			mu.Unlock()
+		}
+	}
+	isSet := func(b *bool) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return *b
+		}
+	}
+
+	r.On("StartReportJob", mock.Anything, mock.Anything).Return(entity.ReportJob{}, entity.ErrNoReportJob).
+		Run(set(&idle)).Once()
+	r.On("CreateReportJob", ctx, entity.ReportJob{Params: testReportParams, Format: "csv"}).Return(job, nil)
+	r.On("StartReportJob", mock.Anything, mock.Anything).Return(running, nil).Once()
+	r.On("GetReport", mock.Anything, mock.Anything).Return([]entity.SumByService{{Sum: "1", OrderSum: "1"}}, nil)
+	r.On("UpdateReportJob", mock.Anything, mock.MatchedBy(func(j entity.ReportJob) bool {
+		return j.Status == entity.ReportJobRunning
+	})).Return(nil).Maybe()
+	f.On("Create", mock.Anything, "2022-10", mock.Anything).Return(entity.SavedReport{Name: "2022-10.csv"}, nil)
+	r.On("SaveReport", mock.Anything, entity.SavedReport{Name: "2022-10.csv"}).
+		Return(entity.SavedReport{Name: "2022-10.csv"}, nil)
+	r.On("UpdateReportJob", mock.Anything, mock.MatchedBy(func(j entity.ReportJob) bool {
+		return j.Status == entity.ReportJobDone
+	})).Run(set(&done)).Return(nil)
+	r.On("StartReportJob", mock.Anything, mock.Anything).Return(entity.ReportJob{}, entity.ErrNoReportJob)
+
+	uc.StartReportJobs(1, func(err error) {
+		t.Error(err)
+	})
+	// worker finds no jobs on start and is woken up by a new one
+	assert.Eventually(t, isSet(&idle), time.Second, time.Millisecond)
+	_, err := uc.CreateReportJob(ctx, testReportParams, "csv")
+	assert.NoError(t, err)
+	assert.Eventually(t, isSet(&done), time.Second, time.Millisecond)
+	uc.StopReportJobs()
+}
@@ -5,11 +5,17 @@ import (
 	"balance_api/pkg/postgres"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
-	"strings"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"time"
 )
 
+const uniqueViolation = "23505"
+
 // BalanceRepo keeps db connection pool
 type BalanceRepo struct {
 	*postgres.Db
@@ -20,209 +26,1047 @@ func New(db *postgres.Db) *BalanceRepo {
 	return &BalanceRepo{db}
 }
 
-// GetByID returns entity.Balance of a given id, entity.ErrNoID in case if there is no such one
+// GetByID returns entity.Balance of a given id with reserved money, pending orders count and time of last
+// operations, entity.ErrNoID in case if there is no such one
 func (r *BalanceRepo) GetByID(ctx context.Context, id int) (entity.Balance, error) {
 	var res entity.Balance
-	err := r.Pool.GetContext(ctx, &res, `SELECT user_id, amount FROM users WHERE user_id = $1`, id)
+	err := r.Pool.GetContext(ctx, &res,
+		`SELECT u.user_id, u.amount, u.reserved, u.amount + u.reserved AS total,
+						(SELECT count(*) FROM orders WHERE user_id = u.user_id AND status_id = 1) AS pending_orders,
+						(SELECT max(created) FROM replenishments WHERE user_id = u.user_id) AS last_replenishment,
+						(SELECT max(created) FROM orders WHERE user_id = u.user_id) AS last_order
+						FROM users AS u WHERE u.user_id = $1`, id)
 	if err != nil {
 		return entity.Balance{}, entity.ErrNoID
 	}
 	return res, nil
 }
 
-// CreateOrder creates new order and transfers money from user's balance to special account
-func (r *BalanceRepo) CreateOrder(ctx context.Context, order entity.Order) error {
-	tx, err := r.Pool.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
-	if err != nil {
-		return fmt.Errorf("BalanceRepository - CreateOrder: %w", err)
+// GetBalanceAt reconstructs entity.Balance of a given id at given moment from user's operations. Order is reserved
+// since its creation until its status was changed at modified time, then captured sum is charged if it was approved.
+// Returns entity.ErrNoID in case if there is no such user
+func (r *BalanceRepo) GetBalanceAt(ctx context.Context, id int, at time.Time) (entity.Balance, error) {
+	var res entity.Balance
+	err := r.Pool.GetContext(ctx, &res,
+		`WITH o AS (
+							SELECT order_sum, captured, status_id, created, status_id = 1 OR modified > $2 AS pending
+							FROM orders WHERE user_id = $1 AND created <= $2
+						), t AS (
+							SELECT COALESCE((SELECT sum(amount) FROM replenishments WHERE user_id = $1 AND created <= $2), 0.00)
+								+ COALESCE((SELECT sum(amount) FROM transfers WHERE to_user_id = $1 AND created <= $2), 0.00)
+								- COALESCE((SELECT sum(amount) FROM transfers WHERE from_user_id = $1 AND created <= $2), 0.00)
+								+ COALESCE((SELECT sum(amount) FROM refunds WHERE user_id = $1 AND created <= $2), 0.00)
+								- COALESCE((SELECT sum(CASE WHEN pending THEN order_sum
+									WHEN status_id IN (2, 4, 5) THEN captured END) FROM o), 0.00) AS amount,
+								COALESCE((SELECT sum(order_sum) FROM o WHERE pending), 0.00) AS reserved
+						)
+						SELECT u.user_id, t.amount, t.reserved, t.amount + t.reserved AS total,
+						(SELECT count(*) FROM o WHERE pending) AS pending_orders,
+						(SELECT max(created) FROM replenishments WHERE user_id = $1 AND created <= $2) AS last_replenishment,
+						(SELECT max(created) FROM o) AS last_order
+						FROM users AS u, t WHERE u.user_id = $1`, id, at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Balance{}, entity.ErrNoID
 	}
-	defer tx.Rollback()
-	_, err = tx.NamedExecContext(ctx,
-		`INSERT INTO orders (order_id, service_id, user_id, order_sum, status_id)
-						VALUES (:order_id, :service_id, :user_id, :order_sum, 1)`, order)
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - CreateOrder: %w", err)
+		return entity.Balance{}, fmt.Errorf("BalanceRepository - GetBalanceAt: %w", err)
 	}
-	_, err = tx.NamedExecContext(ctx,
-		`UPDATE users SET amount = amount - :order_sum, reserved = reserved + :order_sum 
-             WHERE user_id = :user_id`, order)
+	return res, nil
+}
+
+// CreateOrder creates new order and moves money from user's available to reserved account. All checks are made
+// in the same transaction: returns entity.ErrNoID if there is no such user, entity.ErrNotEnoughMoney if user doesn't
+// have enough money, entity.ErrNoService if service id is wrong or service is deactivated, entity.ErrOrderExists if order exists
+func (r *BalanceRepo) CreateOrder(ctx context.Context, order entity.Order) error {
+	sum, err := decimal.NewFromString(order.Sum)
 	if err != nil {
 		return fmt.Errorf("BalanceRepository - CreateOrder: %w", err)
 	}
-	return tx.Commit()
+	return r.runInTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted}, func(tx *sqlx.Tx) error {
+		// conditional update in read committed waits for concurrent orders of the same user and rechecks balance
+		err := postEntries(ctx, tx, opOrder, order.ID, ledgerEntry{
+			debit:  reservedAccount(order.UserID),
+			credit: availableAccount(order.UserID),
+			amount: sum,
+		})
+		if errors.Is(err, errNegativeBalance) {
+			var exists bool
+			err = tx.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM users WHERE user_id = $1)`, order.UserID)
+			switch {
+			case err != nil:
+				return fmt.Errorf("BalanceRepository - CreateOrder: %w", err)
+			case !exists:
+				return entity.ErrNoID
+			}
+			return entity.ErrNotEnoughMoney
+		}
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - CreateOrder: %w", err)
+		}
+		var exists bool
+		err = tx.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM services WHERE service_id = $1 AND active)`, order.ServiceID)
+		switch {
+		case err != nil:
+			return fmt.Errorf("BalanceRepository - CreateOrder: %w", err)
+		case !exists:
+			return entity.ErrNoService
+		}
+		res, err := tx.NamedExecContext(ctx,
+			`INSERT INTO orders (order_id, service_id, user_id, order_sum, status_id, expires, comment, metadata)
+							VALUES (:order_id, :service_id, :user_id, :order_sum, 1,
+							        CASE WHEN :ttl > 0 THEN now() + make_interval(secs => :ttl) END,
+							        :comment, :metadata)
+							ON CONFLICT (order_id) DO NOTHING`, order)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - CreateOrder: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - CreateOrder: %w", err)
+		}
+		if n == 0 {
+			return entity.ErrOrderExists
+		}
+		err = addOrderEvent(ctx, tx, entity.OrderEvent{OrderID: order.ID, ToStatusID: entity.StatusPending,
+			Amount: order.Sum, Actor: order.Actor, Reason: order.Reason})
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - CreateOrder: %w", err)
+		}
+		return nil
+	})
 }
 
 // GetOrderByID returns order with given id, entity.ErrOrderNoExists if there is no one
 func (r *BalanceRepo) GetOrderByID(ctx context.Context, id int) (entity.Order, error) {
 	var res entity.Order
-	err := r.Pool.GetContext(ctx, &res,
-		`SELECT order_id, service_id, user_id, status_id, order_sum FROM orders WHERE order_id = $1`, id)
-	if err != nil {
+	err := r.Pool.GetContext(ctx, &res, orderSelect+` WHERE o.order_id = $1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
 		return entity.Order{}, entity.ErrOrderNoExists
 	}
+	if err != nil {
+		return entity.Order{}, fmt.Errorf("BalanceRepository - GetOrderByID: %w", err)
+	}
+	return res, nil
+}
+
+// GetOrderEvents returns status changes of order from old to new
+func (r *BalanceRepo) GetOrderEvents(ctx context.Context, orderID int) ([]entity.OrderEvent, error) {
+	var res []entity.OrderEvent
+	err := r.Pool.SelectContext(ctx, &res,
+		`SELECT e.order_id, COALESCE(e.from_status_id, 0) AS from_status_id,
+						COALESCE(fs.status_name, '') AS from_status, e.to_status_id, ts.status_name AS to_status,
+						e.amount, e.actor, e.reason, e.created
+						FROM order_events AS e
+						LEFT JOIN status AS fs ON e.from_status_id = fs.status_id
+						JOIN status AS ts ON e.to_status_id = ts.status_id
+						WHERE e.order_id = $1
+						ORDER BY e.created, e.id`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("BalanceRepository - GetOrderEvents: %w", err)
+	}
 	return res, nil
 }
 
-// CheckServiceID returns nil if service exists in db, entity.ErrNoService otherwise
+// addOrderEvent saves status change of order, it has to be called in the transaction which changes the status
+func addOrderEvent(ctx context.Context, tx *sqlx.Tx, event entity.OrderEvent) error {
+	var from *int
+	if event.FromStatusID != 0 {
+		from = &event.FromStatusID
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO order_events (order_id, from_status_id, to_status_id, amount, actor, reason)
+						VALUES ($1, $2, $3, $4, $5, $6)`,
+		event.OrderID, from, event.ToStatusID, event.Amount, event.Actor, event.Reason)
+	return err
+}
+
+const orderSelect = `SELECT o.order_id, o.service_id, serv.service_name, o.user_id, o.status_id, st.status_name,
+						o.order_sum, COALESCE(o.captured::text, '') AS captured, o.refunded, o.created, o.modified, o.expires,
+						o.comment, o.metadata
+						FROM orders AS o
+						JOIN services AS serv ON o.service_id = serv.service_id
+						JOIN status AS st ON o.status_id = st.status_id`
+
+// GetOrders returns orders matching filter from new to old
+func (r *BalanceRepo) GetOrders(ctx context.Context, filter entity.OrderFilter) ([]entity.Order, error) {
+	q := newConditions()
+	if filter.UserID != 0 {
+		q.where("o.user_id = ?", filter.UserID)
+	}
+	if filter.ServiceID != 0 {
+		q.where("o.service_id = ?", filter.ServiceID)
+	}
+	if filter.StatusID != 0 {
+		q.where("o.status_id = ?", filter.StatusID)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		q.where("o.created >= ?", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		q.where("o.created < ?", filter.CreatedTo)
+	}
+	if !filter.ModifiedFrom.IsZero() {
+		q.where("o.modified >= ?", filter.ModifiedFrom)
+	}
+	if !filter.ModifiedTo.IsZero() {
+		q.where("o.modified < ?", filter.ModifiedTo)
+	}
+	if filter.After != nil {
+		q.where("(o.created, o.order_id) < (?, ?)", filter.After.Created, filter.After.ID)
+	}
+
+	query := orderSelect + "\n" + q.String() + "ORDER BY o.created DESC, o.order_id DESC\nLIMIT " + q.arg(filter.Limit)
+
+	var res []entity.Order
+	err := r.Pool.SelectContext(ctx, &res, query, q.args...)
+	if err != nil {
+		return nil, fmt.Errorf("BalanceRepository - GetOrders: %w", err)
+	}
+	return res, nil
+}
+
+// GetExpiredOrders returns pending orders which expiration time has passed
+func (r *BalanceRepo) GetExpiredOrders(ctx context.Context, limit int) ([]entity.Order, error) {
+	var res []entity.Order
+	err := r.Pool.SelectContext(ctx, &res,
+		`SELECT order_id, service_id, user_id, status_id, order_sum FROM orders
+						WHERE status_id = 1 AND expires <= now()
+						ORDER BY expires
+						LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BalanceRepository - GetExpiredOrders: %w", err)
+	}
+	return res, nil
+}
+
+// CheckServiceID returns nil if service exists in db and is active, entity.ErrNoService otherwise
 func (r *BalanceRepo) CheckServiceID(ctx context.Context, id int) error {
 	var service struct {
 		ID int `db:"service_id"`
 	}
 	err := r.Pool.GetContext(ctx, &service,
-		`SELECT service_id FROM services WHERE service_id = $1`, id)
+		`SELECT service_id FROM services WHERE service_id = $1 AND active`, id)
 	if err != nil {
 		return entity.ErrNoService
 	}
 	return nil
 }
 
-// CommitOrder updates order and reduce amount of user's reserved money
+// ledgerOrder is a part of order needed for ledger entries
+type ledgerOrder struct {
+	UserID    int             `db:"user_id"`
+	ServiceID int             `db:"service_id"`
+	Sum       decimal.Decimal `db:"order_sum"`
+	Captured  decimal.Decimal `db:"captured"`
+}
+
+// CommitOrder updates order and moves captured sum from user's reserved account to revenue of the service, not
+// captured part of order is returned to user's available account. Returns entity.ErrCantChangeStatus if order
+// isn't pending
 func (r *BalanceRepo) CommitOrder(ctx context.Context, order entity.Order) error {
-	tx, err := r.Pool.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	return r.runInTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(tx *sqlx.Tx) error {
+		var o ledgerOrder
+		err := tx.GetContext(ctx, &o,
+			`UPDATE orders SET status_id = $2, captured = $3, modified = now()
+             WHERE order_id = $1 AND status_id = 1
+             RETURNING user_id, service_id, order_sum, captured`, order.ID, order.StatusID, order.Captured)
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.ErrCantChangeStatus
+		}
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - CommitOrder: %w", err)
+		}
+		err = postEntries(ctx, tx, opApprove, order.ID, ledgerEntry{
+			debit:  revenueAccount(o.ServiceID),
+			credit: reservedAccount(o.UserID),
+			amount: o.Captured,
+		}, ledgerEntry{
+			debit:  availableAccount(o.UserID),
+			credit: reservedAccount(o.UserID),
+			amount: o.Sum.Sub(o.Captured),
+		})
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - CommitOrder: %w", err)
+		}
+		err = addOrderEvent(ctx, tx, entity.OrderEvent{OrderID: order.ID, FromStatusID: entity.StatusPending,
+			ToStatusID: order.StatusID, Amount: o.Captured.StringFixed(2), Actor: order.Actor, Reason: order.Reason})
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - CommitOrder: %w", err)
+		}
+		return nil
+	})
+}
+
+// RollbackOrder updates order and moves money back from user's reserved to available account, order.StatusID is
+// either canceled or expired. Returns entity.ErrCantChangeStatus if order isn't pending
+func (r *BalanceRepo) RollbackOrder(ctx context.Context, order entity.Order) error {
+	operation := opCancel
+	if order.StatusID == entity.StatusExpired {
+		operation = opExpire
+	}
+	return r.runInTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(tx *sqlx.Tx) error {
+		var o ledgerOrder
+		err := tx.GetContext(ctx, &o,
+			`UPDATE orders SET status_id = $2, modified = now() WHERE order_id = $1 AND status_id = 1
+             RETURNING user_id, service_id, order_sum`, order.ID, order.StatusID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.ErrCantChangeStatus
+		}
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - RollbackOrder: %w", err)
+		}
+		err = postEntries(ctx, tx, operation, order.ID, ledgerEntry{
+			debit:  availableAccount(o.UserID),
+			credit: reservedAccount(o.UserID),
+			amount: o.Sum,
+		})
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - RollbackOrder: %w", err)
+		}
+		err = addOrderEvent(ctx, tx, entity.OrderEvent{OrderID: order.ID, FromStatusID: entity.StatusPending,
+			ToStatusID: order.StatusID, Amount: o.Sum.StringFixed(2), Actor: order.Actor, Reason: order.Reason})
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - RollbackOrder: %w", err)
+		}
+		return nil
+	})
+}
+
+// RefundOrder moves money of approved order from revenue of the service back to user's available account and saves
+// the refund, order's modified time isn't changed, so order stays in the report of its approval month. Returns
+// entity.ErrRefundExceeds if order isn't approved anymore or refund is more than not refunded rest of captured sum
+func (r *BalanceRepo) RefundOrder(ctx context.Context, refund entity.Refund) error {
+	amount, err := decimal.NewFromString(refund.Amount)
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - CommitOrder: %w", err)
+		return fmt.Errorf("BalanceRepository - RefundOrder: %w", err)
 	}
-	defer tx.Rollback()
-	_, err = tx.NamedExecContext(ctx,
-		`UPDATE orders SET status_id = :status_id, modified = now() WHERE order_id = :order_id`, order)
+	return r.runInTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(tx *sqlx.Tx) error {
+		var o struct {
+			ServiceID int  `db:"service_id"`
+			StatusID  int  `db:"status_id"`
+			First     bool `db:"first"`
+		}
+		// the first refund is made from approved status, the next ones from partially refunded
+		err := tx.GetContext(ctx, &o,
+			`UPDATE orders SET refunded = refunded + $2,
+                  status_id = CASE WHEN refunded + $2 = captured THEN 4 ELSE 5 END
+             WHERE order_id = $1 AND status_id IN (2, 5) AND refunded + $2 <= captured
+             RETURNING service_id, status_id, refunded = $2 AS first`, refund.OrderID, amount)
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.ErrRefundExceeds
+		}
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - RefundOrder: %w", err)
+		}
+		var id int
+		err = tx.GetContext(ctx, &id,
+			`INSERT INTO refunds (order_id, user_id, amount) VALUES ($1, $2, $3) RETURNING id`,
+			refund.OrderID, refund.UserID, amount)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - RefundOrder: %w", err)
+		}
+		err = postEntries(ctx, tx, opRefund, id, ledgerEntry{
+			debit:  availableAccount(refund.UserID),
+			credit: revenueAccount(o.ServiceID),
+			amount: amount,
+		})
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - RefundOrder: %w", err)
+		}
+		from := entity.StatusPartiallyRefunded
+		if o.First {
+			from = entity.StatusApproved
+		}
+		err = addOrderEvent(ctx, tx, entity.OrderEvent{OrderID: refund.OrderID, FromStatusID: from,
+			ToStatusID: o.StatusID, Amount: amount.StringFixed(2), Actor: refund.Actor, Reason: refund.Reason})
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - RefundOrder: %w", err)
+		}
+		return nil
+	})
+}
+
+// CreateUser creates new user with initial replenishment
+func (r *BalanceRepo) CreateUser(ctx context.Context, balance entity.Balance) error {
+	return r.runInTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO users (user_id, amount) VALUES ($1, 0)`, balance.ID)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - CreateUser: %w", err)
+		}
+		err = replenish(ctx, tx, balance)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - CreateUser: %w", err)
+		}
+		return nil
+	})
+}
+
+// Increase puts replenishment to user's available account
+func (r *BalanceRepo) Increase(ctx context.Context, balance entity.Balance) error {
+	return r.runInTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(tx *sqlx.Tx) error {
+		err := replenish(ctx, tx, balance)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - Increase: %w", err)
+		}
+		return nil
+	})
+}
+
+func replenish(ctx context.Context, tx *sqlx.Tx, balance entity.Balance) error {
+	amount, err := decimal.NewFromString(balance.Amount)
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - CommitOrder: %w", err)
+		return err
 	}
-	_, err = tx.NamedExecContext(ctx,
-		`UPDATE users SET reserved = reserved - :order_sum WHERE user_id = :user_id`, order)
+	var id int
+	err = tx.GetContext(ctx, &id,
+		`INSERT INTO replenishments (user_id, amount, comment, metadata) VALUES ($1, $2, $3, $4) RETURNING id`,
+		balance.ID, amount, balance.Comment, balance.Metadata)
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - CommitOrder: %w", err)
+		return err
 	}
-	return tx.Commit()
+	return postEntries(ctx, tx, opReplenishment, id, ledgerEntry{
+		debit:  availableAccount(balance.ID),
+		credit: fundingAccount,
+		amount: amount,
+	})
 }
 
-// RollbackOrder updates order and transfers money back from reserved to main account
-func (r *BalanceRepo) RollbackOrder(ctx context.Context, order entity.Order) error {
-	tx, err := r.Pool.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+// Transfer moves money between users' available accounts and saves the transfer, returns entity.ErrNoID if there is
+// no sender or receiver, entity.ErrNotEnoughMoney if sender doesn't have enough money
+func (r *BalanceRepo) Transfer(ctx context.Context, transfer entity.Transfer) error {
+	amount, err := decimal.NewFromString(transfer.Amount)
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - RollbackOrder: %w", err)
+		return fmt.Errorf("BalanceRepository - Transfer: %w", err)
 	}
-	defer tx.Rollback()
-	_, err = tx.NamedExecContext(ctx,
-		`UPDATE orders SET status_id = :status_id, modified = now() WHERE order_id = :order_id`, order)
+	return r.runInTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(tx *sqlx.Tx) error {
+		var users []entity.Balance
+		err := tx.SelectContext(ctx, &users,
+			`SELECT user_id, amount FROM users WHERE user_id IN ($1, $2) ORDER BY user_id FOR UPDATE`,
+			transfer.FromID, transfer.ToID)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - Transfer: %w", err)
+		}
+		if len(users) != 2 {
+			return entity.ErrNoID
+		}
+		var id int
+		err = tx.GetContext(ctx, &id,
+			`INSERT INTO transfers (from_user_id, to_user_id, amount, comment)
+							VALUES ($1, $2, $3, $4) RETURNING id`, transfer.FromID, transfer.ToID, amount, transfer.Comment)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - Transfer: %w", err)
+		}
+		err = postEntries(ctx, tx, opTransfer, id, ledgerEntry{
+			debit:  availableAccount(transfer.ToID),
+			credit: availableAccount(transfer.FromID),
+			amount: amount,
+		})
+		switch {
+		case errors.Is(err, errNegativeBalance):
+			return entity.ErrNotEnoughMoney
+		case err != nil:
+			return fmt.Errorf("BalanceRepository - Transfer: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetHistory returns page of user's transaction history, the page is empty if there are no more operations.
+// Limit of zero returns the whole history
+func (r *BalanceRepo) GetHistory(ctx context.Context, history entity.History) (entity.History, error) {
+	OrdersSet := make([]entity.Order, 0, history.Limit+1)
+	query, args := queryConstructor(history)
+	err := r.Pool.SelectContext(ctx, &OrdersSet, query, args...)
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - RollbackOrder: %w", err)
+		return entity.History{}, fmt.Errorf("BalanceRepository - GetHistory: %w", err)
+	}
+	history.Next = nil
+	if history.Limit != 0 && len(OrdersSet) > history.Limit {
+		OrdersSet = OrdersSet[:history.Limit]
+		last := OrdersSet[len(OrdersSet)-1]
+		history.Next = &entity.HistoryCursor{OrderBy: history.OrderBy, Desc: history.Desc,
+			Created: last.Time.Time, Sum: last.Sum, Kind: last.Kind, ID: last.ID}
 	}
-	_, err = tx.NamedExecContext(ctx,
-		`UPDATE users SET reserved = reserved - :order_sum, amount = amount + :order_sum 
-             WHERE user_id = :user_id`, order)
+	history.Orders = OrdersSet
+	return history, nil
+}
+
+// ExportHistory passes every user's operation matching history filters to fn one by one in history order,
+// operations aren't loaded into memory at once. Limit, page and cursor of history are not used
+func (r *BalanceRepo) ExportHistory(ctx context.Context, history entity.History,
+	fn func(order entity.Order) error) error {
+	history.Limit, history.Page, history.After = 0, 0, nil
+	query, args := queryConstructor(history)
+	rows, err := r.Pool.QueryxContext(ctx, query, args...)
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - RollbackOrder: %w", err)
+		return fmt.Errorf("BalanceRepository - ExportHistory: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var order entity.Order
+		err = rows.StructScan(&order)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - ExportHistory: %w", err)
+		}
+		err = fn(order)
+		if err != nil {
+			return err
+		}
 	}
-	return tx.Commit()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("BalanceRepository - ExportHistory: %w", err)
+	}
+	return nil
 }
 
-// CreateUser creates new user and put order with initial replenishment
-func (r *BalanceRepo) CreateUser(ctx context.Context, balance entity.Balance) error {
-	tx, err := r.Pool.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+// historySelect lists all operations of user $1, service_id is zero for replenishments and transfers. Balance after
+// operation is a running sum of user's ledger entries up to the entry of this operation, so it doesn't depend on
+// sort and filters. Order is shown with its current status, but balance after it is the one after its creation
+const historySelect = `WITH e AS (
+						SELECT id, operation, ref_id,
+						       sum(CASE WHEN debit = 'user:' || $1::int || ':available' THEN amount
+						                WHEN credit = 'user:' || $1::int || ':available' THEN -amount
+						                ELSE 0.00 END) OVER (ORDER BY id) AS available,
+						       sum(CASE WHEN debit = 'user:' || $1::int || ':reserved' THEN amount
+						                WHEN credit = 'user:' || $1::int || ':reserved' THEN -amount
+						                ELSE 0.00 END) OVER (ORDER BY id) AS reserved
+						FROM ledger_entries
+						WHERE debit IN ('user:' || $1::int || ':available', 'user:' || $1::int || ':reserved')
+						   OR credit IN ('user:' || $1::int || ':available', 'user:' || $1::int || ':reserved')
+						), b AS (
+						SELECT DISTINCT ON (operation, ref_id) operation, ref_id, available, reserved
+						FROM e
+						ORDER BY operation, ref_id, id DESC
+						)
+						SELECT * FROM (
+						SELECT u.order_id, u.kind, u.service_id, u.service_name, u.order_sum, u.captured, u.status_id,
+						       u.status_name, u.created, u.comment, u.metadata,
+						       COALESCE(b.available::text, '') AS "balance_after.available",
+						       COALESCE(b.reserved::text, '') AS "balance_after.reserved"
+						FROM (
+						SELECT o.order_id, 1 AS kind, o.service_id, serv.service_name, o.order_sum,
+						       COALESCE(o.captured::text, '') AS captured, o.status_id, st.status_name, o.created,
+						       o.comment, o.metadata, 'order' AS operation
+						FROM orders AS o
+						JOIN services AS serv ON o.service_id = serv.service_id
+						JOIN status AS st ON o.status_id = st.status_id
+						WHERE o.user_id = $1
+						UNION ALL
+						SELECT id, 2, 0, 'Replenishment', amount, '', 2, 'Approved', created, comment, metadata,
+						       'replenishment'
+						FROM replenishments
+						WHERE user_id = $1
+						UNION ALL
+						SELECT id, 3, 0, 'Transfer to user ' || to_user_id, amount, '', 2, 'Approved', created,
+						       comment, '{}'::jsonb, 'transfer'
+						FROM transfers
+						WHERE from_user_id = $1
+						UNION ALL
+						SELECT id, 4, 0, 'Transfer from user ' || from_user_id, amount, '', 2, 'Approved', created,
+						       comment, '{}'::jsonb, 'transfer'
+						FROM transfers
+						WHERE to_user_id = $1
+						UNION ALL
+						SELECT r.id, 5, o.service_id, 'Refund: ' || serv.service_name, r.amount, '', 2, 'Approved',
+						       r.created, '', '{}'::jsonb, 'refund'
+						FROM refunds AS r
+						JOIN orders AS o ON r.order_id = o.order_id
+						JOIN services AS serv ON o.service_id = serv.service_id
+						WHERE r.user_id = $1
+						) AS u
+						LEFT JOIN b ON b.operation = u.operation AND b.ref_id = u.order_id
+						) AS h
+`
+
+// queryConstructor makes query of history page, all values are passed as arguments. Only sort column and
+// direction are put into query text, they are chosen from fixed values
+func queryConstructor(history entity.History) (string, []interface{}) {
+	q := newConditions(history.UserID)
+	if !history.From.IsZero() {
+		q.where("created >= ?", history.From)
+	}
+	if !history.To.IsZero() {
+		q.where("created < ?", history.To)
+	}
+	if len(history.Kinds) > 0 {
+		q.where("kind = ANY(?)", history.Kinds)
+	}
+	if len(history.ServiceIDs) > 0 {
+		q.where("service_id = ANY(?)", history.ServiceIDs)
+	}
+	if len(history.StatusIDs) > 0 {
+		q.where("status_id = ANY(?)", history.StatusIDs)
+	}
+	if history.MinSum != "" {
+		q.where("order_sum >= ?", history.MinSum)
+	}
+	if history.MaxSum != "" {
+		q.where("order_sum <= ?", history.MaxSum)
+	}
+	switch {
+	case history.MetadataKey != "" && history.MetadataValue != "":
+		q.where("metadata ->> ? = ?", history.MetadataKey, history.MetadataValue)
+	case history.MetadataKey != "":
+		// ? is also a jsonb operator, so placeholder is put by hand
+		q.where("metadata ? " + q.arg(history.MetadataKey))
+	}
+
+	column, cmp, dir := "created", ">", ""
+	if history.OrderBy == "sum" {
+		column = "order_sum"
+	}
+	if history.Desc {
+		cmp, dir = "<", " DESC"
+	}
+	if c := history.After; c != nil {
+		var value interface{} = c.Created
+		if history.OrderBy == "sum" {
+			value = c.Sum
+		}
+		q.where("("+column+", kind, order_id) "+cmp+" (?, ?, ?)", value, c.Kind, c.ID)
+	}
+
+	// LIMIT NULL means no limit, one more operation shows if there is the next page
+	var limit, offset interface{}
+	if history.Limit != 0 {
+		limit = history.Limit + 1
+	}
+	if history.After == nil && history.Page > 1 {
+		offset = history.Limit * (history.Page - 1)
+	}
+	query := historySelect + q.String() +
+		fmt.Sprintf("ORDER BY %[1]s%[2]s, kind%[2]s, order_id%[2]s\n", column, dir) +
+		"LIMIT " + q.arg(limit) + " OFFSET " + q.arg(offset)
+	return query, q.args
+}
+
+// GetReport returns sums of report's rows by breakdown periods ordered by service, user and period,
+// entity.ErrEmptyReport if there were no operations in report's days. Revenue is a captured part of orders,
+// refunds are subtracted from revenue of the period they were made in. Days and periods are taken in report's
+// location, weeks start on Monday
+func (r *BalanceRepo) GetReport(ctx context.Context, params entity.ReportParams) ([]entity.SumByService, error) {
+	var sums []entity.SumByService
+	err := r.Pool.SelectContext(ctx, &sums,
+		`SELECT sum(t.amount) AS sums, sum(t.order_sum) AS order_sums,
+			CASE WHEN $5::boolean THEN s.service_name ELSE '' END AS service_name,
+			CASE WHEN $6::boolean THEN t.user_id ELSE 0 END AS user_id,
+			COALESCE(to_char(date_trunc(NULLIF($4::text, ''), t.created AT TIME ZONE $3::text), 'YYYY-MM-DD'), '')
+				AS period
+		FROM (
+			SELECT o.service_id, o.user_id, o.captured AS amount, o.order_sum, o.modified AS created
+			FROM orders AS o
+			WHERE o.status_id IN (2, 4, 5) AND o.modified >= $1 AND o.modified < $2
+			UNION ALL
+			SELECT o.service_id, o.user_id, -r.amount AS amount, 0 AS order_sum, r.created FROM refunds AS r
+			JOIN orders AS o ON o.order_id = r.order_id
+			WHERE r.created >= $1 AND r.created < $2
+		) AS t
+		JOIN services AS s ON s.service_id = t.service_id
+		GROUP BY 3, 4, 5
+		ORDER BY 3, 4, 5`,
+		params.From, params.To.AddDate(0, 0, 1), params.Location.String(), params.Breakdown,
+		params.ByService, params.ByUser)
+	if err != nil {
+		return nil, fmt.Errorf("BalanceRepository - GetReport: %w", err)
+	}
+	if len(sums) == 0 {
+		return nil, entity.ErrEmptyReport
+	}
+	return sums, nil
+}
+
+// reportJob is a row of report_jobs, its params are converted to entity.ReportParams
+type reportJob struct {
+	ID        int        `db:"job_id"`
+	From      time.Time  `db:"date_from"`
+	To        time.Time  `db:"date_to"`
+	TimeZone  string     `db:"time_zone"`
+	Breakdown string     `db:"breakdown"`
+	ByService bool       `db:"by_service"`
+	ByUser    bool       `db:"by_user"`
+	Format    string     `db:"format"`
+	Status    string     `db:"status"`
+	Progress  int        `db:"progress"`
+	Name      string     `db:"file_name"`
+	Error     string     `db:"error"`
+	Created   time.Time  `db:"created"`
+	Started   *time.Time `db:"started"`
+	Finished  *time.Time `db:"finished"`
+}
+
+func (j reportJob) entity() (entity.ReportJob, error) {
+	loc, err := time.LoadLocation(j.TimeZone)
+	if err != nil {
+		return entity.ReportJob{}, err
+	}
+	return entity.ReportJob{
+		ID: j.ID,
+		Params: entity.ReportParams{
+			From:      time.Date(j.From.Year(), j.From.Month(), j.From.Day(), 0, 0, 0, 0, loc),
+			To:        time.Date(j.To.Year(), j.To.Month(), j.To.Day(), 0, 0, 0, 0, loc),
+			Location:  loc,
+			Breakdown: j.Breakdown,
+			ByService: j.ByService,
+			ByUser:    j.ByUser,
+		},
+		Format:   j.Format,
+		Status:   j.Status,
+		Progress: j.Progress,
+		Name:     j.Name,
+		Error:    j.Error,
+		Created:  j.Created,
+		Started:  j.Started,
+		Finished: j.Finished,
+	}, nil
+}
+
+const reportJobColumns = `job_id, date_from, date_to, time_zone, breakdown, by_service, by_user, format, status,
+	progress, file_name, error, created, started, finished`
+
+// CreateReportJob saves new queued report job, returns it with id and creation time
+func (r *BalanceRepo) CreateReportJob(ctx context.Context, job entity.ReportJob) (entity.ReportJob, error) {
+	var row reportJob
+	err := r.Pool.GetContext(ctx, &row,
+		`INSERT INTO report_jobs (date_from, date_to, time_zone, breakdown, by_service, by_user, format)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+reportJobColumns,
+		job.Params.From.Format("2006-01-02"), job.Params.To.Format("2006-01-02"), job.Params.Location.String(),
+		job.Params.Breakdown, job.Params.ByService, job.Params.ByUser, job.Format)
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - CreateUser: %w", err)
+		return entity.ReportJob{}, fmt.Errorf("BalanceRepository - CreateReportJob: %w", err)
 	}
-	defer tx.Rollback()
-	_, err = tx.NamedExecContext(ctx,
-		`INSERT INTO users (user_id, amount) VALUES (:user_id, :amount)`, balance)
+	job, err = row.entity()
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - CreateUser: %w", err)
+		return entity.ReportJob{}, fmt.Errorf("BalanceRepository - CreateReportJob: %w", err)
+	}
+	return job, nil
+}
+
+// GetReportJob returns report job by id, entity.ErrNoReportJob if there is no such one
+func (r *BalanceRepo) GetReportJob(ctx context.Context, id int) (entity.ReportJob, error) {
+	var row reportJob
+	err := r.Pool.GetContext(ctx, &row, `SELECT `+reportJobColumns+` FROM report_jobs WHERE job_id = $1`, id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return entity.ReportJob{}, entity.ErrNoReportJob
+	case err != nil:
+		return entity.ReportJob{}, fmt.Errorf("BalanceRepository - GetReportJob: %w", err)
 	}
-	_, err = tx.NamedExecContext(ctx,
-		`INSERT INTO replenishments (user_id, amount) 
-						VALUES (:user_id, :amount)`, balance)
+	job, err := row.entity()
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - CreateUser: %w", err)
+		return entity.ReportJob{}, fmt.Errorf("BalanceRepository - GetReportJob: %w", err)
 	}
-	return tx.Commit()
+	return job, nil
 }
 
-// Increase updates user's account and  put order with this replenishment
-func (r *BalanceRepo) Increase(ctx context.Context, balance entity.Balance) error {
-	tx, err := r.Pool.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+// StartReportJob takes the oldest queued job, or running one which heartbeat was before stale, and marks it as
+// running. Jobs taken by other workers are skipped, entity.ErrNoReportJob is returned if there is nothing to take
+func (r *BalanceRepo) StartReportJob(ctx context.Context, stale time.Time) (entity.ReportJob, error) {
+	var row reportJob
+	err := r.Pool.GetContext(ctx, &row,
+		`UPDATE report_jobs SET status = 'running', progress = 0, started = now(), heartbeat = now()
+		WHERE job_id = (
+			SELECT job_id FROM report_jobs
+			WHERE status = 'queued' OR (status = 'running' AND COALESCE(heartbeat, started) < $1)
+			ORDER BY job_id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+reportJobColumns, stale)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return entity.ReportJob{}, entity.ErrNoReportJob
+	case err != nil:
+		return entity.ReportJob{}, fmt.Errorf("BalanceRepository - StartReportJob: %w", err)
+	}
+	job, err := row.entity()
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - Increase: %w", err)
+		return entity.ReportJob{}, fmt.Errorf("BalanceRepository - StartReportJob: %w", err)
 	}
-	defer tx.Rollback()
-	_, err = tx.NamedExecContext(ctx,
-		`UPDATE users SET amount = amount + :amount WHERE user_id = :user_id`, balance)
+	return job, nil
+}
+
+// UpdateReportJob saves status, progress, file name and error of running report job and its heartbeat, done and
+// failed jobs get their finish time. Job is identified by its id and start, entity.ErrNoReportJob is returned if
+// it was taken again by another worker
+func (r *BalanceRepo) UpdateReportJob(ctx context.Context, job entity.ReportJob) error {
+	res, err := r.Pool.ExecContext(ctx,
+		`UPDATE report_jobs SET status = $3, progress = $4, file_name = $5, error = $6, heartbeat = now(),
+			finished = CASE WHEN $3 IN ('done', 'failed') THEN now() END
+		WHERE job_id = $1 AND started = $2 AND status = 'running'`,
+		job.ID, job.Started, job.Status, job.Progress, job.Name, job.Error)
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - Increase: %w", err)
+		return fmt.Errorf("BalanceRepository - UpdateReportJob: %w", err)
 	}
-	_, err = tx.NamedExecContext(ctx,
-		`INSERT INTO replenishments (user_id, amount) 
-						VALUES (:user_id, :amount)`, balance)
+	n, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("BalanceRepository - Increase: %w", err)
+		return fmt.Errorf("BalanceRepository - UpdateReportJob: %w", err)
+	}
+	if n == 0 {
+		return entity.ErrNoReportJob
 	}
-	return tx.Commit()
+	return nil
 }
 
-// GetHistory return's user's transaction history, entity.ErrEmptyPage if page and limit are wrong
-func (r *BalanceRepo) GetHistory(ctx context.Context, history entity.History) (entity.History, error) {
-	var OrdersSet []entity.Order
-	query := queryConstructor(history)
-	err := r.Pool.SelectContext(ctx, &OrdersSet, query, history.UserID)
+const savedReportColumns = `file_name, period, time_zone, format, size, checksum, created`
+
+// SaveReport saves report file kept in storage, row of regenerated file is replaced, returns it with creation time
+func (r *BalanceRepo) SaveReport(ctx context.Context, report entity.SavedReport) (entity.SavedReport, error) {
+	err := r.Pool.GetContext(ctx, &report,
+		`INSERT INTO report_files (file_name, period, time_zone, format, size, checksum)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (file_name) DO UPDATE SET period = excluded.period, time_zone = excluded.time_zone,
+			format = excluded.format, size = excluded.size, checksum = excluded.checksum, created = now()
+		RETURNING `+savedReportColumns,
+		report.Name, report.Period, report.TimeZone, report.Format, report.Size, report.Checksum)
 	if err != nil {
-		return entity.History{}, fmt.Errorf("BalanceRepository - GetHistory: %w", err)
+		return entity.SavedReport{}, fmt.Errorf("BalanceRepository - SaveReport: %w", err)
 	}
-	if len(OrdersSet) == 0 {
-		return entity.History{}, entity.ErrEmptyPage
+	return report, nil
+}
+
+// GetSavedReport returns report file by name, entity.ErrNoReport if there is no such one
+func (r *BalanceRepo) GetSavedReport(ctx context.Context, name string) (entity.SavedReport, error) {
+	var report entity.SavedReport
+	err := r.Pool.GetContext(ctx, &report, `SELECT `+savedReportColumns+` FROM report_files WHERE file_name = $1`,
+		name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return entity.SavedReport{}, entity.ErrNoReport
+	case err != nil:
+		return entity.SavedReport{}, fmt.Errorf("BalanceRepository - GetSavedReport: %w", err)
 	}
-	history.Orders = OrdersSet
-	return history, nil
+	return report, nil
 }
 
-func queryConstructor(history entity.History) string {
-	var str strings.Builder
-	str.WriteString(`SELECT serv.service_name, o.order_sum, st.status_name, o.created 
-											FROM orders AS o
-											JOIN services AS serv ON o.service_id = serv.service_id
-											JOIN status AS st ON o.status_id = st.status_id
-											WHERE o.user_id = $1
-											UNION
-											SELECT 'Replenishment' AS service_name, amount AS order_sum, 'Approved' AS status_name, created
-											FROM replenishments
-											WHERE user_id = $1
-											ORDER BY `)
-	switch history.OrderBy {
-	case "date":
-		str.WriteString("created ")
-	case "sum":
-		str.WriteString("order_sum ")
+// GetSavedReports returns at most limit report files, the latest generated first
+func (r *BalanceRepo) GetSavedReports(ctx context.Context, limit int) ([]entity.SavedReport, error) {
+	reports := []entity.SavedReport{}
+	err := r.Pool.SelectContext(ctx, &reports,
+		`SELECT `+savedReportColumns+` FROM report_files ORDER BY created DESC, file_name LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BalanceRepository - GetSavedReports: %w", err)
 	}
-	if history.Desc {
-		str.WriteString("DESC\n")
-	} else {
-		str.WriteString("\n")
+	return reports, nil
+}
+
+const idempotencyColumns = `idem_key, fingerprint, completed, response_code, response_body, created`
+
+// CreateIdempotencyKey saves new idempotency key and returns it with start of its lease. In-progress key of the same
+// request which lease started before stale is taken over. entity.ErrIdempotencyKeyExists if key is already used
+func (r *BalanceRepo) CreateIdempotencyKey(ctx context.Context, key entity.Idempotency,
+	stale time.Time) (entity.Idempotency, error) {
+	var res entity.Idempotency
+	err := r.Pool.GetContext(ctx, &res,
+		`INSERT INTO idempotency_keys (idem_key, fingerprint) VALUES ($1, $2)
+		ON CONFLICT (idem_key) DO UPDATE SET created = now()
+		WHERE NOT idempotency_keys.completed AND idempotency_keys.created < $3
+			AND idempotency_keys.fingerprint = excluded.fingerprint
+		RETURNING `+idempotencyColumns, key.Key, key.Fingerprint, stale)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return entity.Idempotency{}, entity.ErrIdempotencyKeyExists
+	case err != nil:
+		return entity.Idempotency{}, fmt.Errorf("BalanceRepository - CreateIdempotencyKey: %w", err)
 	}
-	offset := history.Limit * (history.Page - 1)
-	str.WriteString(`LIMIT `)
-	if history.Limit != 0 {
-		str.WriteString(strconv.Itoa(history.Limit))
-	} else {
-		str.WriteString("ALL")
+	return res, nil
+}
+
+// GetIdempotencyKey returns saved idempotency key with its response
+func (r *BalanceRepo) GetIdempotencyKey(ctx context.Context, key string) (entity.Idempotency, error) {
+	var res entity.Idempotency
+	err := r.Pool.GetContext(ctx, &res, `SELECT `+idempotencyColumns+` FROM idempotency_keys WHERE idem_key = $1`,
+		key)
+	if err != nil {
+		return entity.Idempotency{}, fmt.Errorf("BalanceRepository - GetIdempotencyKey: %w", err)
 	}
-	str.WriteString(` OFFSET `)
-	str.WriteString(strconv.Itoa(offset))
-	return str.String()
+	return res, nil
 }
 
-// GetReport returns report with given period, entity.ErrEmptyReport if there were no operations in this period
-func (r *BalanceRepo) GetReport(ctx context.Context, year, month int) (entity.Report, error) {
-	var Sums []entity.SumByService
-	err := r.Pool.SelectContext(ctx, &Sums,
-		`SELECT sum(o.order_sum) AS sums, s.service_name FROM orders AS o
-						JOIN services AS s ON s.service_id = o.service_id
-						WHERE o.status_id = 2
-						AND EXTRACT(YEAR FROM o.modified) = $1 AND EXTRACT(MONTH FROM o.modified) = $2
-						GROUP BY s.service_name`, year, month)
+// CompleteIdempotencyKey saves response of request with given idempotency key, key taken over by a retry is
+// left as is
+func (r *BalanceRepo) CompleteIdempotencyKey(ctx context.Context, key entity.Idempotency) error {
+	_, err := r.Pool.NamedExecContext(ctx,
+		`UPDATE idempotency_keys SET completed = true, response_code = :response_code, response_body = :response_body
+						WHERE idem_key = :idem_key AND created = :created`, key)
 	if err != nil {
-		return entity.Report{}, fmt.Errorf("BalanceRepository - GetReport: %w", err)
+		return fmt.Errorf("BalanceRepository - CompleteIdempotencyKey: %w", err)
 	}
-	if len(Sums) == 0 {
-		return entity.Report{}, entity.ErrEmptyReport
+	return nil
+}
+
+// DeleteIdempotencyKey removes idempotency key which request wasn't completed, key taken over by a retry is
+// left as is
+func (r *BalanceRepo) DeleteIdempotencyKey(ctx context.Context, key entity.Idempotency) error {
+	_, err := r.Pool.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE idem_key = $1 AND created = $2 AND NOT completed`, key.Key, key.Created)
+	if err != nil {
+		return fmt.Errorf("BalanceRepository - DeleteIdempotencyKey: %w", err)
 	}
-	return entity.Report{Sums: Sums}, nil
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys removes idempotency keys created before given time, returns number of removed keys
+func (r *BalanceRepo) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.Pool.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE created < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("BalanceRepository - DeleteExpiredIdempotencyKeys: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("BalanceRepository - DeleteExpiredIdempotencyKeys: %w", err)
+	}
+	return n, nil
+}
+
+// runInTx runs fn in transaction. Idempotency key of request context is saved as completed with its response in
+// the same transaction, so operation is never done without its response saved. If the lease of the key was taken
+// over by a retry, transaction is rolled back with entity.ErrIdempotencyInProgress
+func (r *BalanceRepo) runInTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *sqlx.Tx) error) error {
+	return r.RunInTx(ctx, opts, func(tx *sqlx.Tx) error {
+		err := fn(tx)
+		if err != nil {
+			return err
+		}
+		key, ok := entity.IdempotencyFrom(ctx)
+		if !ok {
+			return nil
+		}
+		res, err := tx.ExecContext(ctx,
+			`UPDATE idempotency_keys SET completed = true, response_code = $3, response_body = $4
+			WHERE idem_key = $1 AND created = $2 AND NOT completed`, key.Key, key.Created, key.Code, key.Response)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - runInTx: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - runInTx: %w", err)
+		}
+		if n == 0 {
+			return entity.ErrIdempotencyInProgress
+		}
+		return nil
+	})
+}
+
+// GetBalanceChecks returns cached balances of users with id greater than afterID together with balances computed
+// from the ledger and from users' operations, users are ordered by id
+func (r *BalanceRepo) GetBalanceChecks(ctx context.Context, afterID, limit int) ([]entity.BalanceCheck, error) {
+	var res []entity.BalanceCheck
+	err := r.Pool.SelectContext(ctx, &res,
+		`SELECT u.user_id, u.amount, u.reserved,
+						COALESCE((SELECT sum(amount) FROM ledger_entries WHERE debit = 'user:' || u.user_id || ':available'), 0.00)
+						- COALESCE((SELECT sum(amount) FROM ledger_entries WHERE credit = 'user:' || u.user_id || ':available'), 0.00)
+						AS ledger_amount,
+						COALESCE((SELECT sum(amount) FROM ledger_entries WHERE debit = 'user:' || u.user_id || ':reserved'), 0.00)
+						- COALESCE((SELECT sum(amount) FROM ledger_entries WHERE credit = 'user:' || u.user_id || ':reserved'), 0.00)
+						AS ledger_reserved,
+						COALESCE((SELECT sum(amount) FROM replenishments WHERE user_id = u.user_id), 0.00)
+						+ COALESCE((SELECT sum(amount) FROM transfers WHERE to_user_id = u.user_id), 0.00)
+						- COALESCE((SELECT sum(amount) FROM transfers WHERE from_user_id = u.user_id), 0.00)
+						+ COALESCE((SELECT sum(amount) FROM refunds WHERE user_id = u.user_id), 0.00)
+						- COALESCE((SELECT sum(CASE WHEN status_id = 1 THEN order_sum WHEN status_id IN (2, 4, 5) THEN captured END)
+						            FROM orders WHERE user_id = u.user_id), 0.00) AS operations_amount,
+						COALESCE((SELECT sum(order_sum) FROM orders WHERE user_id = u.user_id AND status_id = 1), 0.00)
+						AS operations_reserved
+						FROM users AS u WHERE u.user_id > $1
+						ORDER BY u.user_id
+						LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BalanceRepository - GetBalanceChecks: %w", err)
+	}
+	return res, nil
+}
+
+// reconciliation is a row of reconciliations, discrepancies are kept as json
+type reconciliation struct {
+	Started       time.Time `db:"started"`
+	Finished      time.Time `db:"finished"`
+	Users         int       `db:"users"`
+	Discrepancies []byte    `db:"discrepancies"`
+}
+
+// SaveReconciliation saves result of reconciliation run
+func (r *BalanceRepo) SaveReconciliation(ctx context.Context, res entity.Reconciliation) error {
+	discrepancies, err := json.Marshal(res.Discrepancies)
+	if err != nil {
+		return fmt.Errorf("BalanceRepository - SaveReconciliation: %w", err)
+	}
+	_, err = r.Pool.ExecContext(ctx,
+		`INSERT INTO reconciliations (started, finished, users, discrepancies) VALUES ($1, $2, $3, $4)`,
+		res.Started, res.Finished, res.Users, discrepancies)
+	if err != nil {
+		return fmt.Errorf("BalanceRepository - SaveReconciliation: %w", err)
+	}
+	return nil
+}
+
+// GetLastReconciliation returns result of the latest finished reconciliation run, entity.ErrNoReconciliation
+// if there was no one
+func (r *BalanceRepo) GetLastReconciliation(ctx context.Context) (entity.Reconciliation, error) {
+	var row reconciliation
+	err := r.Pool.GetContext(ctx, &row,
+		`SELECT started, finished, users, discrepancies FROM reconciliations
+		ORDER BY finished DESC, reconciliation_id DESC LIMIT 1`)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return entity.Reconciliation{}, entity.ErrNoReconciliation
+	case err != nil:
+		return entity.Reconciliation{}, fmt.Errorf("BalanceRepository - GetLastReconciliation: %w", err)
+	}
+	res := entity.Reconciliation{Started: row.Started, Finished: row.Finished, Users: row.Users}
+	err = json.Unmarshal(row.Discrepancies, &res.Discrepancies)
+	if err != nil {
+		return entity.Reconciliation{}, fmt.Errorf("BalanceRepository - GetLastReconciliation: %w", err)
+	}
+	return res, nil
+}
+
+// GetServices returns all services ordered by id
+func (r *BalanceRepo) GetServices(ctx context.Context) ([]entity.Service, error) {
+	var res []entity.Service
+	err := r.Pool.SelectContext(ctx, &res,
+		`SELECT service_id, service_name, active FROM services ORDER BY service_id`)
+	if err != nil {
+		return nil, fmt.Errorf("BalanceRepository - GetServices: %w", err)
+	}
+	return res, nil
+}
+
+// CreateService creates new active service, returns entity.ErrServiceExists if name is already used
+func (r *BalanceRepo) CreateService(ctx context.Context, name string) (entity.Service, error) {
+	var res entity.Service
+	err := r.Pool.GetContext(ctx, &res,
+		`INSERT INTO services (service_name) VALUES ($1) RETURNING service_id, service_name, active`, name)
+	switch {
+	case isUniqueViolation(err):
+		return entity.Service{}, entity.ErrServiceExists
+	case err != nil:
+		return entity.Service{}, fmt.Errorf("BalanceRepository - CreateService: %w", err)
+	}
+	return res, nil
+}
+
+// UpdateService changes not nil fields of service, returns entity.ErrNoService if there is no such service,
+// entity.ErrServiceExists if new name is already used
+func (r *BalanceRepo) UpdateService(ctx context.Context, update entity.ServiceUpdate) (entity.Service, error) {
+	var res entity.Service
+	err := r.Pool.GetContext(ctx, &res,
+		`UPDATE services SET service_name = COALESCE($2, service_name), active = COALESCE($3, active)
+						WHERE service_id = $1
+						RETURNING service_id, service_name, active`, update.ID, update.Name, update.Active)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return entity.Service{}, entity.ErrNoService
+	case isUniqueViolation(err):
+		return entity.Service{}, entity.ErrServiceExists
+	case err != nil:
+		return entity.Service{}, fmt.Errorf("BalanceRepository - UpdateService: %w", err)
+	}
+	return res, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
 }
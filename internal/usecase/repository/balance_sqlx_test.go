@@ -0,0 +1,908 @@
+package repository
+
+import (
+	"balance_api/internal/entity"
+	"balance_api/pkg/postgres"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testUserID      = 1000001
+	otherTestUserID = 1000002
+)
+
+// newTestRepo connects to db from TEST_DB_URI env, tests are skipped if it is not set.
+// Db should have schema applied.
+func newTestRepo(t *testing.T) *BalanceRepo {
+	uri := os.Getenv("TEST_DB_URI")
+	if uri == "" {
+		t.Skip("TEST_DB_URI is not set")
+	}
+	db, err := postgres.New(uri, postgres.MaxConn(20))
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+	r := New(db)
+	cleanTestUser(t, r)
+	t.Cleanup(func() { cleanTestUser(t, r) })
+	return r
+}
+
+func cleanTestUser(t *testing.T, r *BalanceRepo) {
+	for _, id := range []int{testUserID, otherTestUserID} {
+		for _, q := range []string{
+			`DELETE FROM ledger_entries WHERE debit LIKE 'user:' || $1::text || ':%'
+				OR credit LIKE 'user:' || $1::text || ':%'`,
+			`DELETE FROM refunds WHERE user_id = $1`,
+			`DELETE FROM order_events WHERE order_id IN (SELECT order_id FROM orders WHERE user_id = $1)`,
+			`DELETE FROM orders WHERE user_id = $1`,
+			`DELETE FROM replenishments WHERE user_id = $1`,
+			`DELETE FROM transfers WHERE from_user_id = $1 OR to_user_id = $1`,
+			`DELETE FROM users WHERE user_id = $1`,
+		} {
+			_, err := r.Pool.Exec(q, id)
+			require.NoError(t, err)
+		}
+	}
+}
+
+func TestCreateOrderConcurrent(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+
+	const orders = 20
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+		errs    []error
+	)
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := r.CreateOrder(ctx,
+				entity.Order{ID: testUserID + i, ServiceID: 1, UserID: testUserID, Sum: "100"})
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				created++
+				return
+			}
+			errs = append(errs, err)
+		}(i)
+	}
+	wg.Wait()
+
+	require.Equal(t, 5, created)
+	for _, err := range errs {
+		require.True(t, errors.Is(err, entity.ErrNotEnoughMoney), err)
+	}
+
+	var res struct {
+		Amount   decimal.Decimal `db:"amount"`
+		Reserved decimal.Decimal `db:"reserved"`
+	}
+	require.NoError(t, r.Pool.Get(&res, `SELECT amount, reserved FROM users WHERE user_id = $1`, testUserID))
+	require.True(t, res.Amount.IsZero(), res.Amount)
+	require.True(t, res.Reserved.Equal(decimal.NewFromInt(500)), res.Reserved)
+}
+
+func TestCreateOrderConcurrentSameID(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+
+	const orders = 10
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+		errs    []error
+	)
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := r.CreateOrder(ctx,
+				entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "10"})
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				created++
+				return
+			}
+			errs = append(errs, err)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, 1, created)
+	for _, err := range errs {
+		require.True(t, errors.Is(err, entity.ErrOrderExists), err)
+	}
+}
+
+func TestCreateOrderErrors(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "100"}))
+
+	type TestCase struct {
+		name        string
+		val         entity.Order
+		expectedErr error
+	}
+
+	cases := []TestCase{{
+		name:        "no such user",
+		val:         entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID + 1, Sum: "10"},
+		expectedErr: entity.ErrNoID,
+	}, {
+		name:        "not enough money",
+		val:         entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "1000"},
+		expectedErr: entity.ErrNotEnoughMoney,
+	}, {
+		name:        "no such service",
+		val:         entity.Order{ID: testUserID, ServiceID: 1000, UserID: testUserID, Sum: "10"},
+		expectedErr: entity.ErrNoService,
+	},
+	}
+
+	for _, tc := range cases {
+		err := r.CreateOrder(ctx, tc.val)
+		require.Equal(t, tc.expectedErr, err, tc.name)
+	}
+}
+
+func TestGetBalanceAt(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+	for _, q := range []string{
+		`UPDATE replenishments SET created = '2022-01-01T00:00:00Z' WHERE user_id = $1`,
+		`INSERT INTO orders (order_id, service_id, user_id, order_sum, captured, refunded, status_id, created, modified)
+			VALUES ($1, 1, $1, 100, 80, 30, 5, '2022-02-01T00:00:00Z', '2022-03-01T00:00:00Z')`,
+		`INSERT INTO refunds (order_id, user_id, amount, created) VALUES ($1, $1, 30, '2022-04-01T00:00:00Z')`,
+	} {
+		_, err := r.Pool.Exec(q, testUserID)
+		require.NoError(t, err)
+	}
+
+	type TestCase struct {
+		name             string
+		at               time.Time
+		expectedAmount   string
+		expectedReserved string
+		expectedPending  int
+	}
+
+	cases := []TestCase{{
+		name:             "before any operation",
+		at:               time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC),
+		expectedAmount:   "0.00",
+		expectedReserved: "0.00",
+	}, {
+		name:             "after replenishment",
+		at:               time.Date(2022, 1, 15, 0, 0, 0, 0, time.UTC),
+		expectedAmount:   "500.00",
+		expectedReserved: "0.00",
+	}, {
+		name:             "order is pending",
+		at:               time.Date(2022, 2, 15, 0, 0, 0, 0, time.UTC),
+		expectedAmount:   "400.00",
+		expectedReserved: "100.00",
+		expectedPending:  1,
+	}, {
+		name:             "order is approved",
+		at:               time.Date(2022, 3, 15, 0, 0, 0, 0, time.UTC),
+		expectedAmount:   "420.00",
+		expectedReserved: "0.00",
+	}, {
+		name:             "order is refunded",
+		at:               time.Date(2022, 4, 15, 0, 0, 0, 0, time.UTC),
+		expectedAmount:   "450.00",
+		expectedReserved: "0.00",
+	},
+	}
+
+	for _, tc := range cases {
+		b, err := r.GetBalanceAt(ctx, testUserID, tc.at)
+		require.NoError(t, err, tc.name)
+		require.Equal(t, tc.expectedAmount, b.Amount, tc.name)
+		require.Equal(t, tc.expectedReserved, b.Reserved, tc.name)
+		require.Equal(t, tc.expectedPending, b.PendingOrders, tc.name)
+	}
+
+	_, err := r.GetBalanceAt(ctx, testUserID+1, time.Now())
+	require.Equal(t, entity.ErrNoID, err)
+}
+
+func TestLedger(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100"}))
+	require.NoError(t, r.CommitOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Captured: "80", StatusID: entity.StatusApproved}))
+	require.NoError(t, r.RefundOrder(ctx, entity.Refund{OrderID: testUserID, UserID: testUserID, Amount: "30"}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 1, ServiceID: 1, UserID: testUserID, Sum: "50"}))
+
+	var res struct {
+		Amount   decimal.Decimal `db:"amount"`
+		Reserved decimal.Decimal `db:"reserved"`
+	}
+	require.NoError(t, r.Pool.Get(&res, `SELECT amount, reserved FROM users WHERE user_id = $1`, testUserID))
+	require.True(t, res.Amount.Equal(decimal.NewFromInt(400)), res.Amount)
+	require.True(t, res.Reserved.Equal(decimal.NewFromInt(50)), res.Reserved)
+
+	for _, tc := range []struct {
+		account  string
+		expected decimal.Decimal
+	}{
+		{availableAccount(testUserID).name, res.Amount},
+		{reservedAccount(testUserID).name, res.Reserved},
+	} {
+		var balance decimal.Decimal
+		require.NoError(t, r.Pool.Get(&balance,
+			`SELECT COALESCE(sum(CASE WHEN debit = $1 THEN amount ELSE -amount END), 0)
+			FROM ledger_entries WHERE debit = $1 OR credit = $1`, tc.account))
+		require.True(t, tc.expected.Equal(balance), tc.account)
+	}
+}
+
+func TestOrderEvents(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CommitOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Captured: "80", StatusID: entity.StatusApproved, Actor: "api:support", Reason: "delivered"}))
+	require.NoError(t, r.RefundOrder(ctx, entity.Refund{OrderID: testUserID, UserID: testUserID, Amount: "30",
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.RefundOrder(ctx, entity.Refund{OrderID: testUserID, UserID: testUserID, Amount: "50",
+		Actor: entity.ActorAPI, Reason: "damaged"}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 1, ServiceID: 1, UserID: testUserID,
+		Sum: "50", Actor: entity.ActorAPI}))
+	require.NoError(t, r.RollbackOrder(ctx, entity.Order{ID: testUserID + 1, StatusID: entity.StatusExpired,
+		Actor: entity.ActorSweeper}))
+
+	type step struct {
+		from, to      int
+		amount, actor string
+		reason        string
+	}
+	for id, expected := range map[int][]step{
+		testUserID: {
+			{0, entity.StatusPending, "100.00", entity.ActorAPI, ""},
+			{entity.StatusPending, entity.StatusApproved, "80.00", "api:support", "delivered"},
+			{entity.StatusApproved, entity.StatusPartiallyRefunded, "30.00", entity.ActorAPI, ""},
+			{entity.StatusPartiallyRefunded, entity.StatusRefunded, "50.00", entity.ActorAPI, "damaged"},
+		},
+		testUserID + 1: {
+			{0, entity.StatusPending, "50.00", entity.ActorAPI, ""},
+			{entity.StatusPending, entity.StatusExpired, "50.00", entity.ActorSweeper, ""},
+		},
+	} {
+		events, err := r.GetOrderEvents(ctx, id)
+		require.NoError(t, err)
+		require.Len(t, events, len(expected))
+		for i, e := range events {
+			require.Equal(t, expected[i], step{e.FromStatusID, e.ToStatusID, e.Amount, e.Actor, e.Reason}, id)
+		}
+	}
+}
+
+func TestHistoryMetadata(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500", Comment: "credited by card",
+		Metadata: entity.Metadata{"card": "*1234"}}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Comment: "rent for October", Metadata: entity.Metadata{"invoice": "INV-42"}, Actor: entity.ActorAPI}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 1, ServiceID: 1, UserID: testUserID,
+		Sum: "50", Metadata: entity.Metadata{"invoice": "INV-43"}, Actor: entity.ActorAPI}))
+
+	order, err := r.GetOrderByID(ctx, testUserID)
+	require.NoError(t, err)
+	require.Equal(t, "rent for October", order.Comment)
+	require.Equal(t, entity.Metadata{"invoice": "INV-42"}, order.Metadata)
+
+	for _, tc := range []struct {
+		key, value string
+		expected   []string
+	}{
+		{"", "", []string{"credited by card", "rent for October", ""}},
+		{"card", "", []string{"credited by card"}},
+		{"invoice", "", []string{"rent for October", ""}},
+		{"invoice", "INV-42", []string{"rent for October"}},
+		{"invoice", "INV-44", nil},
+	} {
+		h, err := r.GetHistory(ctx, entity.History{UserID: testUserID, OrderBy: "sum", Desc: true,
+			MetadataKey: tc.key, MetadataValue: tc.value})
+		require.NoError(t, err)
+		var comments []string
+		for _, o := range h.Orders {
+			comments = append(comments, o.Comment)
+		}
+		require.Equal(t, tc.expected, comments, tc.key+"="+tc.value)
+	}
+}
+
+func TestHistoryCursor(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	// operations of the same sum and kind differ only by id
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "100"}))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Increase(ctx, entity.Balance{ID: testUserID, Amount: "100"}))
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + i, ServiceID: 1, UserID: testUserID,
+			Sum: "50", Actor: entity.ActorAPI}))
+	}
+
+	for _, orderBy := range []string{"date", "sum"} {
+		for _, desc := range []bool{false, true} {
+			all, err := r.GetHistory(ctx, entity.History{UserID: testUserID, OrderBy: orderBy, Desc: desc})
+			require.NoError(t, err)
+			require.Len(t, all.Orders, 7)
+			require.Nil(t, all.Next)
+
+			var paged []entity.Order
+			h := entity.History{UserID: testUserID, OrderBy: orderBy, Desc: desc, Limit: 2, Page: 1}
+			for {
+				h, err = r.GetHistory(ctx, h)
+				require.NoError(t, err)
+				paged = append(paged, h.Orders...)
+				if h.Next == nil {
+					break
+				}
+				h.After, h.Page = h.Next, 0
+			}
+			require.Equal(t, all.Orders, paged, orderBy)
+		}
+	}
+
+	h, err := r.GetHistory(ctx, entity.History{UserID: testUserID, OrderBy: "date", Limit: 10, Page: 2})
+	require.NoError(t, err)
+	require.Empty(t, h.Orders)
+}
+
+func TestHistoryFilters(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	start := time.Now().Add(-time.Minute)
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CommitOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Captured: "100", StatusID: entity.StatusApproved, Actor: entity.ActorAPI}))
+	require.NoError(t, r.RefundOrder(ctx, entity.Refund{OrderID: testUserID, UserID: testUserID, Amount: "30",
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 1, ServiceID: 2, UserID: testUserID,
+		Sum: "50", Actor: entity.ActorAPI}))
+	require.NoError(t, r.RollbackOrder(ctx, entity.Order{ID: testUserID + 1, StatusID: entity.StatusCanceled,
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 2, ServiceID: 2, UserID: testUserID,
+		Sum: "20", Actor: entity.ActorAPI}))
+
+	for _, tc := range []struct {
+		name     string
+		history  entity.History
+		expected []string
+	}{{
+		name:     "no filters",
+		expected: []string{"500.00", "100.00", "50.00", "30.00", "20.00"},
+	}, {
+		name:     "from and to",
+		history:  entity.History{From: start, To: time.Now().Add(time.Minute)},
+		expected: []string{"500.00", "100.00", "50.00", "30.00", "20.00"},
+	}, {
+		name:     "from in future",
+		history:  entity.History{From: time.Now().Add(time.Minute)},
+		expected: nil,
+	}, {
+		name:     "to in past",
+		history:  entity.History{To: start},
+		expected: nil,
+	}, {
+		name:     "replenishments",
+		history:  entity.History{Kinds: []int{entity.KindReplenishment}},
+		expected: []string{"500.00"},
+	}, {
+		name:     "orders and refunds",
+		history:  entity.History{Kinds: []int{entity.KindOrder, entity.KindRefund}},
+		expected: []string{"100.00", "50.00", "30.00", "20.00"},
+	}, {
+		name:     "service",
+		history:  entity.History{ServiceIDs: []int{1}},
+		expected: []string{"100.00", "30.00"},
+	}, {
+		name:     "status",
+		history:  entity.History{StatusIDs: []int{entity.StatusCanceled, entity.StatusPending}},
+		expected: []string{"50.00", "20.00"},
+	}, {
+		name:     "sums",
+		history:  entity.History{MinSum: "30", MaxSum: "100"},
+		expected: []string{"100.00", "50.00", "30.00"},
+	}, {
+		name: "combined",
+		history: entity.History{Kinds: []int{entity.KindOrder}, ServiceIDs: []int{2},
+			StatusIDs: []int{entity.StatusPending}, MinSum: "10", From: start},
+		expected: []string{"20.00"},
+	}} {
+		h := tc.history
+		h.UserID, h.OrderBy, h.Desc = testUserID, "sum", true
+		h, err := r.GetHistory(ctx, h)
+		require.NoError(t, err, tc.name)
+		var sums []string
+		for _, o := range h.Orders {
+			sums = append(sums, o.Sum)
+		}
+		require.Equal(t, tc.expected, sums, tc.name)
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CommitOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Captured: "80", StatusID: entity.StatusApproved, Actor: entity.ActorAPI}))
+	require.NoError(t, r.RefundOrder(ctx, entity.Refund{OrderID: testUserID, UserID: testUserID, Amount: "30",
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 1, ServiceID: 2, UserID: testUserID,
+		Sum: "50", Actor: entity.ActorAPI}))
+	require.NoError(t, r.RollbackOrder(ctx, entity.Order{ID: testUserID + 1, StatusID: entity.StatusCanceled,
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 2, ServiceID: 2, UserID: testUserID,
+		Sum: "20", Actor: entity.ActorAPI}))
+
+	export := func(h entity.History) []string {
+		var res []string
+		h.UserID, h.OrderBy = testUserID, "date"
+		require.NoError(t, r.ExportHistory(ctx, h, func(o entity.Order) error {
+			res = append(res, o.Sum+" "+o.BalanceAfter.Available+"/"+o.BalanceAfter.Reserved)
+			return nil
+		}))
+		return res
+	}
+
+	// limit and page are ignored, balance after the last operation is the current one
+	require.Equal(t, []string{"500.00 500.00/0.00", "100.00 400.00/100.00", "30.00 450.00/0.00",
+		"50.00 400.00/50.00", "20.00 430.00/20.00"}, export(entity.History{Limit: 1, Page: 2}))
+	b, err := r.GetByID(ctx, testUserID)
+	require.NoError(t, err)
+	require.Equal(t, "430.00/20.00", b.Amount+"/"+b.Reserved)
+
+	// balance doesn't depend on filters
+	require.Equal(t, []string{"100.00 400.00/100.00", "50.00 400.00/50.00", "20.00 430.00/20.00"},
+		export(entity.History{Kinds: []int{entity.KindOrder}}))
+
+	stop := errors.New("stop")
+	calls := 0
+	err = r.ExportHistory(ctx, entity.History{UserID: testUserID, OrderBy: "date"}, func(o entity.Order) error {
+		calls++
+		return stop
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, 1, calls)
+}
+
+func TestHistoryBalanceAfter(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: otherTestUserID, Amount: "10"}))
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CommitOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Captured: "80", StatusID: entity.StatusApproved, Actor: entity.ActorAPI}))
+	require.NoError(t, r.RefundOrder(ctx, entity.Refund{OrderID: testUserID, UserID: testUserID, Amount: "30",
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 1, ServiceID: 2, UserID: testUserID,
+		Sum: "50", Actor: entity.ActorAPI}))
+	require.NoError(t, r.Transfer(ctx, entity.Transfer{FromID: testUserID, ToID: otherTestUserID, Amount: "70"}))
+	require.NoError(t, r.RollbackOrder(ctx, entity.Order{ID: testUserID + 1, StatusID: entity.StatusCanceled,
+		Actor: entity.ActorAPI}))
+
+	after := func(orders []entity.Order) map[string]string {
+		res := make(map[string]string)
+		for _, o := range orders {
+			res[o.ServiceName+" "+o.Sum] = o.BalanceAfter.Available + "/" + o.BalanceAfter.Reserved
+		}
+		return res
+	}
+	expected := map[string]string{
+		"Replenishment 500.00": "500.00/0.00",
+		"Rent 100.00":          "400.00/100.00",
+		"Refund: Rent 30.00":   "450.00/0.00",
+		"Good bought 50.00":    "400.00/50.00",
+		fmt.Sprintf("Transfer to user %d 70.00", otherTestUserID): "330.00/50.00",
+	}
+
+	for _, orderBy := range []string{"date", "sum"} {
+		for _, desc := range []bool{false, true} {
+			all, err := r.GetHistory(ctx, entity.History{UserID: testUserID, OrderBy: orderBy, Desc: desc})
+			require.NoError(t, err)
+			require.Equal(t, expected, after(all.Orders), orderBy)
+
+			var paged []entity.Order
+			h := entity.History{UserID: testUserID, OrderBy: orderBy, Desc: desc, Limit: 2, Page: 1}
+			for {
+				h, err = r.GetHistory(ctx, h)
+				require.NoError(t, err)
+				paged = append(paged, h.Orders...)
+				if h.Next == nil {
+					break
+				}
+				h.After, h.Page = h.Next, 0
+			}
+			require.Equal(t, expected, after(paged), orderBy)
+		}
+	}
+}
+
+func TestGetReport(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CommitOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Captured: "100", StatusID: entity.StatusApproved, Actor: entity.ActorAPI}))
+	require.NoError(t, r.RefundOrder(ctx, entity.Refund{OrderID: testUserID, UserID: testUserID, Amount: "30",
+		Actor: entity.ActorAPI}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 1, ServiceID: 2, UserID: testUserID,
+		Sum: "50", Actor: entity.ActorAPI}))
+	require.NoError(t, r.CommitOrder(ctx, entity.Order{ID: testUserID + 1, ServiceID: 2, UserID: testUserID,
+		Sum: "50", Captured: "40", StatusID: entity.StatusApproved, Actor: entity.ActorAPI}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 2, ServiceID: 2, UserID: testUserID,
+		Sum: "20", Actor: entity.ActorAPI}))
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	userSums := func(sums []entity.SumByService) []entity.SumByService {
+		var res []entity.SumByService
+		for _, s := range sums {
+			if s.UserID == testUserID {
+				s.Name = ""
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+
+	sums, err := r.GetReport(ctx, entity.ReportParams{From: today, To: today, Location: time.UTC, Breakdown: "day",
+		ByService: true, ByUser: true})
+	require.NoError(t, err)
+	period := today.Format("2006-01-02")
+	require.Equal(t, []entity.SumByService{
+		{Sum: "70.00", OrderSum: "100.00", UserID: testUserID, Period: period},
+		{Sum: "40.00", OrderSum: "50.00", UserID: testUserID, Period: period},
+	}, userSums(sums))
+
+	sums, err = r.GetReport(ctx, entity.ReportParams{From: today.AddDate(0, 0, -7), To: today, Location: time.UTC,
+		ByUser: true})
+	require.NoError(t, err)
+	require.Equal(t, []entity.SumByService{{Sum: "110.00", OrderSum: "150.00", UserID: testUserID}}, userSums(sums))
+
+	_, err = r.GetReport(ctx, entity.ReportParams{From: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+		To: time.Date(1980, 1, 31, 0, 0, 0, 0, time.UTC), Location: time.UTC, ByService: true})
+	require.ErrorIs(t, err, entity.ErrEmptyReport)
+}
+
+func TestReportJobs(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	clean := func() {
+		_, err := r.Pool.Exec(`DELETE FROM report_jobs`)
+		require.NoError(t, err)
+	}
+	clean()
+	t.Cleanup(clean)
+
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	params := entity.ReportParams{From: time.Date(2022, 7, 1, 0, 0, 0, 0, moscow),
+		To: time.Date(2022, 9, 30, 0, 0, 0, 0, moscow), Location: moscow, Breakdown: "month", ByUser: true}
+	first, err := r.CreateReportJob(ctx, entity.ReportJob{Params: params, Format: "xlsx"})
+	require.NoError(t, err)
+	require.Equal(t, entity.ReportJobQueued, first.Status)
+	require.Equal(t, params.Period(), first.Params.Period())
+	require.Equal(t, params.Location.String(), first.Params.Location.String())
+	second, err := r.CreateReportJob(ctx, entity.ReportJob{Params: params, Format: "csv"})
+	require.NoError(t, err)
+
+	job, err := r.StartReportJob(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, first.ID, job.ID)
+	require.Equal(t, entity.ReportJobRunning, job.Status)
+	require.NotNil(t, job.Started)
+
+	job.Status, job.Progress, job.Name = entity.ReportJobDone, 100, "report.xlsx"
+	require.NoError(t, r.UpdateReportJob(ctx, job))
+	job, err = r.GetReportJob(ctx, first.ID)
+	require.NoError(t, err)
+	require.Equal(t, entity.ReportJobDone, job.Status)
+	require.Equal(t, "report.xlsx", job.Name)
+	require.NotNil(t, job.Finished)
+
+	job, err = r.StartReportJob(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, second.ID, job.ID)
+	_, err = r.StartReportJob(ctx, time.Now().Add(-time.Hour))
+	require.ErrorIs(t, err, entity.ErrNoReportJob)
+	// running job of a stopped worker is taken again when its heartbeat is stale
+	taken, err := r.StartReportJob(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, second.ID, taken.ID)
+	// the first worker can't save progress or result of the job anymore
+	job.Progress = 50
+	require.ErrorIs(t, r.UpdateReportJob(ctx, job), entity.ErrNoReportJob)
+	taken.Progress = 50
+	require.NoError(t, r.UpdateReportJob(ctx, taken))
+
+	_, err = r.GetReportJob(ctx, second.ID+1)
+	require.ErrorIs(t, err, entity.ErrNoReportJob)
+}
+
+func TestSavedReports(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	clean := func() {
+		_, err := r.Pool.Exec(`DELETE FROM report_files`)
+		require.NoError(t, err)
+	}
+	clean()
+	t.Cleanup(clean)
+
+	_, err := r.GetSavedReport(ctx, "2022-10.csv")
+	require.ErrorIs(t, err, entity.ErrNoReport)
+
+	report := entity.SavedReport{Name: "2022-10.csv", Period: "2022-10", TimeZone: "UTC", Format: "csv", Size: 42,
+		Checksum: strings.Repeat("a", 64)}
+	first, err := r.SaveReport(ctx, report)
+	require.NoError(t, err)
+	require.False(t, first.Created.IsZero())
+	other, err := r.SaveReport(ctx, entity.SavedReport{Name: "2022-09.json", Period: "2022-09", TimeZone: "UTC",
+		Format: "json", Size: 10, Checksum: strings.Repeat("b", 64)})
+	require.NoError(t, err)
+
+	// regenerated report replaces the old one and becomes the latest
+	report.Size, report.Checksum = 43, strings.Repeat("c", 64)
+	second, err := r.SaveReport(ctx, report)
+	require.NoError(t, err)
+	require.False(t, second.Created.Before(first.Created))
+	got, err := r.GetSavedReport(ctx, "2022-10.csv")
+	require.NoError(t, err)
+	require.Equal(t, int64(43), got.Size)
+	require.Equal(t, strings.Repeat("c", 64), got.Checksum)
+
+	reports, err := r.GetSavedReports(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	require.Equal(t, "2022-10.csv", reports[0].Name)
+	require.Equal(t, other.Name, reports[1].Name)
+	reports, err = r.GetSavedReports(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	clean := func() {
+		_, err := r.Pool.Exec(`DELETE FROM idempotency_keys WHERE idem_key LIKE 'test-%'`)
+		require.NoError(t, err)
+	}
+	clean()
+	t.Cleanup(clean)
+	fp := strings.Repeat("a", 64)
+
+	first, err := r.CreateIdempotencyKey(ctx, entity.Idempotency{Key: "test-1", Fingerprint: fp},
+		time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.False(t, first.Created.IsZero())
+	_, err = r.CreateIdempotencyKey(ctx, entity.Idempotency{Key: "test-1", Fingerprint: fp},
+		time.Now().Add(-time.Minute))
+	require.ErrorIs(t, err, entity.ErrIdempotencyKeyExists)
+	// key of another request is never taken over
+	_, err = r.CreateIdempotencyKey(ctx, entity.Idempotency{Key: "test-1", Fingerprint: strings.Repeat("b", 64)},
+		time.Now().Add(time.Minute))
+	require.ErrorIs(t, err, entity.ErrIdempotencyKeyExists)
+
+	// stale lease is taken over by a retry, the first request can't complete or release the key anymore
+	second, err := r.CreateIdempotencyKey(ctx, entity.Idempotency{Key: "test-1", Fingerprint: fp},
+		time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, second.Created.After(first.Created))
+	done := first
+	done.Completed, done.Code, done.Response = true, 200, []byte("{}")
+	err = r.CreateUser(entity.WithIdempotency(ctx, done), entity.Balance{ID: testUserID, Amount: "100"})
+	require.ErrorIs(t, err, entity.ErrIdempotencyInProgress)
+	_, err = r.GetByID(ctx, testUserID)
+	require.ErrorIs(t, err, entity.ErrNoID)
+	require.NoError(t, r.DeleteIdempotencyKey(ctx, first))
+	saved, err := r.GetIdempotencyKey(ctx, "test-1")
+	require.NoError(t, err)
+	require.False(t, saved.Completed)
+
+	// response is saved in transaction of operation
+	done = second
+	done.Completed, done.Code, done.Response = true, 200, []byte("{}")
+	require.NoError(t, r.CreateUser(entity.WithIdempotency(ctx, done), entity.Balance{ID: testUserID, Amount: "100"}))
+	saved, err = r.GetIdempotencyKey(ctx, "test-1")
+	require.NoError(t, err)
+	require.True(t, saved.Completed)
+	require.Equal(t, 200, saved.Code)
+	require.Equal(t, []byte("{}"), saved.Response)
+	// completed key is neither taken over nor released
+	_, err = r.CreateIdempotencyKey(ctx, entity.Idempotency{Key: "test-1", Fingerprint: fp},
+		time.Now().Add(time.Minute))
+	require.ErrorIs(t, err, entity.ErrIdempotencyKeyExists)
+	require.NoError(t, r.DeleteIdempotencyKey(ctx, saved))
+	_, err = r.GetIdempotencyKey(ctx, "test-1")
+	require.NoError(t, err)
+
+	_, err = r.CreateIdempotencyKey(ctx, entity.Idempotency{Key: "test-2", Fingerprint: fp},
+		time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	n, err := r.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, n, int64(2))
+	_, err = r.GetIdempotencyKey(ctx, "test-2")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestGetBalanceChecks(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100"}))
+	require.NoError(t, r.CommitOrder(ctx, entity.Order{ID: testUserID, ServiceID: 1, UserID: testUserID, Sum: "100",
+		Captured: "80", StatusID: entity.StatusApproved}))
+	require.NoError(t, r.CreateOrder(ctx, entity.Order{ID: testUserID + 1, ServiceID: 1, UserID: testUserID, Sum: "50"}))
+
+	checks, err := r.GetBalanceChecks(ctx, testUserID-1, 1)
+	require.NoError(t, err)
+	require.Equal(t, []entity.BalanceCheck{{UserID: testUserID, Amount: "370.00", Reserved: "50.00",
+		LedgerAmount: "370.00", LedgerReserved: "50.00", OperationsAmount: "370.00", OperationsReserved: "50.00"}},
+		checks)
+
+	_, err = r.Pool.Exec(`UPDATE users SET amount = amount + 1 WHERE user_id = $1`, testUserID)
+	require.NoError(t, err)
+	checks, err = r.GetBalanceChecks(ctx, testUserID-1, 1)
+	require.NoError(t, err)
+	require.Equal(t, "371.00", checks[0].Amount)
+	require.Equal(t, "370.00", checks[0].LedgerAmount)
+}
+
+func TestReconciliations(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	clean := func() {
+		_, err := r.Pool.Exec(`DELETE FROM reconciliations`)
+		require.NoError(t, err)
+	}
+	clean()
+	t.Cleanup(clean)
+
+	_, err := r.GetLastReconciliation(ctx)
+	require.ErrorIs(t, err, entity.ErrNoReconciliation)
+
+	started := time.Date(2022, 10, 24, 13, 0, 0, 0, time.UTC)
+	first := entity.Reconciliation{Started: started, Finished: started.Add(time.Second), Users: 2,
+		Discrepancies: []entity.BalanceCheck{}}
+	last := entity.Reconciliation{Started: started.Add(time.Hour), Finished: started.Add(time.Hour + time.Second),
+		Users: 3, Discrepancies: []entity.BalanceCheck{{UserID: testUserID, Amount: "371.00", Reserved: "50.00",
+			LedgerAmount: "370.00", LedgerReserved: "50.00", OperationsAmount: "370.00", OperationsReserved: "50.00"}}}
+	require.NoError(t, r.SaveReconciliation(ctx, last))
+	require.NoError(t, r.SaveReconciliation(ctx, first))
+
+	res, err := r.GetLastReconciliation(ctx)
+	require.NoError(t, err)
+	require.True(t, last.Finished.Equal(res.Finished))
+	require.Equal(t, last.Users, res.Users)
+	require.Equal(t, last.Discrepancies, res.Discrepancies)
+}
+
+func TestServices(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	const name = "Test service"
+	t.Cleanup(func() {
+		_, err := r.Pool.Exec(`DELETE FROM services WHERE service_name = $1`, name)
+		require.NoError(t, err)
+	})
+
+	service, err := r.CreateService(ctx, name)
+	require.NoError(t, err)
+	require.True(t, service.Active)
+	_, err = r.CreateService(ctx, name)
+	require.Equal(t, entity.ErrServiceExists, err)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+	require.NoError(t, r.CreateOrder(ctx,
+		entity.Order{ID: testUserID, ServiceID: service.ID, UserID: testUserID, Sum: "100"}))
+	// orders have to be deleted before the service
+	t.Cleanup(func() { cleanTestUser(t, r) })
+
+	inactive := false
+	service, err = r.UpdateService(ctx, entity.ServiceUpdate{ID: service.ID, Active: &inactive})
+	require.NoError(t, err)
+	require.Equal(t, entity.Service{ID: service.ID, Name: name}, service)
+	err = r.CreateOrder(ctx, entity.Order{ID: testUserID + 1, ServiceID: service.ID, UserID: testUserID, Sum: "100"})
+	require.Equal(t, entity.ErrNoService, err)
+	require.NoError(t, r.CommitOrder(ctx, entity.Order{ID: testUserID, Captured: "100", StatusID: entity.StatusApproved}))
+
+	services, err := r.GetServices(ctx)
+	require.NoError(t, err)
+	require.Contains(t, services, service)
+
+	_, err = r.UpdateService(ctx, entity.ServiceUpdate{ID: -1, Active: &inactive})
+	require.Equal(t, entity.ErrNoService, err)
+}
+
+func TestGetOrders(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+
+	require.NoError(t, r.CreateUser(ctx, entity.Balance{ID: testUserID, Amount: "500"}))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.CreateOrder(ctx,
+			entity.Order{ID: testUserID + i, ServiceID: 1 + i%2, UserID: testUserID, Sum: "100"}))
+	}
+	require.NoError(t, r.RollbackOrder(ctx, entity.Order{ID: testUserID + 1, StatusID: entity.StatusCanceled}))
+
+	order, err := r.GetOrderByID(ctx, testUserID+1)
+	require.NoError(t, err)
+	require.Equal(t, "Canceled", order.Status)
+	require.Equal(t, "Good bought", order.ServiceName)
+	require.False(t, order.Modified.Time.Before(order.Time.Time))
+	_, err = r.GetOrderByID(ctx, -1)
+	require.Equal(t, entity.ErrOrderNoExists, err)
+
+	page, err := r.GetOrders(ctx, entity.OrderFilter{UserID: testUserID, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	require.Equal(t, testUserID+2, page[0].ID)
+	require.Equal(t, testUserID+1, page[1].ID)
+	page, err = r.GetOrders(ctx, entity.OrderFilter{UserID: testUserID, Limit: 2,
+		After: &entity.OrderCursor{Created: page[1].Time.Time, ID: page[1].ID}})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Equal(t, testUserID, page[0].ID)
+
+	page, err = r.GetOrders(ctx, entity.OrderFilter{UserID: testUserID, StatusID: entity.StatusPending, ServiceID: 1,
+		CreatedFrom: time.Now().Add(-time.Hour), Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+}
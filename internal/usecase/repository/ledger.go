@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+// ledger operations, ref_id of entry is an id of replenishment, order, refund or transfer
+const (
+	opReplenishment = "replenishment"
+	opOrder         = "order"
+	opApprove       = "approve"
+	opCancel        = "cancel"
+	opExpire        = "expire"
+	opRefund        = "refund"
+	opTransfer      = "transfer"
+)
+
+// errNegativeBalance means that entries can't be applied to cached balance of a user, either it would become
+// negative or there is no such user
+var errNegativeBalance = errors.New("negative balance")
+
+// account is a ledger account, userID is set only for users' accounts which balances are cached in users table
+type account struct {
+	name     string
+	userID   int
+	reserved bool
+}
+
+var fundingAccount = account{name: "external:funding"}
+
+func availableAccount(userID int) account {
+	return account{name: fmt.Sprintf("user:%d:available", userID), userID: userID}
+}
+
+func reservedAccount(userID int) account {
+	return account{name: fmt.Sprintf("user:%d:reserved", userID), userID: userID, reserved: true}
+}
+
+func revenueAccount(serviceID int) account {
+	return account{name: fmt.Sprintf("revenue:%d", serviceID)}
+}
+
+// ledgerEntry moves amount from credit account to debit account
+type ledgerEntry struct {
+	debit  account
+	credit account
+	amount decimal.Decimal
+}
+
+type balanceDelta struct {
+	amount   decimal.Decimal
+	reserved decimal.Decimal
+}
+
+func (d *balanceDelta) add(a account, amount decimal.Decimal) {
+	if a.reserved {
+		d.reserved = d.reserved.Add(amount)
+	} else {
+		d.amount = d.amount.Add(amount)
+	}
+}
+
+// balanceDeltas sums changes of cached balances of every user touched by entries
+func balanceDeltas(entries []ledgerEntry) map[int]*balanceDelta {
+	deltas := make(map[int]*balanceDelta)
+	apply := func(a account, amount decimal.Decimal) {
+		if a.userID == 0 {
+			return
+		}
+		if deltas[a.userID] == nil {
+			deltas[a.userID] = &balanceDelta{}
+		}
+		deltas[a.userID].add(a, amount)
+	}
+	for _, e := range entries {
+		apply(e.debit, e.amount)
+		apply(e.credit, e.amount.Neg())
+	}
+	return deltas
+}
+
+// postEntries appends entries of operation to the ledger and applies them to cached balances in users table.
+// Users are updated in order of their ids, so concurrent operations can't deadlock. Entries with zero amount
+// are not saved, but their users are still checked. Returns errNegativeBalance if balance of any user can't be changed
+func postEntries(ctx context.Context, tx *sqlx.Tx, operation string, refID int, entries ...ledgerEntry) error {
+	deltas := balanceDeltas(entries)
+	ids := make([]int, 0, len(deltas))
+	for id := range deltas {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		d := deltas[id]
+		res, err := tx.ExecContext(ctx,
+			`UPDATE users SET amount = amount + $2, reserved = reserved + $3
+							WHERE user_id = $1 AND amount + $2 >= 0 AND reserved + $3 >= 0`, id, d.amount, d.reserved)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - postEntries: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - postEntries: %w", err)
+		}
+		if n == 0 {
+			return errNegativeBalance
+		}
+	}
+
+	for _, e := range entries {
+		if !e.amount.IsPositive() {
+			continue
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO ledger_entries (operation, ref_id, debit, credit, amount) VALUES ($1, $2, $3, $4, $5)`,
+			operation, refID, e.debit.name, e.credit.name, e.amount)
+		if err != nil {
+			return fmt.Errorf("BalanceRepository - postEntries: %w", err)
+		}
+	}
+	return nil
+}
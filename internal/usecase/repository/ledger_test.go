@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBalanceDeltas(t *testing.T) {
+	d := func(s string) decimal.Decimal {
+		return decimal.RequireFromString(s)
+	}
+
+	type TestCase struct {
+		name     string
+		entries  []ledgerEntry
+		expected map[int]balanceDelta
+	}
+
+	cases := []TestCase{{
+		name:     "replenishment",
+		entries:  []ledgerEntry{{debit: availableAccount(1), credit: fundingAccount, amount: d("100")}},
+		expected: map[int]balanceDelta{1: {amount: d("100"), reserved: d("0")}},
+	}, {
+		name:     "order",
+		entries:  []ledgerEntry{{debit: reservedAccount(1), credit: availableAccount(1), amount: d("100")}},
+		expected: map[int]balanceDelta{1: {amount: d("-100"), reserved: d("100")}},
+	}, {
+		name: "partial capture",
+		entries: []ledgerEntry{
+			{debit: revenueAccount(2), credit: reservedAccount(1), amount: d("80")},
+			{debit: availableAccount(1), credit: reservedAccount(1), amount: d("20")},
+		},
+		expected: map[int]balanceDelta{1: {amount: d("20"), reserved: d("-100")}},
+	}, {
+		name:     "zero capture",
+		entries:  []ledgerEntry{{debit: revenueAccount(2), credit: reservedAccount(1), amount: d("0")}},
+		expected: map[int]balanceDelta{1: {amount: d("0"), reserved: d("0")}},
+	}, {
+		name:    "transfer",
+		entries: []ledgerEntry{{debit: availableAccount(2), credit: availableAccount(1), amount: d("50")}},
+		expected: map[int]balanceDelta{
+			1: {amount: d("-50"), reserved: d("0")},
+			2: {amount: d("50"), reserved: d("0")},
+		},
+	},
+	}
+
+	for _, tc := range cases {
+		deltas := balanceDeltas(tc.entries)
+		require.Equal(t, len(tc.expected), len(deltas), tc.name)
+		for id, expected := range tc.expected {
+			require.NotNil(t, deltas[id], tc.name)
+			require.True(t, expected.amount.Equal(deltas[id].amount), tc.name)
+			require.True(t, expected.reserved.Equal(deltas[id].reserved), tc.name)
+		}
+	}
+}
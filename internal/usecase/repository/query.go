@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"strconv"
+	"strings"
+)
+
+// conditions collects WHERE conditions of a query together with their arguments, so values never get into
+// query text. Arguments are numbered after the first ones passed to newConditions
+type conditions struct {
+	conds []string
+	args  []interface{}
+}
+
+func newConditions(args ...interface{}) *conditions {
+	return &conditions{args: args}
+}
+
+// arg adds argument and returns its placeholder
+func (c *conditions) arg(v interface{}) string {
+	c.args = append(c.args, v)
+	return "$" + strconv.Itoa(len(c.args))
+}
+
+// where adds condition, every ? in it is replaced with placeholder of the next value
+func (c *conditions) where(cond string, vals ...interface{}) {
+	for _, v := range vals {
+		cond = strings.Replace(cond, "?", c.arg(v), 1)
+	}
+	c.conds = append(c.conds, cond)
+}
+
+// String returns WHERE clause, it is empty if there are no conditions
+func (c *conditions) String() string {
+	if len(c.conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(c.conds, " AND ") + "\n"
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"balance_api/internal/entity"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConditions(t *testing.T) {
+	q := newConditions(1)
+	require.Equal(t, "", q.String())
+
+	q.where("a = ?", "x")
+	q.where("(b, c) < (?, ?)", 2, 3)
+	q.where("d ? " + q.arg("key"))
+	require.Equal(t, "WHERE a = $2 AND (b, c) < ($3, $4) AND d ? $5\n", q.String())
+	require.Equal(t, []interface{}{1, "x", 2, 3, "key"}, q.args)
+}
+
+func TestQueryConstructor(t *testing.T) {
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	after := &entity.HistoryCursor{OrderBy: "sum", Desc: true, Sum: "100.00", Kind: entity.KindOrder, ID: 5}
+
+	type TestCase struct {
+		name    string
+		history entity.History
+		conds   []string
+		order   string
+		args    []interface{}
+	}
+
+	cases := []TestCase{{
+		name:    "no filters",
+		history: entity.History{UserID: 1, OrderBy: "date"},
+		order:   "ORDER BY created, kind, order_id\nLIMIT $2 OFFSET $3",
+		args:    []interface{}{1, nil, nil},
+	}, {
+		name:    "page",
+		history: entity.History{UserID: 1, OrderBy: "sum", Desc: true, Limit: 10, Page: 3},
+		order:   "ORDER BY order_sum DESC, kind DESC, order_id DESC\nLIMIT $2 OFFSET $3",
+		args:    []interface{}{1, 11, 20},
+	}, {
+		name:    "dates",
+		history: entity.History{UserID: 1, OrderBy: "date", From: from, To: to},
+		conds:   []string{"created >= $2", "created < $3"},
+		order:   "ORDER BY created, kind, order_id\nLIMIT $4 OFFSET $5",
+		args:    []interface{}{1, from, to, nil, nil},
+	}, {
+		name: "kinds, services and statuses",
+		history: entity.History{UserID: 1, OrderBy: "date", Kinds: []int{entity.KindOrder, entity.KindRefund},
+			ServiceIDs: []int{2, 3}, StatusIDs: []int{entity.StatusCanceled}},
+		conds: []string{"kind = ANY($2)", "service_id = ANY($3)", "status_id = ANY($4)"},
+		order: "ORDER BY created, kind, order_id\nLIMIT $5 OFFSET $6",
+		args: []interface{}{1, []int{entity.KindOrder, entity.KindRefund}, []int{2, 3},
+			[]int{entity.StatusCanceled}, nil, nil},
+	}, {
+		name:    "sums",
+		history: entity.History{UserID: 1, OrderBy: "date", MinSum: "10", MaxSum: "500.50"},
+		conds:   []string{"order_sum >= $2", "order_sum <= $3"},
+		order:   "ORDER BY created, kind, order_id\nLIMIT $4 OFFSET $5",
+		args:    []interface{}{1, "10", "500.50", nil, nil},
+	}, {
+		name:    "metadata key",
+		history: entity.History{UserID: 1, OrderBy: "date", MetadataKey: "'; DROP TABLE users; --"},
+		conds:   []string{"metadata ? $2"},
+		order:   "ORDER BY created, kind, order_id\nLIMIT $3 OFFSET $4",
+		args:    []interface{}{1, "'; DROP TABLE users; --", nil, nil},
+	}, {
+		name:    "metadata value",
+		history: entity.History{UserID: 1, OrderBy: "date", MetadataKey: "invoice", MetadataValue: "INV-42"},
+		conds:   []string{"metadata ->> $2 = $3"},
+		order:   "ORDER BY created, kind, order_id\nLIMIT $4 OFFSET $5",
+		args:    []interface{}{1, "invoice", "INV-42", nil, nil},
+	}, {
+		name: "filters with cursor",
+		history: entity.History{UserID: 1, OrderBy: "sum", Desc: true, Limit: 10, From: from,
+			Kinds: []int{entity.KindOrder}, MinSum: "10", After: after},
+		conds: []string{"created >= $2", "kind = ANY($3)", "order_sum >= $4",
+			"(order_sum, kind, order_id) < ($5, $6, $7)"},
+		order: "ORDER BY order_sum DESC, kind DESC, order_id DESC\nLIMIT $8 OFFSET $9",
+		args:  []interface{}{1, from, []int{entity.KindOrder}, "10", "100.00", entity.KindOrder, 5, 11, nil},
+	},
+	}
+
+	for _, tc := range cases {
+		query, args := queryConstructor(tc.history)
+		require.True(t, strings.HasPrefix(query, historySelect), tc.name)
+		where := ""
+		if len(tc.conds) > 0 {
+			where = "WHERE " + strings.Join(tc.conds, " AND ") + "\n"
+		}
+		require.Equal(t, where+tc.order, strings.TrimPrefix(query, historySelect), tc.name)
+		require.Equal(t, tc.args, args, tc.name)
+	}
+}
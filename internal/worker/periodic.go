@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"context"
+	"time"
+)
+
+// periodic runs job in background every interval until it is stopped
+type periodic struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startPeriodic starts running job, if now is set first run is made immediately, not after first interval
+func startPeriodic(interval time.Duration, now bool, job func(ctx context.Context)) *periodic {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &periodic{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.done)
+		if now {
+			job(ctx)
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				job(ctx)
+			}
+		}
+	}()
+
+	return p
+}
+
+// stop stops running and waits for current run to finish
+func (p *periodic) stop() {
+	p.cancel()
+	<-p.done
+}
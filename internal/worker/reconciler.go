@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"balance_api/internal/entity"
+	"balance_api/pkg/logger"
+	"context"
+	"time"
+)
+
+const defaultReconcileInterval = time.Hour
+
+// BalanceReconciler is an interface for model layer
+type BalanceReconciler interface {
+	Reconcile(ctx context.Context) (entity.Reconciliation, error)
+}
+
+// Reconciler periodically checks users' cached balances against the ledger and users' operations,
+// first check is made on start
+type Reconciler struct {
+	rc BalanceReconciler
+	l  logger.Interface
+	p  *periodic
+}
+
+// NewReconciler is a constructor for Reconciler, it starts reconciliation in background
+func NewReconciler(rc BalanceReconciler, l logger.Interface, interval time.Duration) *Reconciler {
+	if interval <= 0 {
+		interval = defaultReconcileInterval
+	}
+	r := &Reconciler{
+		rc: rc,
+		l:  l,
+	}
+	r.p = startPeriodic(interval, true, r.reconcile)
+	return r
+}
+
+// Shutdown stops reconciliation and waits for current one to finish
+func (r *Reconciler) Shutdown() {
+	r.p.stop()
+}
+
+func (r *Reconciler) reconcile(ctx context.Context) {
+	res, err := r.rc.Reconcile(ctx)
+	if err != nil {
+		r.l.Error(err)
+		return
+	}
+	for _, d := range res.Discrepancies {
+		r.l.Warnf("balance of user %d doesn't match: cached %s/%s, ledger %s/%s, operations %s/%s",
+			d.UserID, d.Amount, d.Reserved, d.LedgerAmount, d.LedgerReserved, d.OperationsAmount, d.OperationsReserved)
+	}
+	r.l.Infof("reconciliation checked %d users, found %d discrepancies", res.Users, len(res.Discrepancies))
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"balance_api/internal/entity"
+	"balance_api/pkg/logger"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type reconcilerStub struct {
+	calls int64
+}
+
+func (r *reconcilerStub) Reconcile(ctx context.Context) (entity.Reconciliation, error) {
+	if atomic.AddInt64(&r.calls, 1)%2 == 0 {
+		return entity.Reconciliation{}, errors.New("aboba")
+	}
+	return entity.Reconciliation{Users: 2, Discrepancies: []entity.BalanceCheck{{UserID: 1, Amount: "200.00",
+		LedgerAmount: "100.00", OperationsAmount: "200.00"}}}, nil
+}
+
+func TestReconciler(t *testing.T) {
+	rc := &reconcilerStub{}
+	l, _ := logger.New("error")
+	r := NewReconciler(rc, l, time.Hour)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&rc.calls) == 1
+	}, time.Second, time.Millisecond)
+
+	r.Shutdown()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&rc.calls))
+
+	rc = &reconcilerStub{}
+	r = NewReconciler(rc, l, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&rc.calls) >= 3
+	}, time.Second, time.Millisecond)
+	r.Shutdown()
+}
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"balance_api/internal/entity"
+	"balance_api/pkg/logger"
+	"context"
+	"time"
+)
+
+const defaultSweepInterval = time.Minute
+
+// Expirer is an interface for model layer
+type Expirer interface {
+	ExpireOrders(ctx context.Context) ([]entity.Order, error)
+	ExpireIdempotent(ctx context.Context) (int64, error)
+}
+
+// Sweeper periodically cancels pending orders which ttl is over and removes expired idempotency keys
+type Sweeper struct {
+	e Expirer
+	l logger.Interface
+	p *periodic
+}
+
+// NewSweeper is a constructor for Sweeper, it starts sweeping in background
+func NewSweeper(e Expirer, l logger.Interface, interval time.Duration) *Sweeper {
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+	s := &Sweeper{
+		e: e,
+		l: l,
+	}
+	s.p = startPeriodic(interval, false, s.sweep)
+	return s
+}
+
+// Shutdown stops sweeping and waits for current sweep to finish
+func (s *Sweeper) Shutdown() {
+	s.p.stop()
+}
+
+func (s *Sweeper) sweep(ctx context.Context) {
+	orders, err := s.e.ExpireOrders(ctx)
+	for _, o := range orders {
+		s.l.Infof("order %d of user %d expired, released %s", o.ID, o.UserID, o.Sum)
+	}
+	if err != nil {
+		s.l.Error(err)
+	}
+	n, err := s.e.ExpireIdempotent(ctx)
+	if n > 0 {
+		s.l.Infof("removed %d expired idempotency keys", n)
+	}
+	if err != nil {
+		s.l.Error(err)
+	}
+}
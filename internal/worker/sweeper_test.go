@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"balance_api/internal/entity"
+	"balance_api/pkg/logger"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type expirerStub struct {
+	calls     int64
+	idemCalls int64
+}
+
+func (e *expirerStub) ExpireOrders(ctx context.Context) ([]entity.Order, error) {
+	if atomic.AddInt64(&e.calls, 1)%2 == 0 {
+		return nil, errors.New("aboba")
+	}
+	return []entity.Order{{ID: 1, UserID: 1, Sum: "200", StatusID: entity.StatusExpired}}, nil
+}
+
+func (e *expirerStub) ExpireIdempotent(ctx context.Context) (int64, error) {
+	if atomic.AddInt64(&e.idemCalls, 1)%2 == 0 {
+		return 0, errors.New("aboba")
+	}
+	return 2, nil
+}
+
+func TestSweeper(t *testing.T) {
+	e := &expirerStub{}
+	l, _ := logger.New("error")
+	s := NewSweeper(e, l, time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&e.calls) >= 3 && atomic.LoadInt64(&e.idemCalls) >= 3
+	}, time.Second, time.Millisecond)
+
+	s.Shutdown()
+	calls := atomic.LoadInt64(&e.calls)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, calls, atomic.LoadInt64(&e.calls))
+}
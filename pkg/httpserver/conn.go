@@ -0,0 +1,25 @@
+package httpserver
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+type connCtxKey struct{}
+
+// connContext keeps connection of request in its context
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connCtxKey{}, c)
+}
+
+// SetWriteDeadline sets write deadline of the connection which request with ctx came from, so long responses
+// can outlive server's WriteTimeout. Zero t means no deadline. Returns false if ctx isn't a context of request
+// served by Server
+func SetWriteDeadline(ctx context.Context, t time.Time) bool {
+	c, ok := ctx.Value(connCtxKey{}).(net.Conn)
+	if !ok {
+		return false
+	}
+	return c.SetWriteDeadline(t) == nil
+}
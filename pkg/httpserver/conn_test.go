@@ -0,0 +1,60 @@
+package httpserver
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetWriteDeadline(t *testing.T) {
+	require.False(t, SetWriteDeadline(context.Background(), time.Time{}))
+
+	type testCases struct {
+		name     string
+		lift     bool
+		expError bool
+	}
+
+	cases := []testCases{{
+		name:     "response is cut off by write timeout",
+		lift:     false,
+		expError: true,
+	}, {
+		name:     "lifted deadline",
+		lift:     true,
+		expError: false,
+	},
+	}
+
+	for _, tc := range cases {
+		lift := tc.lift
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if lift {
+				require.True(t, SetWriteDeadline(r.Context(), time.Time{}))
+			}
+			_, _ = w.Write([]byte("first part\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			_, _ = w.Write([]byte("second part\n"))
+		}))
+		srv.Config.WriteTimeout = 100 * time.Millisecond
+		srv.Config.ConnContext = connContext
+		srv.Start()
+
+		resp, err := http.Get(srv.URL)
+		require.NoError(t, err, tc.name)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		srv.Close()
+		if tc.expError {
+			require.Error(t, err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		require.Equal(t, "first part\nsecond part\n", string(body), tc.name)
+	}
+}
@@ -0,0 +1,295 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const (
+	defaultTable  = "schema_migrations"
+	defaultLockID = 7_355_608
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a versioned change of db schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies migrations to db, concurrent runners wait for each other on advisory lock
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+	table      string
+	lockID     int64
+	// db having baselineTable without table of versions was created before migrations with baselineVersion schema
+	baselineVersion int
+	baselineTable   string
+}
+
+// New is a constructor for Migrator, migrations are read from the root of fsys
+func New(db *sqlx.DB, fsys fs.FS, opts ...Option) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	m := &Migrator{
+		db:         db,
+		migrations: migrations,
+		table:      defaultTable,
+		lockID:     defaultLockID,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m, nil
+}
+
+// Load reads migrations from the root of fsys and sorts them by version. Files are named
+// <version>_<name>.up.sql and <version>_<name>.down.sql, down file is optional
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("migrate - Load: %w", err)
+	}
+	byVersion := make(map[int]*Migration)
+	for _, f := range files {
+		parts := fileName.FindStringSubmatch(f)
+		if parts == nil {
+			return nil, fmt.Errorf("migrate - Load: wrong file name %s", f)
+		}
+		version, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("migrate - Load: wrong version in %s: %w", f, err)
+		}
+		b, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, fmt.Errorf("migrate - Load: %w", err)
+		}
+		m := byVersion[version]
+		switch {
+		case m == nil:
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		case m.Name != parts[2]:
+			return nil, fmt.Errorf("migrate - Load: version %d is used by %s and %s", version, m.Name, parts[2])
+		}
+		if parts[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrate - Load: no up migration for version %d", m.Version)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+// Up applies all not applied migrations in order of versions and returns them
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var res []Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if applied[mig.Version] {
+				continue
+			}
+			err = m.exec(ctx, conn, mig.Up,
+				fmt.Sprintf(`INSERT INTO %s (version, name) VALUES ($1, $2)`, m.table), mig.Version, mig.Name)
+			if err != nil {
+				return fmt.Errorf("version %d: %w", mig.Version, err)
+			}
+			res = append(res, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("migrate - Up: %w", err)
+	}
+	return res, nil
+}
+
+// Down reverts n last applied migrations and returns them
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	var res []Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(res) < n; i-- {
+			mig := m.migrations[i]
+			if !applied[mig.Version] {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("version %d: no down migration", mig.Version)
+			}
+			err = m.exec(ctx, conn, mig.Down,
+				fmt.Sprintf(`DELETE FROM %s WHERE version = $1`, m.table), mig.Version)
+			if err != nil {
+				return fmt.Errorf("version %d: %w", mig.Version, err)
+			}
+			res = append(res, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("migrate - Down: %w", err)
+	}
+	return res, nil
+}
+
+// Version returns the latest applied version, 0 if there is no one
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var version int
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		return conn.GetContext(ctx, &version, fmt.Sprintf(`SELECT COALESCE(max(version), 0) FROM %s`, m.table))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("migrate - Version: %w", err)
+	}
+	return version, nil
+}
+
+// Force marks migrations up to version as applied and later ones as not applied without running them.
+// It is used for db which schema was created before migrations or was fixed by hand
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		tx, err := conn.BeginTxx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s`, m.table))
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+			_, err = tx.ExecContext(ctx,
+				fmt.Sprintf(`INSERT INTO %s (version, name) VALUES ($1, $2)`, m.table), mig.Version, mig.Name)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return fmt.Errorf("migrate - Force: %w", err)
+	}
+	return nil
+}
+
+// withLock runs fn on a single connection holding advisory lock, table of versions is created if it doesn't exist
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sqlx.Conn) error) error {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, m.lockID)
+	if err != nil {
+		return err
+	}
+	// lock must be released even if ctx is canceled, otherwise it stays with connection returned to the pool
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, m.lockID)
+
+	err = m.createTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// createTable creates table of versions if it doesn't exist. Migrations up to baseline version are marked as
+// applied in new table if db already has baseline table
+func (m *Migrator) createTable(ctx context.Context, conn *sqlx.Conn) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var exists bool
+	err = tx.GetContext(ctx, &exists, `SELECT to_regclass($1) IS NOT NULL`, m.table)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %s (
+							version INTEGER PRIMARY KEY,
+							name VARCHAR(255) NOT NULL,
+							applied TIMESTAMPTZ NOT NULL DEFAULT now()
+						)`, m.table))
+	if err != nil {
+		return err
+	}
+	if m.baselineTable != "" {
+		err = tx.GetContext(ctx, &exists, `SELECT to_regclass($1) IS NOT NULL`, m.baselineTable)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if !exists || mig.Version > m.baselineVersion {
+				break
+			}
+			_, err = tx.ExecContext(ctx,
+				fmt.Sprintf(`INSERT INTO %s (version, name) VALUES ($1, $2)`, m.table), mig.Version, mig.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sqlx.Conn) (map[int]bool, error) {
+	var versions []int
+	err := conn.SelectContext(ctx, &versions, fmt.Sprintf(`SELECT version FROM %s`, m.table))
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int]bool, len(versions))
+	for _, v := range versions {
+		res[v] = true
+	}
+	return res, nil
+}
+
+// exec runs migration and changes table of versions in one transaction
+func (m *Migrator) exec(ctx context.Context, conn *sqlx.Conn, migration, query string, args ...interface{}) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, migration)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
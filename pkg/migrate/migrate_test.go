@@ -0,0 +1,142 @@
+package migrate
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+func file(s string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(s)}
+}
+
+func TestLoad(t *testing.T) {
+	type TestCase struct {
+		name        string
+		fsys        fstest.MapFS
+		expected    []Migration
+		expectedErr bool
+	}
+
+	cases := []TestCase{{
+		name: "valid",
+		fsys: fstest.MapFS{
+			"0002_B.up.sql":   file("up b"),
+			"0001_A.up.sql":   file("up a"),
+			"0001_A.down.sql": file("down a"),
+			"schema.go":       file("package schema"),
+		},
+		expected: []Migration{
+			{Version: 1, Name: "A", Up: "up a", Down: "down a"},
+			{Version: 2, Name: "B", Up: "up b"},
+		},
+	}, {
+		name:        "wrong name",
+		fsys:        fstest.MapFS{"A.up.sql": file("up a")},
+		expectedErr: true,
+	}, {
+		name:        "no up",
+		fsys:        fstest.MapFS{"0001_A.down.sql": file("down a")},
+		expectedErr: true,
+	}, {
+		name:        "same version",
+		fsys:        fstest.MapFS{"0001_A.up.sql": file("up a"), "0001_B.up.sql": file("up b")},
+		expectedErr: true,
+	},
+	}
+
+	for _, tc := range cases {
+		res, err := Load(tc.fsys)
+		if tc.expectedErr {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expected, res, tc.name)
+	}
+}
+
+// TestMigrator needs db from TEST_DB_URI env, it is skipped if it is not set
+func TestMigrator(t *testing.T) {
+	uri := os.Getenv("TEST_DB_URI")
+	if uri == "" {
+		t.Skip("TEST_DB_URI is not set")
+	}
+	ctx := context.Background()
+	db, err := sqlx.Connect("pgx", uri)
+	require.NoError(t, err)
+	defer db.Close()
+
+	cleanup := func() {
+		for _, q := range []string{
+			`DROP TABLE IF EXISTS migrate_test_a`,
+			`DROP TABLE IF EXISTS migrate_test_b`,
+			`DROP TABLE IF EXISTS migrate_test_versions`,
+			`DROP TABLE IF EXISTS migrate_test_baseline`,
+		} {
+			_, err := db.Exec(q)
+			require.NoError(t, err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	m, err := New(db, fstest.MapFS{
+		"0001_A.up.sql":   file("CREATE TABLE migrate_test_a (id INTEGER)"),
+		"0001_A.down.sql": file("DROP TABLE migrate_test_a"),
+		"0002_B.up.sql":   file("CREATE TABLE migrate_test_b (id INTEGER); INSERT INTO migrate_test_b VALUES (1)"),
+		"0002_B.down.sql": file("DROP TABLE migrate_test_b"),
+	}, Table("migrate_test_versions"), LockID(1))
+	require.NoError(t, err)
+
+	applied, err := m.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	applied, err = m.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, applied, 0)
+	version, err := m.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+
+	reverted, err := m.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, reverted, 1)
+	require.Equal(t, 2, reverted[0].Version)
+	version, err = m.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+
+	require.NoError(t, m.Force(ctx, 2))
+	version, err = m.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+	_, err = db.Exec(`CREATE TABLE migrate_test_b (id INTEGER)`)
+	require.NoError(t, err)
+
+	reverted, err = m.Down(ctx, 5)
+	require.NoError(t, err)
+	require.Len(t, reverted, 2)
+	version, err = m.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+
+	// db created before migrations has the first version of schema
+	_, err = db.Exec(`CREATE TABLE migrate_test_a (id INTEGER)`)
+	require.NoError(t, err)
+	m, err = New(db, fstest.MapFS{
+		"0001_A.up.sql": file("CREATE TABLE migrate_test_a (id INTEGER)"),
+		"0002_B.up.sql": file("CREATE TABLE migrate_test_b (id INTEGER)"),
+	}, Table("migrate_test_baseline"), LockID(1), Baseline(1, "migrate_test_a"))
+	require.NoError(t, err)
+	applied, err = m.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+	require.Equal(t, 2, applied[0].Version)
+}
@@ -0,0 +1,28 @@
+package migrate
+
+// Option is a type of functions-setters
+type Option func(*Migrator)
+
+// Table sets up name of the table where applied versions are kept
+func Table(name string) Option {
+	return func(m *Migrator) {
+		if name != "" {
+			m.table = name
+		}
+	}
+}
+
+// LockID sets up key of advisory lock which is held while migrations are run
+func LockID(id int64) Option {
+	return func(m *Migrator) {
+		m.lockID = id
+	}
+}
+
+// Baseline sets up version of schema which db created before migrations has, such db is recognized by table
+// existing without table of versions
+func Baseline(version int, table string) Option {
+	return func(m *Migrator) {
+		m.baselineVersion, m.baselineTable = version, table
+	}
+}
@@ -1,5 +1,7 @@
 package postgres
 
+import "time"
+
 // Option is a type of functions-setters
 type Option func(*Db)
 
@@ -11,3 +13,28 @@ func MaxConn(mc int) Option {
 		}
 	}
 }
+
+// MaxRetries sets up how many times transaction is retried on serialization failure
+func MaxRetries(n int) Option {
+	return func(db *Db) {
+		if n > 0 {
+			db.maxRetries = n
+		}
+	}
+}
+
+// RetryBackoff sets up base delay between transaction retries
+func RetryBackoff(d time.Duration) Option {
+	return func(db *Db) {
+		if d > 0 {
+			db.retryBackoff = d
+		}
+	}
+}
+
+// OnRetry sets up callback which is called before every transaction retry
+func OnRetry(f func(attempt int, err error)) Option {
+	return func(db *Db) {
+		db.onRetry = f
+	}
+}
@@ -3,16 +3,23 @@ package postgres
 import (
 	_ "github.com/jackc/pgx/v5/stdlib" // needs for connection via sqlx
 	"github.com/jmoiron/sqlx"
+	"time"
 )
 
 const (
-	defaultMaxConns = 5
+	defaultMaxConns     = 5
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 10 * time.Millisecond
 )
 
 // Db keeps pool of connections to db
 type Db struct {
-	maxConns int
-	Pool     *sqlx.DB
+	maxConns     int
+	maxRetries   int
+	retryBackoff time.Duration
+	onRetry      func(attempt int, err error)
+	stats        TxStats
+	Pool         *sqlx.DB
 }
 
 // New is constructor for Db
@@ -22,7 +29,9 @@ func New(uri string, opts ...Option) (*Db, error) {
 		return nil, err
 	}
 	pg := &Db{Pool: c,
-		maxConns: defaultMaxConns}
+		maxConns:     defaultMaxConns,
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: defaultRetryBackoff}
 	for _, opt := range opts {
 		opt(pg)
 	}
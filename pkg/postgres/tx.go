@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// TxStats keeps counters of transaction retries
+type TxStats struct {
+	Retries   int64
+	Exhausted int64
+}
+
+// RunInTx runs fn inside a transaction and commits it. Transaction is retried with jittered backoff on
+// serialization failures and deadlocks, so fn must be safe to run several times. Errors of fn are returned as is
+func (db *Db) RunInTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *sqlx.Tx) error) error {
+	return db.retry(ctx, func() error {
+		tx, err := db.Pool.BeginTxx(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("postgres - RunInTx: %w", err)
+		}
+		defer tx.Rollback()
+		err = fn(tx)
+		if err != nil {
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return fmt.Errorf("postgres - RunInTx: %w", err)
+		}
+		return nil
+	})
+}
+
+// Stats returns transaction retries counters
+func (db *Db) Stats() TxStats {
+	return TxStats{
+		Retries:   atomic.LoadInt64(&db.stats.Retries),
+		Exhausted: atomic.LoadInt64(&db.stats.Exhausted),
+	}
+}
+
+func (db *Db) retry(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !isRetryable(err) {
+			return err
+		}
+		if attempt > db.maxRetries {
+			atomic.AddInt64(&db.stats.Exhausted, 1)
+			return err
+		}
+		atomic.AddInt64(&db.stats.Retries, 1)
+		if db.onRetry != nil {
+			db.onRetry(attempt, err)
+		}
+		t := time.NewTimer(db.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// backoff doubles base delay with every attempt and picks random duration from its upper half
+func (db *Db) backoff(attempt int) time.Duration {
+	d := db.retryBackoff << (attempt - 1)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == serializationFailure || pgErr.Code == deadlockDetected
+}
@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	serialization := fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: serializationFailure})
+	deadlock := &pgconn.PgError{Code: deadlockDetected}
+	other := errors.New("aboba")
+
+	type TestCase struct {
+		name          string
+		errs          []error
+		expectedErr   error
+		expectedCalls int
+		expectedStats TxStats
+	}
+
+	cases := []TestCase{{
+		name:          "success",
+		errs:          []error{nil},
+		expectedErr:   nil,
+		expectedCalls: 1,
+	}, {
+		name:          "not retryable",
+		errs:          []error{other},
+		expectedErr:   other,
+		expectedCalls: 1,
+	}, {
+		name:          "retried",
+		errs:          []error{serialization, deadlock, nil},
+		expectedErr:   nil,
+		expectedCalls: 3,
+		expectedStats: TxStats{Retries: 2},
+	}, {
+		name:          "exhausted",
+		errs:          []error{serialization, serialization, serialization, serialization},
+		expectedErr:   serialization,
+		expectedCalls: 3,
+		expectedStats: TxStats{Retries: 2, Exhausted: 1},
+	},
+	}
+
+	for _, tc := range cases {
+		var attempts []int
+		db := &Db{maxRetries: 2, retryBackoff: time.Microsecond,
+			onRetry: func(attempt int, err error) { attempts = append(attempts, attempt) }}
+		calls := 0
+		err := db.retry(context.Background(), func() error {
+			calls++
+			return tc.errs[calls-1]
+		})
+		assert.Equal(t, tc.expectedErr, err, tc.name)
+		assert.Equal(t, tc.expectedCalls, calls, tc.name)
+		assert.Equal(t, tc.expectedStats, db.Stats(), tc.name)
+		assert.Equal(t, int(tc.expectedStats.Retries), len(attempts), tc.name)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	db := &Db{retryBackoff: 10 * time.Millisecond}
+	for attempt := 1; attempt <= 3; attempt++ {
+		base := db.retryBackoff << (attempt - 1)
+		for i := 0; i < 100; i++ {
+			d := db.backoff(attempt)
+			assert.GreaterOrEqual(t, d, base/2)
+			assert.LessOrEqual(t, d, base)
+		}
+	}
+}
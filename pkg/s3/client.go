@@ -0,0 +1,130 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRegion  = "us-east-1"
+	defaultTimeout = time.Minute
+)
+
+// Error is an error response of storage
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Client is a client of one bucket of S3-compatible storage, objects are addressed in path style:
+// endpoint/bucket/key, as MinIO and most of other storages support it
+type Client struct {
+	endpoint *url.URL
+	public   *url.URL
+	bucket   string
+	signer   signer
+	http     *http.Client
+	now      func() time.Time
+}
+
+// New is constructor for Client
+func New(endpoint, bucket, accessKey, secretKey string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("s3: endpoint %q has no scheme or host", endpoint)
+	}
+	if bucket == "" {
+		return nil, errors.New("s3: empty bucket")
+	}
+	c := &Client{endpoint: u, public: u, bucket: bucket,
+		signer: signer{accessKey: accessKey, secretKey: secretKey, region: defaultRegion},
+		http:   &http.Client{Timeout: defaultTimeout},
+		now:    time.Now}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// CreateBucket creates bucket if it doesn't exist yet
+func (c *Client) CreateBucket(ctx context.Context) error {
+	err := c.do(ctx, http.MethodHead, "", "", nil)
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusNotFound {
+		return err
+	}
+	err = c.do(ctx, http.MethodPut, "", "", nil)
+	// bucket is created by other instance at the same time
+	if errors.As(err, &e) && e.Code == "BucketAlreadyOwnedByYou" {
+		return nil
+	}
+	return err
+}
+
+// PutObject uploads object, existing object with the same key is replaced
+func (c *Client) PutObject(ctx context.Context, key, contentType string, body []byte) error {
+	return c.do(ctx, http.MethodPut, key, contentType, body)
+}
+
+// PresignGetObject returns url which allows to download object without credentials during expires
+func (c *Client) PresignGetObject(key string, expires time.Duration) string {
+	u := c.objectURL(c.public, key)
+	c.signer.presign(http.MethodGet, u, c.now(), expires)
+	return u.String()
+}
+
+func (c *Client) objectURL(endpoint *url.URL, key string) *url.URL {
+	u := *endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = canonicalURI(&u)
+	return &u
+}
+
+// do sends signed request to bucket or its object, error response is returned as *Error
+func (c *Client) do(ctx context.Context, method, key, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(c.endpoint, key).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	c.signer.sign(req, hashHex(body), c.now())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	e := &Error{StatusCode: resp.StatusCode}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err == nil && len(b) > 0 {
+		_ = xml.Unmarshal(b, e)
+	}
+	if e.Code == "" {
+		e.Code = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
@@ -0,0 +1,124 @@
+package s3
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage is a minimal in-memory storage of one bucket, it checks only presence of signatures
+type fakeStorage struct {
+	mu      sync.Mutex
+	bucket  bool
+	objects map[string]string
+	types   map[string]string
+}
+
+func (s *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), algorithm) && r.URL.Query().Get("X-Amz-Signature") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/reports")
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		if !s.bucket {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case key == "" && r.Method == http.MethodPut:
+		s.bucket = true
+	case !s.bucket:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(w, "<Error><Code>NoSuchBucket</Code><Message>no bucket</Message></Error>")
+	case r.Method == http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		s.objects[key], s.types[key] = string(b), r.Header.Get("Content-Type")
+	case r.Method == http.MethodGet:
+		b, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", s.types[key])
+		_, _ = io.WriteString(w, b)
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeStorage{objects: map[string]string{}, types: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	c, err := New(srv.URL, "reports", "key", "secret")
+	require.NoError(t, err)
+	err = c.PutObject(ctx, "2022-10.csv", "text/csv", []byte("a,b"))
+	var e *Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, "NoSuchBucket", e.Code)
+
+	// bucket is created once, next calls see it exists
+	require.NoError(t, c.CreateBucket(ctx))
+	require.NoError(t, c.CreateBucket(ctx))
+	require.NoError(t, c.PutObject(ctx, "2022-10.csv", "text/csv", []byte("a,b")))
+	assert.Equal(t, "a,b", fake.objects["/2022-10.csv"])
+
+	resp, err := http.Get(c.PresignGetObject("2022-10.csv", time.Hour))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "a,b", string(b))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("localhost:9000", "reports", "key", "secret")
+	assert.Error(t, err)
+	_, err = New("http://localhost:9000", "", "key", "secret")
+	assert.Error(t, err)
+
+	c, err := New("http://minio:9000", "reports", "key", "secret", Region("eu-central-1"),
+		PublicEndpoint("https://files.example.com/s3/"))
+	require.NoError(t, err)
+	c.now = func() time.Time { return exampleTime }
+	u := c.PresignGetObject("2022-10.csv", time.Hour)
+	assert.True(t, strings.HasPrefix(u, "https://files.example.com/s3/reports/2022-10.csv?"), u)
+	assert.Contains(t, u, "X-Amz-Credential=key%2F20130524%2Feu-central-1%2Fs3%2Faws4_request")
+	assert.Contains(t, u, "X-Amz-Expires=3600")
+}
+
+// TestMinIO runs against real storage from TEST_S3_ENDPOINT env, e.g. MinIO of docker-compose,
+// it is skipped if env is not set
+func TestMinIO(t *testing.T) {
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT is not set")
+	}
+	ctx := context.Background()
+	c, err := New(endpoint, "test-reports", os.Getenv("TEST_S3_ACCESS_KEY"), os.Getenv("TEST_S3_SECRET_KEY"))
+	require.NoError(t, err)
+	require.NoError(t, c.CreateBucket(ctx))
+	require.NoError(t, c.CreateBucket(ctx))
+	require.NoError(t, c.PutObject(ctx, "2022-10 report.csv", "text/csv", []byte("a,b")))
+
+	resp, err := http.Get(c.PresignGetObject("2022-10 report.csv", time.Minute))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "a,b", string(b))
+}
@@ -0,0 +1,36 @@
+package s3
+
+import (
+	"net/url"
+	"time"
+)
+
+// Option is a type of functions-setters
+type Option func(*Client)
+
+// Region sets up region of bucket, it's a part of signature
+func Region(r string) Option {
+	return func(c *Client) {
+		if r != "" {
+			c.signer.region = r
+		}
+	}
+}
+
+// PublicEndpoint sets up endpoint of pre-signed urls, when clients reach storage by other address than api
+func PublicEndpoint(endpoint string) Option {
+	return func(c *Client) {
+		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+			c.public = u
+		}
+	}
+}
+
+// Timeout sets up timeout of requests to storage
+func Timeout(d time.Duration) Option {
+	return func(c *Client) {
+		if d > 0 {
+			c.http.Timeout = d
+		}
+	}
+}
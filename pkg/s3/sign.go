@@ -0,0 +1,145 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm       = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// emptyPayload is a sha256 of empty body
+var emptyPayload = hashHex(nil)
+
+// signer signs requests to s3 with AWS signature version 4
+type signer struct {
+	accessKey string
+	secretKey string
+	region    string
+}
+
+// sign sets date, payload hash and authorization headers of request, host and all set headers are signed
+func (s signer) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, k := range names {
+		canonical.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{req.Method, canonicalURI(req.URL), canonicalQuery(req.URL.Query()),
+		canonical.String(), signed, payloadHash}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, s.accessKey, s.scope(t), signed, s.signature(t, request)))
+}
+
+// presign adds to url query parameters which authorize request of method with only host header
+// during expires from t
+func (s signer) presign(method string, u *url.URL, t time.Time, expires time.Duration) {
+	t = t.UTC()
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", algorithm)
+	q.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(t))
+	q.Set("X-Amz-Date", t.Format(amzDateFormat))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+
+	request := strings.Join([]string{method, canonicalURI(u), canonicalQuery(q), "host:" + u.Host + "\n", "host",
+		unsignedPayload}, "\n")
+	q.Set("X-Amz-Signature", s.signature(t, request))
+	u.RawQuery = canonicalQuery(q)
+}
+
+func (s signer) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature signs canonical request by key derived from secret key, date and region
+func (s signer) signature(t time.Time, canonicalRequest string) string {
+	toSign := strings.Join([]string{algorithm, t.Format(amzDateFormat), s.scope(t),
+		hashHex([]byte(canonicalRequest))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), t.Format("20060102"))
+	for _, v := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// canonicalURI encodes every segment of url path, slashes are kept as they are
+func canonicalURI(u *url.URL) string {
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, v := range segments {
+		segments[i] = uriEncode(v)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes query parameters sorted by name
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var res []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			res = append(res, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(res, "&")
+}
+
+// uriEncode percent-encodes everything except unreserved characters of RFC 3986
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
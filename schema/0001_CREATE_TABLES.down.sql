@@ -0,0 +1,5 @@
+DROP TABLE orders;
+DROP TABLE replenishments;
+DROP TABLE services;
+DROP TABLE status;
+DROP TABLE users;
@@ -0,0 +1,8 @@
+CREATE TABLE idempotency_keys (
+    idem_key VARCHAR(255) PRIMARY KEY,
+    fingerprint CHAR(64) NOT NULL,
+    completed BOOLEAN NOT NULL DEFAULT false,
+    response_code INTEGER NOT NULL DEFAULT 0,
+    response_body BYTEA,
+    created TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,14 @@
+CREATE TABLE transfers (
+    id SERIAL PRIMARY KEY,
+    from_user_id INTEGER NOT NULL,
+    to_user_id INTEGER NOT NULL,
+    amount DECIMAL(18,2) CHECK ( amount > 0 ) NOT NULL,
+    comment VARCHAR(255) NOT NULL DEFAULT '',
+    created TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK ( from_user_id <> to_user_id ),
+    FOREIGN KEY (from_user_id) REFERENCES users (user_id),
+    FOREIGN KEY (to_user_id) REFERENCES users (user_id)
+);
+
+CREATE INDEX transfers_from_user_id_idx ON transfers (from_user_id);
+CREATE INDEX transfers_to_user_id_idx ON transfers (to_user_id);
@@ -0,0 +1,7 @@
+DROP TABLE refunds;
+
+ALTER TABLE orders DROP COLUMN refunded;
+
+UPDATE orders SET status_id = 2 WHERE status_id IN (4, 5);
+
+DELETE FROM status WHERE status_id IN (4, 5);
@@ -0,0 +1,18 @@
+INSERT INTO status (status_id, status_name) VALUES
+    (4, 'Refunded'),
+    (5, 'Partially refunded');
+
+ALTER TABLE orders ADD COLUMN refunded DECIMAL(18,2) CHECK ( refunded >= 0 ) NOT NULL DEFAULT 0.00;
+
+CREATE TABLE refunds (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    amount DECIMAL(18,2) CHECK ( amount > 0 ) NOT NULL,
+    created TIMESTAMPTZ NOT NULL DEFAULT now(),
+    FOREIGN KEY (order_id) REFERENCES orders (order_id),
+    FOREIGN KEY (user_id) REFERENCES users (user_id)
+);
+
+CREATE INDEX refunds_order_id_idx ON refunds (order_id);
+CREATE INDEX refunds_user_id_idx ON refunds (user_id);
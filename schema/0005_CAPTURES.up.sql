@@ -0,0 +1,3 @@
+ALTER TABLE orders ADD COLUMN captured DECIMAL(18,2) CHECK ( captured >= 0 );
+
+UPDATE orders SET captured = order_sum WHERE status_id IN (2, 4, 5);
@@ -0,0 +1,7 @@
+DROP INDEX orders_pending_expires_idx;
+
+ALTER TABLE orders DROP COLUMN expires;
+
+UPDATE orders SET status_id = 3 WHERE status_id = 6;
+
+DELETE FROM status WHERE status_id = 6;
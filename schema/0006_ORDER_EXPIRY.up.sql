@@ -0,0 +1,6 @@
+INSERT INTO status (status_id, status_name) VALUES
+    (6, 'Expired');
+
+ALTER TABLE orders ADD COLUMN expires TIMESTAMPTZ;
+
+CREATE INDEX orders_pending_expires_idx ON orders (expires) WHERE status_id = 1;
@@ -0,0 +1,2 @@
+DROP INDEX orders_user_id_idx;
+DROP INDEX replenishments_user_id_idx;
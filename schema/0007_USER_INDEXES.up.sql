@@ -0,0 +1,2 @@
+CREATE INDEX orders_user_id_idx ON orders (user_id, created);
+CREATE INDEX replenishments_user_id_idx ON replenishments (user_id, created);
@@ -0,0 +1,53 @@
+-- Every entry moves amount from credit account to debit account, so balance of an account is a sum of its debits
+-- minus a sum of its credits. Accounts are
+--   user:<user_id>:available, user:<user_id>:reserved - users' money, cached in users.amount and users.reserved
+--   revenue:<service_id> - captured money of orders
+--   external:funding - source of replenishments
+CREATE TABLE ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    tx_id BIGINT NOT NULL DEFAULT txid_current(),
+    operation VARCHAR(32) NOT NULL,
+    ref_id INTEGER NOT NULL,
+    debit VARCHAR(64) NOT NULL,
+    credit VARCHAR(64) NOT NULL,
+    amount DECIMAL(18,2) CHECK ( amount > 0 ) NOT NULL,
+    created TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK ( debit <> credit )
+);
+
+CREATE INDEX ledger_entries_debit_idx ON ledger_entries (debit, created);
+CREATE INDEX ledger_entries_credit_idx ON ledger_entries (credit, created);
+CREATE INDEX ledger_entries_ref_idx ON ledger_entries (operation, ref_id);
+
+-- entries of operations made before the ledger
+INSERT INTO ledger_entries (operation, ref_id, debit, credit, amount, created)
+SELECT operation, ref_id, debit, credit, amount, created FROM (
+    SELECT 'replenishment' AS operation, id AS ref_id, 'user:' || user_id || ':available' AS debit,
+           'external:funding' AS credit, amount, created
+    FROM replenishments
+    UNION ALL
+    SELECT 'order', order_id, 'user:' || user_id || ':reserved', 'user:' || user_id || ':available',
+           order_sum, created
+    FROM orders
+    UNION ALL
+    SELECT 'approve', order_id, 'revenue:' || service_id, 'user:' || user_id || ':reserved', captured, modified
+    FROM orders WHERE status_id IN (2, 4, 5)
+    UNION ALL
+    SELECT 'approve', order_id, 'user:' || user_id || ':available', 'user:' || user_id || ':reserved',
+           order_sum - captured, modified
+    FROM orders WHERE status_id IN (2, 4, 5)
+    UNION ALL
+    SELECT CASE status_id WHEN 3 THEN 'cancel' ELSE 'expire' END, order_id, 'user:' || user_id || ':available',
+           'user:' || user_id || ':reserved', order_sum, modified
+    FROM orders WHERE status_id IN (3, 6)
+    UNION ALL
+    SELECT 'refund', r.id, 'user:' || r.user_id || ':available', 'revenue:' || o.service_id, r.amount,
+           r.created
+    FROM refunds AS r JOIN orders AS o ON o.order_id = r.order_id
+    UNION ALL
+    SELECT 'transfer', id, 'user:' || to_user_id || ':available', 'user:' || from_user_id || ':available',
+           amount, created
+    FROM transfers
+) AS e
+WHERE amount > 0
+ORDER BY created;
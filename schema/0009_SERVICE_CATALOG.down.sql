@@ -0,0 +1,3 @@
+DROP INDEX services_service_name_idx;
+
+ALTER TABLE services DROP COLUMN active;
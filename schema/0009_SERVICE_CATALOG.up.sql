@@ -0,0 +1,6 @@
+ALTER TABLE services ADD COLUMN active BOOLEAN NOT NULL DEFAULT true;
+
+CREATE UNIQUE INDEX services_service_name_idx ON services (service_name);
+
+-- seeded services have explicit ids, so new ones have to start after them
+SELECT setval('services_service_id_seq', (SELECT COALESCE(max(service_id), 0) + 1 FROM services), false);
@@ -0,0 +1 @@
+CREATE INDEX orders_created_idx ON orders (created, order_id);
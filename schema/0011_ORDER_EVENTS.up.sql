@@ -0,0 +1,40 @@
+-- Every status change of an order is written in the same transaction as the change itself. from_status_id is
+-- NULL for order creation, amount is order sum on creation, cancel and expiry, captured sum on approval and
+-- refunded amount on refund
+CREATE TABLE order_events (
+    id BIGSERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL,
+    from_status_id INTEGER,
+    to_status_id INTEGER NOT NULL,
+    amount DECIMAL(18,2) CHECK ( amount >= 0 ) NOT NULL,
+    actor VARCHAR(64) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    created TIMESTAMPTZ NOT NULL DEFAULT now(),
+    FOREIGN KEY (order_id) REFERENCES orders (order_id),
+    FOREIGN KEY (from_status_id) REFERENCES status (status_id),
+    FOREIGN KEY (to_status_id) REFERENCES status (status_id)
+);
+
+CREATE INDEX order_events_order_id_idx ON order_events (order_id, created);
+
+-- events of orders made before the audit trail, actor of such changes is unknown
+INSERT INTO order_events (order_id, from_status_id, to_status_id, amount, actor, created)
+SELECT order_id, from_status_id, to_status_id, amount, 'unknown', created FROM (
+    SELECT order_id, NULL::INTEGER AS from_status_id, 1 AS to_status_id, order_sum AS amount, created
+    FROM orders
+    UNION ALL
+    SELECT order_id, 1, 2, captured, modified
+    FROM orders WHERE status_id IN (2, 4, 5)
+    UNION ALL
+    SELECT order_id, 1, status_id, order_sum, modified
+    FROM orders WHERE status_id IN (3, 6)
+    UNION ALL
+    SELECT r.order_id,
+           CASE WHEN row_number() OVER w = 1 THEN 2 ELSE 5 END,
+           CASE WHEN o.status_id = 4 AND row_number() OVER w = count(*) OVER (PARTITION BY r.order_id) THEN 4
+                ELSE 5 END,
+           r.amount, r.created
+    FROM refunds AS r JOIN orders AS o ON o.order_id = r.order_id
+    WINDOW w AS (PARTITION BY r.order_id ORDER BY r.created, r.id)
+) AS e
+ORDER BY created;
@@ -0,0 +1,8 @@
+DROP INDEX replenishments_metadata_idx;
+DROP INDEX orders_metadata_idx;
+
+ALTER TABLE replenishments DROP COLUMN metadata;
+ALTER TABLE replenishments DROP COLUMN comment;
+
+ALTER TABLE orders DROP COLUMN metadata;
+ALTER TABLE orders DROP COLUMN comment;
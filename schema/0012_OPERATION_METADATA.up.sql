@@ -0,0 +1,9 @@
+ALTER TABLE orders ADD COLUMN comment VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}' CHECK ( jsonb_typeof(metadata) = 'object' );
+
+ALTER TABLE replenishments ADD COLUMN comment VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE replenishments ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}' CHECK ( jsonb_typeof(metadata) = 'object' );
+
+-- history is searched by metadata keys
+CREATE INDEX orders_metadata_idx ON orders USING gin (metadata);
+CREATE INDEX replenishments_metadata_idx ON replenishments USING gin (metadata);
@@ -0,0 +1,22 @@
+-- Reports generated in background. Days from and to are taken in time_zone, file_name is set when job is done,
+-- error when it is failed. Running jobs started too long ago are taken by workers again
+CREATE TABLE report_jobs (
+    job_id SERIAL PRIMARY KEY,
+    date_from DATE NOT NULL,
+    date_to DATE NOT NULL,
+    time_zone VARCHAR(64) NOT NULL,
+    breakdown VARCHAR(8) NOT NULL DEFAULT '',
+    by_service BOOLEAN NOT NULL,
+    by_user BOOLEAN NOT NULL,
+    format VARCHAR(8) NOT NULL,
+    status VARCHAR(8) NOT NULL DEFAULT 'queued',
+    progress INTEGER NOT NULL DEFAULT 0 CHECK ( progress BETWEEN 0 AND 100 ),
+    file_name VARCHAR(255) NOT NULL DEFAULT '',
+    error VARCHAR(255) NOT NULL DEFAULT '',
+    created TIMESTAMPTZ NOT NULL DEFAULT now(),
+    started TIMESTAMPTZ,
+    finished TIMESTAMPTZ
+);
+
+-- workers look for unfinished jobs in order of creation
+CREATE INDEX report_jobs_unfinished_idx ON report_jobs (job_id) WHERE status IN ('queued', 'running');
@@ -0,0 +1,12 @@
+-- Report files kept in storage, only files listed here are served. Regenerated report replaces its row
+CREATE TABLE report_files (
+    file_name VARCHAR(255) PRIMARY KEY,
+    period VARCHAR(32) NOT NULL,
+    time_zone VARCHAR(64) NOT NULL,
+    format VARCHAR(8) NOT NULL,
+    size BIGINT NOT NULL CHECK ( size >= 0 ),
+    checksum CHAR(64) NOT NULL,
+    created TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX report_files_created_idx ON report_files (created);
@@ -0,0 +1,2 @@
+-- created is a start of the lease of in-progress key and is used to remove keys older than their ttl
+CREATE INDEX idempotency_keys_created_idx ON idempotency_keys (created);
@@ -0,0 +1,2 @@
+-- heartbeat is updated by the worker generating the job, running job without it for too long is taken again
+ALTER TABLE report_jobs ADD COLUMN heartbeat TIMESTAMPTZ;
@@ -0,0 +1,10 @@
+-- Results of reconciliation runs of the app and of cmd/reconcile, discrepancies are a json array of balance checks
+CREATE TABLE reconciliations (
+    reconciliation_id SERIAL PRIMARY KEY,
+    started TIMESTAMPTZ NOT NULL,
+    finished TIMESTAMPTZ NOT NULL,
+    users INTEGER NOT NULL,
+    discrepancies JSONB NOT NULL
+);
+
+CREATE INDEX reconciliations_finished_idx ON reconciliations (finished);
@@ -0,0 +1,16 @@
+// Package schema keeps db migrations, they are embedded into the binary. Migration files are named
+// <version>_<name>.up.sql and <version>_<name>.down.sql
+package schema
+
+import "embed"
+
+// Migrations -.
+//
+//go:embed *.sql
+var Migrations embed.FS
+
+// Db created by the old docker init script before migrations has only the first version of schema with users table
+const (
+	BaselineVersion = 1
+	BaselineTable   = "users"
+)